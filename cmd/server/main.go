@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -17,23 +19,87 @@ import (
 	"wechatoarss/internal/handler"
 	"wechatoarss/internal/service"
 	"wechatoarss/internal/store"
+	"wechatoarss/internal/store/storetest"
 )
 
 func main() {
+	migrate := flag.Bool("migrate", false, "apply pending database migrations and exit")
+	migrateStatus := flag.Bool("migrate-status", false, "list applied and pending database migrations and exit")
+	copyTo := flag.String("copy-to-postgres", "", "copy the SQLite database into the empty PostgreSQL database at this DSN and exit")
+	checkStore := flag.Bool("check-store", false, "run the storage contract checks against the configured database, which must be empty, and exit")
+	backupTo := flag.String("backup", "", "write a verified backup of the SQLite database to this file or directory and exit")
+	restoreFrom := flag.String("restore", "", "replace the SQLite database with this backup once it passes an integrity check, and exit; stop the server first")
+	flag.Parse()
+
 	// Load configuration
 	if err := config.Load(); err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	if *migrate || *migrateStatus {
+		if err := runMigrations(*migrate); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+	if *backupTo != "" {
+		if err := runBackup(*backupTo); err != nil {
+			log.Fatalf("Backup failed: %v", err)
+		}
+		return
+	}
+	if *restoreFrom != "" {
+		if err := runRestore(*restoreFrom); err != nil {
+			log.Fatalf("Restore failed: %v", err)
+		}
+		return
+	}
+	if *copyTo != "" {
+		if err := runCopy(*copyTo); err != nil {
+			log.Fatalf("Copy failed: %v", err)
+		}
+		return
+	}
+
 	// Initialize database
-	if err := store.InitDB(); err != nil {
+	st, err := store.InitDB()
+	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
+	defer st.Close()
+
+	if *checkStore {
+		if err := storetest.Check(st); err != nil {
+			log.Fatalf("Store check failed: %v", err)
+		}
+		log.Printf("Store %s passed the contract checks", st)
+		return
+	}
+
+	// Feed ids and proxy URLs are signed with rss.secret
+	if err := service.LoadSecret(store.DataDir()); err != nil {
+		log.Fatalf("Failed to load rss.secret: %v", err)
+	}
 
 	// Initialize services
-	wechatSvc := service.NewWechatService()
-	fetcherSvc := service.NewFetcherService(wechatSvc)
-	schedulerSvc := service.NewSchedulerService(fetcherSvc)
+	wechatSvc := service.NewWechatService(st)
+	sources := service.NewSourceRegistry(
+		service.NewWechat2RSSSource(),
+		service.NewFixtureSource(""),
+		service.NewMPSource(st, ""),
+	)
+	proxyPolicy := service.NewProxyPolicyFromConfig()
+	mediaCache, err := service.NewMediaCacheFromConfig(store.DataDir(), proxyPolicy)
+	if err != nil {
+		log.Printf("Warning: Media cache disabled: %v", err)
+	}
+	feedCache := service.NewFeedCacheFromConfig()
+	hub := service.NewWebSubHubFromConfig(st)
+	fetcherSvc := service.NewFetcherService(st, wechatSvc, sources, mediaCache, feedCache, hub)
+	retentionSvc := service.NewRetentionService(st, feedCache)
+	recheckSvc := service.NewRecheckService(st, wechatSvc, fetcherSvc, feedCache, hub)
+	backupSvc := service.NewBackupService(st)
+	schedulerSvc := service.NewSchedulerService(fetcherSvc, retentionSvc, recheckSvc, backupSvc)
 
 	// Start scheduler
 	if err := schedulerSvc.Start(); err != nil {
@@ -41,7 +107,7 @@ func main() {
 	}
 
 	// Setup router
-	router := setupRouter(wechatSvc, fetcherSvc)
+	router := setupRouter(st, wechatSvc, fetcherSvc, mediaCache, proxyPolicy, feedCache, hub, retentionSvc, recheckSvc, backupSvc)
 
 	// Start server
 	port := viper.GetString("server.port")
@@ -84,7 +150,117 @@ func main() {
 	log.Println("Server exited")
 }
 
-func setupRouter(wechatSvc *service.WechatService, fetcherSvc *service.FetcherService) *gin.Engine {
+// runMigrations applies the pending migrations, or only lists them
+func runMigrations(apply bool) error {
+	st, err := store.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if apply {
+		applied, err := st.Migrate()
+		for _, m := range applied {
+			log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Database at %s is up to date (%d migrations applied)", st, len(applied))
+		return nil
+	}
+
+	migrations, err := st.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, m := range migrations {
+		status := "applied " + m.AppliedAt.Format("2006-01-02 15:04:05")
+		if m.AppliedAt.IsZero() {
+			status = "pending"
+			pending++
+		}
+		fmt.Printf("%04d_%-28s %s\n", m.Version, m.Name, status)
+	}
+	fmt.Printf("%d pending migrations\n", pending)
+	return nil
+}
+
+// runCopy copies the SQLite database at database.path into the PostgreSQL
+// database at dsn, applying its migrations first
+func runCopy(dsn string) error {
+	src, err := store.OpenSQLite(store.DBPath())
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := store.OpenPostgres(dsn)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	applied, err := dst.Migrate()
+	for _, m := range applied {
+		log.Printf("Applied migration %04d_%s to %s", m.Version, m.Name, dst)
+	}
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = store.CopyToPostgres(dst, src, func(table string, rows int64) {
+		log.Printf("Copied %d rows of %s", rows, table)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Copied %s into %s in %s", src, dst, time.Since(start).Round(time.Second))
+	return nil
+}
+
+// runBackup writes a backup of the database to path, or into path when it
+// is a directory, without stopping a running server
+func runBackup(path string) error {
+	st, err := store.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, service.BackupName(time.Now()))
+	}
+
+	start := time.Now()
+	if err := service.NewBackupService(st).WriteBackup(path); err != nil {
+		return err
+	}
+	log.Printf("Backed up %s to %s in %s", st, path, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// runRestore replaces the database at database.path with the backup at
+// path
+func runRestore(path string) error {
+	if driver := viper.GetString("database.driver"); driver != "" && driver != "sqlite" {
+		return store.ErrBackupUnsupported
+	}
+
+	kept, err := store.RestoreSQLite(path, store.DBPath())
+	if err != nil {
+		return err
+	}
+	if kept != "" {
+		log.Printf("Moved the replaced database to %s", kept)
+	}
+	log.Printf("Restored %s from %s", store.DBPath(), path)
+	return nil
+}
+
+func setupRouter(st store.Store, wechatSvc *service.WechatService, fetcherSvc *service.FetcherService, mediaCache *service.MediaCache, proxyPolicy *service.ProxyPolicy, feedCache *service.FeedCache, hub *service.WebSubHub, retentionSvc *service.RetentionService, recheckSvc *service.RecheckService, backupSvc *service.BackupService) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
 	router.Use(gin.Logger())
@@ -115,12 +291,16 @@ func setupRouter(wechatSvc *service.WechatService, fetcherSvc *service.FetcherSe
 	})
 
 	// Initialize handlers
-	h := handler.NewHandler(wechatSvc, fetcherSvc)
+	h := handler.NewHandler(st, wechatSvc, fetcherSvc, mediaCache, proxyPolicy, feedCache, hub, retentionSvc, recheckSvc, backupSvc)
 
-	// Login routes (no auth required) - must be before /api group
-	router.GET("/login/new", h.GetLoginQRCode)
-	router.POST("/login/code", h.SubmitLoginCode)
-	router.GET("/login/status", h.GetLoginStatus)
+	// Login routes (require auth) - a scan adds an account to this server
+	login := router.Group("/login")
+	login.Use(authMiddleware())
+	{
+		login.GET("/new", h.GetLoginQRCode)
+		login.POST("/code", h.SubmitLoginCode)
+		login.GET("/status", h.GetLoginStatus)
+	}
 
 	// API routes (require auth)
 	api := router.Group("/api")
@@ -138,9 +318,45 @@ func setupRouter(wechatSvc *service.WechatService, fetcherSvc *service.FetcherSe
 		api.GET("/pause/:id", h.PauseChannel)
 		api.GET("/list", h.ListChannels)
 
+		// Saved channel filters
+		api.GET("/filters/:id", h.GetChannelFilter)
+		api.PUT("/filters/:id", h.SetChannelFilter)
+		api.DELETE("/filters/:id", h.DeleteChannelFilter)
+
+		// Channel groups
+		api.GET("/groups", h.ListGroups)
+		api.POST("/groups", h.CreateGroup)
+		api.GET("/groups/:id", h.GetGroup)
+		api.PUT("/groups/:id", h.UpdateGroup)
+		api.DELETE("/groups/:id", h.DeleteGroup)
+
+		// Feed tokens
+		api.GET("/tokens", h.ListTokens)
+		api.POST("/tokens", h.CreateToken)
+		api.DELETE("/tokens/:id", h.RevokeToken)
+
 		// Articles
 		api.GET("/query", h.QueryArticles)
+		api.GET("/search", h.SearchArticles)
 		api.GET("/article/:id", h.GetArticle)
+		api.PUT("/article/:id/flags", h.SetArticleFlags)
+		api.GET("/article/:id/revisions", h.ListArticleRevisions)
+		api.GET("/article/:id/revisions/:rev", h.GetArticleRevision)
+		api.GET("/article/:id/diff", h.DiffArticleRevisions)
+
+		// Retention
+		api.GET("/retention", h.GetRetention)
+		api.POST("/retention/run", h.RunRetention)
+
+		// Article re-checks
+		api.GET("/recheck", h.GetRecheck)
+		api.POST("/recheck/run", h.RunRecheck)
+
+		// Backups
+		api.GET("/backup", h.DownloadBackup)
+		api.GET("/backups", h.ListBackups)
+		api.POST("/backups", h.CreateBackup)
+		api.GET("/backups/:name", h.DownloadSnapshot)
 
 		// Config
 		api.GET("/config", h.GetConfig)
@@ -148,18 +364,29 @@ func setupRouter(wechatSvc *service.WechatService, fetcherSvc *service.FetcherSe
 
 		// Export
 		api.GET("/opml", h.ExportOPML)
+
+		// Proxy
+		api.GET("/proxy/stats", h.GetProxyStats)
 	}
 
-	// RSS routes (public) - using query param for format
+	// RSS routes (public) - format from the path extension. Aggregates are
+	// static routes so they take precedence over /feed/:id.
 	rss := router.Group("")
 	{
 		rss.GET("/feed/:id", h.GetRSSFeed)
 		rss.GET("/feed/all", h.GetRSSAll)
+		rss.GET("/feed/all.xml", h.GetRSSAll)
+		rss.GET("/feed/all.json", h.GetRSSAll)
+		rss.GET("/feed/all.atom", h.GetRSSAll)
+		rss.GET("/feed/group/:slug", h.GetGroupFeed)
 	}
 
+	// WebSub hub (public)
+	router.POST("/websub", h.WebSubHub)
+
 	// Proxy routes
 	proxy := router.Group("")
-	proxy.Use(authMiddleware())
+	proxy.Use(proxyAuthMiddleware())
 	{
 		proxy.GET("/img-proxy", h.ImageProxy)
 		proxy.GET("/video-proxy", h.VideoProxy)
@@ -202,3 +429,26 @@ func authMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// proxyAuthMiddleware accepts either a URL signed by service.ProxyURL for
+// the requested endpoint or the admin token, so feeds can embed media
+// without leaking the token
+func proxyAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if service.VerifyProxySignature(c.FullPath(), c.Query("u"), c.Query("e"), c.Query("s")) {
+			c.Next()
+			return
+		}
+
+		token := c.Query("k")
+		expectedToken := viper.GetString("server.token")
+
+		if expectedToken != "" && token != expectedToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"err": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
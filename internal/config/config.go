@@ -14,12 +14,57 @@ func Load() error {
 	viper.AddConfigPath(".")
 	viper.SetDefault("server.port", "8080")
 	viper.SetDefault("server.token", "")
+	viper.SetDefault("database.driver", "sqlite")
+	viper.SetDefault("database.dsn", "")
+	viper.SetDefault("database.auto_migrate", true)
 	viper.SetDefault("rss.max_item_count", 20)
 	viper.SetDefault("rss.keep_old_count", 50)
 	viper.SetDefault("rss.enc_feed_id", false)
+	viper.SetDefault("rss.require_token", false)
 	viper.SetDefault("rss.static", false)
 	viper.SetDefault("rss.proxy_disable_img", false)
+	viper.SetDefault("rss.flag_updated", false)
+	viper.SetDefault("rss.proxy_url_ttl", "0s")
+	viper.SetDefault("rss.cache_enabled", true)
+	viper.SetDefault("rss.cache_ttl", "10m")
+	viper.SetDefault("rss.cache_max_entries", 256)
+	viper.SetDefault("websub.enabled", true)
+	viper.SetDefault("websub.allow_private", false)
+	viper.SetDefault("websub.lease_default", "240h")
+	viper.SetDefault("websub.lease_min", "1h")
+	viper.SetDefault("websub.lease_max", "720h")
+	viper.SetDefault("websub.max_per_topic", 100)
+	viper.SetDefault("websub.max_per_callback", 100)
+	viper.SetDefault("source.default", "wechat2rss")
+	viper.SetDefault("source.wechat2rss.url", "https://wechat2rss.xlab.app")
+	viper.SetDefault("source.fixture.dir", "./fixtures")
+	viper.SetDefault("source.mp.url", "https://mp.weixin.qq.com")
+	viper.SetDefault("source.mp.page_size", 5)
+	viper.SetDefault("source.mp.page_interval", "1s")
+	viper.SetDefault("login.url", "https://mp.weixin.qq.com")
+	viper.SetDefault("accounts.freq_cooldown", "1h")
+	viper.SetDefault("media_cache.enabled", true)
+	viper.SetDefault("media_cache.dir", "")
+	viper.SetDefault("media_cache.max_size_mb", 1024)
+	viper.SetDefault("media_cache.max_item_size_mb", 20)
+	viper.SetDefault("media_cache.prefetch", false)
+	viper.SetDefault("proxy.allowed_hosts", []string{})
+	viper.SetDefault("proxy.allow_private", false)
+	viper.SetDefault("proxy.max_size_mb", 100)
 	viper.SetDefault("scheduler.times", []string{"07:00", "12:00", "20:00"})
+	viper.SetDefault("retention.enabled", false)
+	viper.SetDefault("retention.time", "04:00")
+	viper.SetDefault("retention.max_age", "0s")
+	viper.SetDefault("retention.vacuum_min_deleted", 1000)
+	viper.SetDefault("backup.enabled", false)
+	viper.SetDefault("backup.time", "03:00")
+	viper.SetDefault("backup.dir", "")
+	viper.SetDefault("backup.keep", 7)
+	viper.SetDefault("revisions.enabled", false)
+	viper.SetDefault("revisions.window", "72h")
+	viper.SetDefault("revisions.interval", "6h")
+	viper.SetDefault("revisions.batch_size", 50)
+	viper.SetDefault("revisions.request_interval", "2s")
 
 	if err := viper.ReadInConfig(); err != nil {
 		log.Printf("Config file not found, using defaults: %v", err)
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RFC 5005 feed history namespace, used for the fh:archive marker
+const historyNS = "http://purl.org/syndication/history/1.0"
+
+// maxArchiveItems caps the articles of one monthly archive document
+const maxArchiveItems = 1000
+
+var archiveMonthRegex = regexp.MustCompile(`^\d{4}-(0[1-9]|1[0-2])$`)
+
+// feedPaging selects the document of a feed to render: a page of the
+// newest articles, or the archive of a month when Archive is set
+type feedPaging struct {
+	Page  int
+	Size  int
+	Total int
+
+	Archive     string // YYYY-MM
+	PrevArchive string // the archive before this document, if any
+	NextArchive string // the archive after Archive, if any
+}
+
+// feedLinks are the RFC 5005 links of a feed document. Paged documents
+// link to the first, previous and next pages; the first page links to the
+// newest archive, and archives link to each other and to the current
+// (subscription) document.
+type feedLinks struct {
+	First       string
+	Previous    string
+	Next        string
+	Current     string
+	PrevArchive string
+	NextArchive string
+	Archive     bool
+}
+
+// atomLinks returns links as Atom link elements, which RSS embeds as
+// atom:link
+func (l feedLinks) atomLinks(contentType string) []AtomLink {
+	var links []AtomLink
+	add := func(rel, href string) {
+		if href != "" {
+			links = append(links, AtomLink{Rel: rel, Type: contentType, Href: href})
+		}
+	}
+	add("first", l.First)
+	add("previous", l.Previous)
+	add("next", l.Next)
+	if l.Archive {
+		add("current", l.Current)
+	}
+	add("prev-archive", l.PrevArchive)
+	add("next-archive", l.NextArchive)
+	return links
+}
+
+// buildFeedLinks returns the links of the document selected by paging.
+// current is the URL of the subscription document.
+func buildFeedLinks(current string, paging feedPaging) feedLinks {
+	links := feedLinks{Current: current}
+
+	if paging.Archive != "" {
+		links.Archive = true
+		if paging.PrevArchive != "" {
+			links.PrevArchive = withParam(current, "archive", paging.PrevArchive)
+		}
+		if paging.NextArchive != "" {
+			links.NextArchive = withParam(current, "archive", paging.NextArchive)
+		}
+		return links
+	}
+
+	links.First = current
+	if paging.Page == 2 {
+		links.Previous = current
+	} else if paging.Page > 2 {
+		links.Previous = withParam(current, "page", strconv.Itoa(paging.Page-1))
+	}
+	if paging.Page*paging.Size < paging.Total {
+		links.Next = withParam(current, "page", strconv.Itoa(paging.Page+1))
+	}
+	if paging.Page <= 1 && paging.PrevArchive != "" {
+		links.PrevArchive = withParam(current, "archive", paging.PrevArchive)
+	}
+	return links
+}
+
+// withParam appends key=value to the query of rawURL
+func withParam(rawURL, key, value string) string {
+	sep := "?"
+	if strings.Contains(rawURL, "?") {
+		sep = "&"
+	}
+	return rawURL + sep + url.QueryEscape(key) + "=" + url.QueryEscape(value)
+}
+
+// completeMonths drops the current and later months from months, as only
+// past months can be served as stable archives
+func completeMonths(months []string, now time.Time) []string {
+	current := now.Format("2006-01")
+	for i, month := range months {
+		if month >= current {
+			return months[:i]
+		}
+	}
+	return months
+}
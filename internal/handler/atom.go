@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"encoding/xml"
+	"time"
+
+	"wechatoarss/internal/model"
+)
+
+// Atom 1.0 feed structs
+type AtomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Subtitle  string      `xml:"subtitle,omitempty"`
+	Updated   string      `xml:"updated"`
+	Links     []AtomLink  `xml:"link"`
+	Author    *AtomAuthor `xml:"author,omitempty"`
+	Icon      string      `xml:"icon,omitempty"`
+	Generator string      `xml:"generator,omitempty"`
+	Archive   *struct{}   `xml:"http://purl.org/syndication/history/1.0 archive,omitempty"`
+	Entries   []AtomEntry `xml:"entry"`
+}
+
+type AtomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type AtomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type AtomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type AtomEntry struct {
+	ID        string      `xml:"id"`
+	Title     AtomText    `xml:"title"`
+	Links     []AtomLink  `xml:"link"`
+	Published string      `xml:"published,omitempty"`
+	Updated   string      `xml:"updated"`
+	Author    *AtomAuthor `xml:"author,omitempty"`
+	Summary   *AtomText   `xml:"summary,omitempty"`
+	Content   *AtomText   `xml:"content,omitempty"`
+}
+
+// feedInfo describes the feed-level fields shared by every output format
+type feedInfo struct {
+	Title       string
+	Description string
+	HomePage    string
+	FeedURL     string
+	Icon        string
+	Author      string
+	Hub         string    // WebSub hub, if enabled
+	Updated     time.Time // latest change to the articles, zero if there are none
+	Links       feedLinks
+}
+
+func (h *Handler) buildAtom(info feedInfo, articles []model.Article, host string) ([]byte, error) {
+	updated := time.Now()
+	if len(articles) > 0 && !articles[0].PublishedAt.IsZero() {
+		updated = articles[0].PublishedAt
+	}
+
+	// Pages and archives share the id of the subscription document
+	id := info.Links.Current
+	if id == "" {
+		id = info.FeedURL
+	}
+
+	feed := AtomFeed{
+		ID:        id,
+		Title:     info.Title,
+		Subtitle:  info.Description,
+		Updated:   updated.Format(time.RFC3339),
+		Generator: "WeChatOArss",
+		Links: []AtomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: info.FeedURL},
+		},
+	}
+	if info.Hub != "" {
+		feed.Links = append(feed.Links, AtomLink{Rel: "hub", Href: info.Hub})
+	}
+	feed.Links = append(feed.Links, info.Links.atomLinks("application/atom+xml")...)
+	if info.Links.Archive {
+		feed.Archive = &struct{}{}
+	}
+	if info.Icon != "" {
+		feed.Icon = proxiedImage(host, info.Icon)
+	}
+	if info.HomePage != "" {
+		feed.Links = append(feed.Links, AtomLink{Rel: "alternate", Type: "text/html", Href: info.HomePage})
+	}
+	if info.Author != "" {
+		feed.Author = &AtomAuthor{Name: info.Author, URI: info.HomePage}
+	}
+
+	for _, a := range articles {
+		published := a.PublishedAt
+		if published.IsZero() {
+			published = a.CreatedAt
+		}
+		if published.IsZero() {
+			published = time.Now()
+		}
+
+		updated := published
+		if a.UpdatedAt.After(updated) {
+			updated = a.UpdatedAt
+		}
+
+		entry := AtomEntry{
+			ID:        articleGUID(a),
+			Title:     AtomText{Type: "text", Body: itemTitle(a)},
+			Links:     []AtomLink{{Rel: "alternate", Type: "text/html", Href: a.Link}},
+			Published: published.Format(time.RFC3339),
+			Updated:   updated.Format(time.RFC3339),
+		}
+		if a.SourceURL != "" {
+			entry.Links = append(entry.Links, AtomLink{Rel: "related", Href: a.SourceURL})
+		}
+
+		if author := itemAuthor(info, a); author != "" {
+			entry.Author = &AtomAuthor{Name: author}
+		}
+
+		if desc := h.fetcherSvc.CleanDescription(a.Description); desc != "" {
+			entry.Summary = &AtomText{Type: "text", Body: desc}
+		}
+		if a.Content != "" {
+			entry.Content = &AtomText{Type: "html", Body: h.fetcherSvc.RewriteMedia(a.Content, host)}
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
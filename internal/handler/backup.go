@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/service"
+	"wechatoarss/internal/store"
+)
+
+// Backup handlers
+func (h *Handler) ListBackups(c *gin.Context) {
+	backups, err := h.backup.Snapshots()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err": "",
+		"data": gin.H{
+			"enabled": viper.GetBool("backup.enabled"),
+			"time":    viper.GetString("backup.time"),
+			"dir":     service.BackupDir(),
+			"keep":    viper.GetInt("backup.keep"),
+			"backups": backups,
+		},
+	})
+}
+
+// CreateBackup takes a snapshot into the backup directory now
+func (h *Handler) CreateBackup(c *gin.Context) {
+	backup, err := h.backup.Snapshot()
+	if err != nil {
+		c.JSON(backupErrorStatus(err), model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": backup,
+	})
+}
+
+// DownloadBackup streams a fresh backup of the database
+func (h *Handler) DownloadBackup(c *gin.Context) {
+	dir := service.BackupDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	// Not named like a snapshot, so rotation and listings skip it
+	path := filepath.Join(dir, fmt.Sprintf(".download-%d.db", time.Now().UnixNano()))
+	defer os.Remove(path)
+
+	if err := h.backup.WriteBackup(path); err != nil {
+		c.JSON(backupErrorStatus(err), model.APIResponse{Err: err.Error()})
+		return
+	}
+	c.FileAttachment(path, service.BackupName(time.Now()))
+}
+
+// DownloadSnapshot streams a snapshot from the backup directory
+func (h *Handler) DownloadSnapshot(c *gin.Context) {
+	path, err := h.backup.SnapshotPath(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Backup not found"})
+		return
+	}
+	c.FileAttachment(path, filepath.Base(path))
+}
+
+// backupErrorStatus maps a backup error to a response status
+func backupErrorStatus(err error) int {
+	if errors.Is(err, store.ErrBackupUnsupported) {
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,447 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/service"
+	"wechatoarss/internal/store"
+)
+
+var (
+	errFeedNotFound     = errors.New("Channel not found")
+	errFeedUnauthorized = errors.New("A valid feed token is required")
+	errArchiveNotFound  = errors.New("Archive not found")
+)
+
+// feedError marks a loadFeed error caused by the request
+type feedError struct {
+	err error
+}
+
+func (e *feedError) Error() string { return e.err.Error() }
+
+// feedErrorStatus maps a loadFeed error to a response status
+func feedErrorStatus(err error) int {
+	var fe *feedError
+	switch {
+	case errors.Is(err, errFeedNotFound), errors.Is(err, errArchiveNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errFeedUnauthorized):
+		return http.StatusUnauthorized
+	case errors.As(err, &fe):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// feedHost returns rss.host, the base of every URL in feeds
+func feedHost() string {
+	host := viper.GetString("rss.host")
+	if host == "" {
+		host = "http://localhost:8080"
+	}
+	return host
+}
+
+// loadFeed returns the feed at path, rendering it on a cache miss. path is
+// /feed/<id>, /feed/all or /feed/group/<slug>, optionally ending in .xml,
+// .atom or .json.
+func (h *Handler) loadFeed(path string, query url.Values) (*service.RenderedFeed, error) {
+	name := strings.TrimPrefix(path, "/feed/")
+	format := "xml"
+	for _, ext := range []string{"json", "atom", "xml"} {
+		if strings.HasSuffix(name, "."+ext) {
+			format = ext
+			name = strings.TrimSuffix(name, "."+ext)
+			break
+		}
+	}
+
+	slug, isGroup := strings.CutPrefix(name, "group/")
+	if name == "" || slug == "" || strings.Contains(slug, "/") {
+		return nil, errFeedNotFound
+	}
+
+	// Checked before the cache so revoked and expired tokens are refused
+	// even for feeds rendered while they were valid
+	if err := h.authorizeFeed(name, slug, isGroup, query); err != nil {
+		return nil, err
+	}
+
+	key := path + "?" + query.Encode()
+	if feed, ok := h.feedCache.Get(key); ok {
+		return feed, nil
+	}
+
+	queryFilter, err := filterFromQuery(query)
+	if err != nil {
+		return nil, &feedError{err}
+	}
+	archive := query.Get("archive")
+	if archive != "" && !archiveMonthRegex.MatchString(archive) {
+		return nil, &feedError{errors.New("archive must be a month such as 2024-05")}
+	}
+
+	host := feedHost()
+	maxItems := viper.GetInt("rss.max_item_count")
+
+	var q store.ArticleQuery
+	var bizIDs []string
+	var info feedInfo
+	var channelName string
+
+	switch {
+	case name == "all":
+		if maxItems == 0 {
+			maxItems = 50
+		}
+		info = feedInfo{
+			Title:       "WeChatOArss All",
+			Description: "All subscribed channels",
+			HomePage:    host,
+			Author:      "WeChatOArss",
+		}
+
+	case isGroup:
+		if maxItems == 0 {
+			maxItems = 50
+		}
+		group, err := h.store.GetGroupBySlug(slug)
+		if err != nil {
+			return nil, errFeedNotFound
+		}
+
+		q.GroupID = group.ID
+		bizIDs = group.Channels
+		info = feedInfo{
+			Title:       group.Name,
+			Description: group.Description,
+			HomePage:    host,
+			Author:      "WeChatOArss",
+		}
+		if info.Description == "" {
+			info.Description = group.Name
+		}
+
+	default:
+		if maxItems == 0 {
+			maxItems = 20
+		}
+
+		// Parse biz_id (handle encrypted)
+		bizID := h.fetcherSvc.ParseBizID(name)
+
+		channel, err := h.store.GetChannelByBizID(bizID)
+		if err != nil {
+			return nil, errFeedNotFound
+		}
+
+		q.BizID = bizID
+		bizIDs = []string{bizID}
+		channelName = channel.Name
+		info = feedInfo{
+			Title:       channel.Name,
+			Description: channel.Description,
+			HomePage:    channel.Link,
+			Icon:        channel.Avatar,
+			Author:      channel.Name,
+		}
+	}
+
+	q.Filters, err = h.fetcherSvc.ArticleFilters(q.BizID, queryFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	paging := feedPaging{Page: feedPage(query), Size: maxItems}
+	if archive != "" || paging.Page == 1 {
+		months, err := h.store.GetArticleMonths(q)
+		if err != nil {
+			return nil, err
+		}
+		months = completeMonths(months, time.Now())
+
+		if archive != "" {
+			i := slices.Index(months, archive)
+			if i < 0 {
+				return nil, errArchiveNotFound
+			}
+			if i > 0 {
+				paging.PrevArchive = months[i-1]
+			}
+			if i+1 < len(months) {
+				paging.NextArchive = months[i+1]
+			}
+			paging.Archive = archive
+			q.Month = archive
+			paging.Page, paging.Size = 1, maxArchiveItems
+		} else if len(months) > 0 {
+			paging.PrevArchive = months[len(months)-1]
+		}
+	}
+
+	q.Page, q.Size = paging.Page, paging.Size
+	articles, total, err := h.fetcherSvc.QueryArticles(q)
+	if err != nil {
+		return nil, err
+	}
+	paging.Total = total
+	if channelName != "" {
+		for i := range articles {
+			articles[i].ChannelName = channelName
+		}
+	} else {
+		h.setChannelNames(articles)
+	}
+
+	info.Hub = h.hub.HubURL(host)
+	info.Updated = lastModified(articles)
+	// Feed URLs keep the filter parameters and feed token so paging and
+	// WebSub topics refer to the same filtered feed. The admin token is
+	// never written into a feed.
+	feedQuery := url.Values{}
+	for k, v := range query {
+		if k != "page" && k != "archive" && k != "k" {
+			feedQuery[k] = v
+		}
+	}
+	feed, err := h.renderFeed(format, info, host+"/feed/"+name, feedQuery, articles, host, paging)
+	if err != nil {
+		return nil, err
+	}
+	feed.BizIDs = bizIDs
+	feed.Archive = paging.Archive != ""
+	return h.cacheFeed(key, feed, info.Updated), nil
+}
+
+// authorizeFeed checks the feed token in query when rss.require_token is
+// set. The admin token is accepted as well.
+func (h *Handler) authorizeFeed(name, slug string, isGroup bool, query url.Values) error {
+	if !viper.GetBool("rss.require_token") {
+		return nil
+	}
+	if admin := viper.GetString("server.token"); admin != "" && query.Get("k") == admin {
+		return nil
+	}
+
+	token := query.Get("token")
+	if token == "" {
+		return errFeedUnauthorized
+	}
+
+	scope, target := model.TokenScopeAll, ""
+	switch {
+	case name == "all":
+	case isGroup:
+		group, err := h.store.GetGroupBySlug(slug)
+		if err != nil {
+			return errFeedNotFound
+		}
+		scope, target = model.TokenScopeGroup, strconv.FormatInt(group.ID, 10)
+	default:
+		scope, target = model.TokenScopeChannel, h.fetcherSvc.ParseBizID(name)
+	}
+
+	if _, err := service.CheckFeedToken(h.store, token, scope, target); err != nil {
+		return fmt.Errorf("%w: %v", errFeedUnauthorized, err)
+	}
+	return nil
+}
+
+// setChannelNames fills ChannelName of articles from several channels
+func (h *Handler) setChannelNames(articles []model.Article) {
+	names := make(map[string]string)
+	for i := range articles {
+		name, ok := names[articles[i].BizID]
+		if !ok {
+			if ch, err := h.store.GetChannelByBizID(articles[i].BizID); err == nil && ch != nil {
+				name = ch.Name
+			}
+			names[articles[i].BizID] = name
+		}
+		articles[i].ChannelName = name
+	}
+}
+
+// renderTopic renders the feed at a WebSub topic URL
+func (h *Handler) renderTopic(topic string) (*service.RenderedFeed, error) {
+	u, err := url.Parse(topic)
+	if err != nil {
+		return nil, errFeedNotFound
+	}
+	base, err := url.Parse(feedHost())
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(u.Host, base.Host) {
+		return nil, errFeedNotFound
+	}
+
+	path := strings.TrimPrefix(u.Path, strings.TrimSuffix(base.Path, "/"))
+	if !strings.HasPrefix(path, "/feed/") {
+		return nil, errFeedNotFound
+	}
+	return h.loadFeed(path, u.Query())
+}
+
+// renderFeed renders the document of articles selected by paging in
+// format. baseURL is the feed URL without its extension or query.
+func (h *Handler) renderFeed(format string, info feedInfo, baseURL string, query url.Values, articles []model.Article, host string, paging feedPaging) (*service.RenderedFeed, error) {
+	feed := &service.RenderedFeed{HubURL: info.Hub}
+	var err error
+
+	ext := ".xml"
+	switch format {
+	case "json":
+		ext = ".json"
+		feed.ContentType = "application/json; charset=utf-8"
+	case "atom":
+		ext = ".atom"
+		feed.ContentType = "application/atom+xml; charset=utf-8"
+	default:
+		feed.ContentType = "application/rss+xml; charset=utf-8"
+	}
+
+	current := baseURL + ext
+	if len(query) > 0 {
+		current += "?" + query.Encode()
+	}
+	info.Links = buildFeedLinks(current, paging)
+	switch {
+	case paging.Archive != "":
+		info.FeedURL = withParam(current, "archive", paging.Archive)
+	case paging.Page > 1:
+		info.FeedURL = withParam(current, "page", strconv.Itoa(paging.Page))
+	default:
+		info.FeedURL = current
+	}
+
+	switch format {
+	case "json":
+		feed.Body, err = json.Marshal(h.buildJSONFeed(info, articles, host))
+	case "atom":
+		feed.Body, err = h.buildAtom(info, articles, host)
+	default:
+		feed.Body, err = h.buildRSS(info, articles, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	feed.FeedURL = info.FeedURL
+	return feed, nil
+}
+
+// lastModified returns the latest time one of articles was stored,
+// published, edited or re-checked, or zero if there are no articles
+func lastModified(articles []model.Article) time.Time {
+	var latest time.Time
+	for _, a := range articles {
+		for _, t := range []time.Time{a.CreatedAt, a.PublishedAt, a.UpdatedAt, a.CheckedAt} {
+			if t.After(latest) {
+				latest = t
+			}
+		}
+	}
+	return latest
+}
+
+// cacheFeed sets the validators of a freshly rendered feed and caches it.
+// The ETag is a hash of the rendered body, so any change to the output
+// changes it; rendering the same articles again must give the same body.
+// Last-Modified is updated, the lastModified of the feed's articles.
+func (h *Handler) cacheFeed(key string, feed *service.RenderedFeed, updated time.Time) *service.RenderedFeed {
+	digest := sha256.New()
+	digest.Write([]byte(feed.ContentType))
+	digest.Write([]byte{0})
+	digest.Write(feed.Body)
+	sum := digest.Sum(nil)
+
+	if updated.IsZero() || updated.After(time.Now()) {
+		updated = time.Now()
+	}
+
+	feed.ETag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	feed.LastModified = updated.UTC().Truncate(time.Second)
+	h.feedCache.Set(key, feed)
+	return feed
+}
+
+// serveFeed writes feed, answering conditional requests with 304 and
+// sending the gzipped body to clients that accept it
+func serveFeed(c *gin.Context, feed *service.RenderedFeed) {
+	c.Header("ETag", feed.ETag)
+	c.Header("Last-Modified", feed.LastModified.Format(http.TimeFormat))
+	if feed.Archive {
+		// Archive documents only change when old articles are backfilled
+		c.Header("Cache-Control", "public, max-age=86400")
+	} else {
+		c.Header("Cache-Control", "public, max-age=60")
+	}
+	c.Header("Vary", "Accept-Encoding")
+	if feed.HubURL != "" {
+		c.Writer.Header().Add("Link", fmt.Sprintf(`<%s>; rel="hub"`, feed.HubURL))
+		c.Writer.Header().Add("Link", fmt.Sprintf(`<%s>; rel="self"`, feed.FeedURL))
+	}
+
+	if notModified(c.Request, feed) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	body := feed.Body
+	if acceptsGzip(c.Request) && len(feed.Gzip) > 0 {
+		c.Header("Content-Encoding", "gzip")
+		body = feed.Gzip
+	}
+	c.Header("Content-Length", strconv.Itoa(len(body)))
+	c.Data(http.StatusOK, feed.ContentType, body)
+}
+
+// notModified evaluates If-None-Match, falling back to If-Modified-Since
+// as RFC 9110 requires
+func notModified(r *http.Request, feed *service.RenderedFeed) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == feed.ETag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !feed.LastModified.After(t)
+	}
+	return false
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc = strings.TrimSpace(enc)
+		name, params, _ := strings.Cut(enc, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		// gzip;q=0 explicitly refuses it
+		params = strings.ReplaceAll(params, " ", "")
+		return params != "q=0" && params != "q=0.0"
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/service"
+)
+
+func TestFeedValidatorsStable(t *testing.T) {
+	h, st := newTestHandler(t, nil)
+	if _, err := st.CreateChannel("BIZ_A", "Alpha News", "desc", "", "https://example.org", 0); err != nil {
+		t.Fatal(err)
+	}
+	published := time.Date(2024, 5, 20, 8, 0, 0, 0, time.UTC)
+	if _, err := st.CreateArticle("BIZ_A", "Hello", "First issue", "<p>Hello</p>", "https://example.org/1", "", "Alpha Writer", "", published); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/feed/BIZ_A", "/feed/BIZ_A.atom", "/feed/BIZ_A.json"} {
+		t.Run(path, func(t *testing.T) {
+			first := serve(h, httptest.NewRequest(http.MethodGet, path, nil))
+			if first.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", first.Code, first.Body)
+			}
+
+			// Render again from scratch a second later
+			h.feedCache.InvalidateAll()
+			time.Sleep(time.Second)
+			second := serve(h, httptest.NewRequest(http.MethodGet, path, nil))
+			if second.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", second.Code, second.Body)
+			}
+
+			etag := first.Header().Get("ETag")
+			if etag == "" || second.Header().Get("ETag") != etag {
+				t.Errorf("ETag changed from %q to %q", etag, second.Header().Get("ETag"))
+			}
+			if second.Body.String() != first.Body.String() {
+				t.Errorf("rendering the same articles gave a different body:\n%s\n%s", first.Body, second.Body)
+			}
+
+			h.feedCache.InvalidateAll()
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("If-None-Match", etag)
+			if w := serve(h, req); w.Code != http.StatusNotModified {
+				t.Errorf("conditional request got status %d, want 304", w.Code)
+			}
+		})
+	}
+}
+
+func TestRSSLastBuildDate(t *testing.T) {
+	h, st := newTestHandler(t, nil)
+	if _, err := st.CreateChannel("BIZ_A", "Alpha News", "desc", "", "https://example.org", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty feed has no build date rather than the time it was rendered
+	w := serve(h, httptest.NewRequest(http.MethodGet, "/feed/BIZ_A", nil))
+	if strings.Contains(w.Body.String(), "<lastBuildDate>") {
+		t.Errorf("empty feed has a build date:\n%s", w.Body)
+	}
+
+	if _, err := st.CreateArticle("BIZ_A", "Hello", "", "<p>Hello</p>", "https://example.org/1", "", "", "", time.Date(2024, 5, 20, 8, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	h.feedCache.InvalidateAll()
+	w = serve(h, httptest.NewRequest(http.MethodGet, "/feed/BIZ_A", nil))
+	lastModified, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rest, _ := strings.Cut(w.Body.String(), "<lastBuildDate>")
+	value, _, _ := strings.Cut(rest, "</lastBuildDate>")
+	built, err := time.Parse(time.RFC1123Z, value)
+	if err != nil {
+		t.Fatalf("lastBuildDate %q: %v", value, err)
+	}
+	if !built.Equal(lastModified) {
+		t.Errorf("lastBuildDate = %v, want Last-Modified %v", built, lastModified)
+	}
+}
+
+func TestFeedAuthors(t *testing.T) {
+	h, st := newTestHandler(t, nil)
+	if _, err := st.CreateChannel("BIZ_A", "Alpha News", "desc", "", "https://example.org", 0); err != nil {
+		t.Fatal(err)
+	}
+	published := time.Date(2024, 5, 20, 8, 0, 0, 0, time.UTC)
+	if _, err := st.CreateArticle("BIZ_A", "Signed", "", "<p>1</p>", "https://example.org/1", "", "Alpha Writer", "", published); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.CreateArticle("BIZ_A", "Unsigned", "", "<p>2</p>", "https://example.org/2", "", "", "", published.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Articles without a byline are credited to their channel
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/feed/BIZ_A", []string{"<dc:creator>Alpha Writer</dc:creator>", "<dc:creator>Alpha News</dc:creator>"}},
+		{"/feed/BIZ_A.atom", []string{"<name>Alpha Writer</name>", "<name>Alpha News</name>"}},
+		{"/feed/BIZ_A.json", []string{`"authors":[{"name":"Alpha Writer"}]`, `"authors":[{"name":"Alpha News"}]`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := serve(h, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			signed, unsigned, _ := strings.Cut(w.Body.String(), "Unsigned")
+			if !strings.Contains(signed, tt.want[0]) {
+				t.Errorf("signed article is not credited with %s:\n%s", tt.want[0], w.Body)
+			}
+			if !strings.Contains(unsigned, tt.want[1]) {
+				t.Errorf("unsigned article is not credited with %s:\n%s", tt.want[1], w.Body)
+			}
+		})
+	}
+}
+
+func TestFeedTokenAuthorization(t *testing.T) {
+	for key, value := range map[string]any{"rss.require_token": true, "server.token": "admin-secret"} {
+		old := viper.Get(key)
+		viper.Set(key, value)
+		t.Cleanup(func() { viper.Set(key, old) })
+	}
+
+	h, st := newTestHandler(t, nil)
+	for _, bizID := range []string{"BIZ_A", "BIZ_B"} {
+		if _, err := st.CreateChannel(bizID, bizID, "", "", "https://example.org", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	group, err := st.CreateGroup("Alpha", "alpha", "", []string{"BIZ_A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.CreateGroup("Beta", "beta", "", []string{"BIZ_B"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// token stores a new feed token of the given scope
+	token := func(scope, target string, expiresAt time.Time) string {
+		token := service.GenerateFeedToken()
+		if _, err := st.CreateFeedToken(service.HashFeedToken(token), "", scope, target, expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	all := token(model.TokenScopeAll, "", time.Time{})
+	channel := token(model.TokenScopeChannel, "BIZ_A", time.Time{})
+	groupToken := token(model.TokenScopeGroup, strconv.FormatInt(group.ID, 10), time.Time{})
+	expired := token(model.TokenScopeAll, "", time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"no token", "/feed/BIZ_A", http.StatusUnauthorized},
+		{"admin token", "/feed/BIZ_A?k=admin-secret", http.StatusOK},
+		{"wrong admin token", "/feed/BIZ_A?k=guess", http.StatusUnauthorized},
+		{"all feeds", "/feed/all?token=" + all, http.StatusOK},
+		{"all covers a group", "/feed/group/beta?token=" + all, http.StatusOK},
+		{"channel", "/feed/BIZ_A?token=" + channel, http.StatusOK},
+		{"channel atom", "/feed/BIZ_A.atom?token=" + channel, http.StatusOK},
+		{"other channel", "/feed/BIZ_B?token=" + channel, http.StatusUnauthorized},
+		{"channel in other case", "/feed/biz_a?token=" + channel, http.StatusUnauthorized},
+		{"channel token for all feeds", "/feed/all?token=" + channel, http.StatusUnauthorized},
+		{"group", "/feed/group/alpha?token=" + groupToken, http.StatusOK},
+		{"other group", "/feed/group/beta?token=" + groupToken, http.StatusUnauthorized},
+		{"group token for its channel", "/feed/BIZ_A?token=" + groupToken, http.StatusUnauthorized},
+		{"expired", "/feed/all?token=" + expired, http.StatusUnauthorized},
+		{"unknown", "/feed/all?token=" + service.GenerateFeedToken(), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(h, httptest.NewRequest(http.MethodGet, tt.path, nil)); w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
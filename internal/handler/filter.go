@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/service"
+)
+
+// filterFromQuery reads filter rules from query parameters. include and
+// exclude take comma-separated keywords and may be repeated; include_re,
+// exclude_re, fields and min_len map to the other rules. It returns nil
+// when no rule is given.
+func filterFromQuery(query url.Values) (*model.ArticleFilter, error) {
+	f := &model.ArticleFilter{
+		Include:      splitList(query["include"]),
+		Exclude:      splitList(query["exclude"]),
+		IncludeRegex: query.Get("include_re"),
+		ExcludeRegex: query.Get("exclude_re"),
+		Fields:       splitList(query["fields"]),
+	}
+	if s := query.Get("min_len"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		f.MinLength = n
+	}
+
+	if len(f.Include) == 0 && len(f.Exclude) == 0 && f.IncludeRegex == "" && f.ExcludeRegex == "" && f.MinLength == 0 {
+		return nil, nil
+	}
+	if err := service.ValidateFilter(*f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func splitList(values []string) []string {
+	var items []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// Filter handlers
+func (h *Handler) GetChannelFilter(c *gin.Context) {
+	bizID := h.fetcherSvc.ParseBizID(c.Param("id"))
+
+	f, err := h.store.GetChannelFilter(bizID)
+	if err != nil {
+		f = &model.ArticleFilter{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": f,
+	})
+}
+
+func (h *Handler) SetChannelFilter(c *gin.Context) {
+	bizID := h.fetcherSvc.ParseBizID(c.Param("id"))
+	if _, err := h.store.GetChannelByBizID(bizID); err != nil {
+		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Channel not found"})
+		return
+	}
+
+	var f model.ArticleFilter
+	if err := c.ShouldBindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: err.Error()})
+		return
+	}
+	if err := service.ValidateFilter(f); err != nil {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	if err := h.store.SetChannelFilter(bizID, f); err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+	h.feedCache.Invalidate(bizID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": f,
+	})
+}
+
+func (h *Handler) DeleteChannelFilter(c *gin.Context) {
+	bizID := h.fetcherSvc.ParseBizID(c.Param("id"))
+
+	if err := h.store.DeleteChannelFilter(bizID); err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+	h.feedCache.Invalidate(bizID)
+
+	c.JSON(http.StatusOK, model.APIResponse{Err: ""})
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"wechatoarss/internal/model"
+)
+
+var groupSlugRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// validGroupSlug reports whether slug is a usable group slug. It must
+// contain a letter so it can't be mistaken for a group id.
+func validGroupSlug(slug string) bool {
+	return groupSlugRegex.MatchString(slug) && strings.IndexFunc(slug, isSlugLetter) >= 0
+}
+
+func isSlugLetter(r rune) bool {
+	return r >= 'a' && r <= 'z'
+}
+
+// groupRequest is the body of group create and update requests
+type groupRequest struct {
+	Name        string   `json:"name"`
+	Slug        string   `json:"slug"`
+	Description string   `json:"description"`
+	Channels    []string `json:"channels"`
+}
+
+// Group handlers
+func (h *Handler) ListGroups(c *gin.Context) {
+	groups, err := h.store.GetGroups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+	if groups == nil {
+		groups = []model.Group{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": groups,
+	})
+}
+
+func (h *Handler) GetGroup(c *gin.Context) {
+	group, ok := h.groupParam(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": group,
+	})
+}
+
+func (h *Handler) CreateGroup(c *gin.Context) {
+	var req groupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: err.Error()})
+		return
+	}
+	if !h.validateGroup(c, &req, 0) {
+		return
+	}
+
+	group, err := h.store.CreateGroup(req.Name, req.Slug, req.Description, req.Channels)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": group,
+	})
+}
+
+func (h *Handler) UpdateGroup(c *gin.Context) {
+	group, ok := h.groupParam(c)
+	if !ok {
+		return
+	}
+
+	var req groupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: err.Error()})
+		return
+	}
+	if req.Name == "" {
+		req.Name = group.Name
+	}
+	if req.Slug == "" {
+		req.Slug = group.Slug
+	}
+	if !h.validateGroup(c, &req, group.ID) {
+		return
+	}
+
+	// A missing channels field keeps the current members
+	if err := h.store.UpdateGroup(group.ID, req.Name, req.Slug, req.Description, req.Channels); err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+	h.feedCache.InvalidateAll()
+
+	group, err := h.store.GetGroupByID(group.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": group,
+	})
+}
+
+func (h *Handler) DeleteGroup(c *gin.Context) {
+	group, ok := h.groupParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteGroup(group.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+	h.feedCache.InvalidateAll()
+
+	c.JSON(http.StatusOK, model.APIResponse{Err: ""})
+}
+
+func (h *Handler) GetGroupFeed(c *gin.Context) {
+	feed, err := h.loadFeed(c.Request.URL.Path, c.Request.URL.Query())
+	if err != nil {
+		c.JSON(feedErrorStatus(err), model.APIResponse{Err: err.Error()})
+		return
+	}
+	serveFeed(c, feed)
+}
+
+// groupParam loads the group named by the :id parameter, which may be the
+// numeric id or the slug
+func (h *Handler) groupParam(c *gin.Context) (*model.Group, bool) {
+	param := c.Param("id")
+
+	var group *model.Group
+	var err error
+	if id, convErr := strconv.ParseInt(param, 10, 64); convErr == nil {
+		group, err = h.store.GetGroupByID(id)
+	} else {
+		group, err = h.store.GetGroupBySlug(param)
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Group not found"})
+		return nil, false
+	}
+	return group, true
+}
+
+// validateGroup checks req and normalizes its slug and channels. id is the
+// group being updated, or 0 for a new group.
+func (h *Handler) validateGroup(c *gin.Context, req *groupRequest, id int64) bool {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "name is required"})
+		return false
+	}
+
+	if req.Slug == "" {
+		req.Slug = slugify(req.Name)
+	}
+	if req.Slug != "" {
+		req.Slug = strings.ToLower(req.Slug)
+		if !validGroupSlug(req.Slug) {
+			c.JSON(http.StatusBadRequest, model.APIResponse{Err: "slug may only contain a-z, 0-9, - and _, and needs at least one letter"})
+			return false
+		}
+		if existing, err := h.store.GetGroupBySlug(req.Slug); err == nil && existing.ID != id {
+			c.JSON(http.StatusConflict, model.APIResponse{Err: fmt.Sprintf("Group %q already exists", req.Slug)})
+			return false
+		}
+	}
+
+	for i, feedID := range req.Channels {
+		bizID := h.fetcherSvc.ParseBizID(feedID)
+		if _, err := h.store.GetChannelByBizID(bizID); err != nil {
+			c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Channel not found: " + feedID})
+			return false
+		}
+		req.Channels[i] = bizID
+	}
+	return true
+}
+
+// slugify derives a slug from an ASCII group name. Names without ASCII
+// letters yield "" and get an id-based slug.
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if b.Len() > 0 && !dash {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if len(slug) > 64 {
+		slug = strings.TrimSuffix(slug[:64], "-")
+	}
+	if strings.IndexFunc(slug, isSlugLetter) < 0 {
+		return ""
+	}
+	return slug
+}
@@ -0,0 +1,44 @@
+package handler
+
+import "testing"
+
+func TestValidGroupSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		want bool
+	}{
+		{"fintech", true},
+		{"2024-reads", true},
+		{"a", true},
+		{"group_7", true},
+		{"7", false},
+		{"2024", false},
+		{"1-2", false},
+		{"", false},
+		{"-fintech", false},
+		{"Fintech", false},
+		{"fin tech", false},
+		{"../fintech", false},
+	}
+	for _, tt := range tests {
+		if got := validGroupSlug(tt.slug); got != tt.want {
+			t.Errorf("validGroupSlug(%q) = %t, want %t", tt.slug, got, tt.want)
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Fintech":        "fintech",
+		"  AI & Chips! ": "ai-chips",
+		"Top 10 Reads":   "top-10-reads",
+		"2024":           "",
+		"金融":             "",
+		"金融 2024":        "",
+	}
+	for name, want := range tests {
+		if got := slugify(name); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
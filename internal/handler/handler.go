@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/xml"
 	"fmt"
 	"html"
@@ -21,15 +23,33 @@ import (
 )
 
 type Handler struct {
-	wechatSvc  *service.WechatService
-	fetcherSvc *service.FetcherService
-}
-
-func NewHandler(wechatSvc *service.WechatService, fetcherSvc *service.FetcherService) *Handler {
-	return &Handler{
-		wechatSvc:  wechatSvc,
-		fetcherSvc: fetcherSvc,
-	}
+	store       store.Store
+	wechatSvc   *service.WechatService
+	fetcherSvc  *service.FetcherService
+	mediaCache  *service.MediaCache
+	proxyPolicy *service.ProxyPolicy
+	feedCache   *service.FeedCache
+	hub         *service.WebSubHub
+	retention   *service.RetentionService
+	recheck     *service.RecheckService
+	backup      *service.BackupService
+}
+
+func NewHandler(st store.Store, wechatSvc *service.WechatService, fetcherSvc *service.FetcherService, mediaCache *service.MediaCache, proxyPolicy *service.ProxyPolicy, feedCache *service.FeedCache, hub *service.WebSubHub, retention *service.RetentionService, recheck *service.RecheckService, backup *service.BackupService) *Handler {
+	h := &Handler{
+		store:       st,
+		wechatSvc:   wechatSvc,
+		fetcherSvc:  fetcherSvc,
+		mediaCache:  mediaCache,
+		proxyPolicy: proxyPolicy,
+		feedCache:   feedCache,
+		hub:         hub,
+		retention:   retention,
+		recheck:     recheck,
+		backup:      backup,
+	}
+	hub.SetRenderer(h.renderTopic)
+	return h
 }
 
 // Wechat login handlers
@@ -46,6 +66,7 @@ func (h *Handler) GetLoginQRCode(c *gin.Context) {
 			"isLogin": qrCode.IsLogin,
 			"qrcode":  qrCode.QRCode,
 			"uuid":    qrCode.UUID,
+			"tips":    qrCode.Tips,
 		},
 	})
 }
@@ -64,8 +85,10 @@ func (h *Handler) GetLoginStatus(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"err":    "",
-		"code":   status.Code,
+		"err":       "",
+		"code":      status.Code,
+		"status":    status.State,
+		"tips":      status.Tips,
 		"redir_url": status.RedirectURL,
 	})
 }
@@ -84,7 +107,7 @@ func (h *Handler) SubmitLoginCode(c *gin.Context) {
 }
 
 func (h *Handler) ListAccounts(c *gin.Context) {
-	accounts, err := store.GetAccounts()
+	accounts, err := h.store.GetAccounts()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
 		return
@@ -104,14 +127,15 @@ func (h *Handler) RefreshAccountStatus(c *gin.Context) {
 	}
 
 	// Refresh account status
-	account, err := store.GetAccountByID(id)
-	if err != nil {
+	if _, err := h.store.GetAccountByID(id); err != nil {
 		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Account not found"})
 		return
 	}
 
-	account.Available = true
-	account.NeedCheck = false
+	if err := h.store.ResetAccountStatus(id); err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, model.APIResponse{Err: ""})
 }
@@ -123,7 +147,7 @@ func (h *Handler) DeleteAccount(c *gin.Context) {
 		return
 	}
 
-	if err := store.DeleteAccount(id); err != nil {
+	if err := h.store.DeleteAccount(id); err != nil {
 		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
 		return
 	}
@@ -227,7 +251,7 @@ func (h *Handler) ListChannels(c *gin.Context) {
 	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
 	name := c.Query("name")
 
-	channels, total, err := store.GetChannels(page, size, name)
+	channels, total, err := h.store.GetChannels(page, size, name)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
 		return
@@ -260,7 +284,7 @@ func (h *Handler) ListChannels(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"err": "",
+		"err":  "",
 		"data": data,
 		"meta": gin.H{
 			"total": total,
@@ -286,7 +310,31 @@ func (h *Handler) QueryArticles(c *gin.Context) {
 
 	includeContent := content == "1"
 
-	articles, total, err := store.GetArticles(bizID, before, after, page, size, includeContent)
+	queryFilter, err := filterFromQuery(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	var filters []store.ScopedFilter
+	if c.Query("raw") != "1" {
+		filters, err = h.fetcherSvc.ArticleFilters(bizID, queryFilter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+			return
+		}
+	} else if queryFilter != nil {
+		filters = []store.ScopedFilter{{Filter: *queryFilter}}
+	}
+
+	articles, total, err := h.fetcherSvc.QueryArticles(store.ArticleQuery{
+		BizID:   bizID,
+		Before:  before,
+		After:   after,
+		Filters: filters,
+		Page:    page,
+		Size:    size,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
 		return
@@ -294,7 +342,7 @@ func (h *Handler) QueryArticles(c *gin.Context) {
 
 	// Add channel names
 	for i := range articles {
-		ch, err := store.GetChannelByBizID(articles[i].BizID)
+		ch, err := h.store.GetChannelByBizID(articles[i].BizID)
 		if err == nil && ch != nil {
 			articles[i].ChannelName = ch.Name
 		}
@@ -303,13 +351,13 @@ func (h *Handler) QueryArticles(c *gin.Context) {
 	var data []gin.H
 	for _, a := range articles {
 		item := gin.H{
-			"biz_id":     a.BizID,
-			"biz_name":   a.ChannelName,
-			"title":      a.Title,
-			"desc":       a.Description,
-			"created":    a.PublishedAt.Format(time.RFC3339),
-			"link":       a.Link,
-			"cover":      a.Cover,
+			"biz_id":   a.BizID,
+			"biz_name": a.ChannelName,
+			"title":    a.Title,
+			"desc":     a.Description,
+			"created":  a.PublishedAt.Format(time.RFC3339),
+			"link":     a.Link,
+			"cover":    a.Cover,
 		}
 		if includeContent {
 			item["content"] = a.Content
@@ -318,7 +366,7 @@ func (h *Handler) QueryArticles(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"err": "",
+		"err":  "",
 		"data": data,
 		"meta": gin.H{
 			"total": total,
@@ -335,40 +383,80 @@ func (h *Handler) GetArticle(c *gin.Context) {
 		return
 	}
 
-	article, err := store.GetArticleByID(id)
+	article, err := h.store.GetArticleByID(id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Article not found"})
 		return
 	}
 
+	host := viper.GetString("rss.host")
+	if host == "" {
+		host = "http://localhost:8080"
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"err": "",
 		"data": gin.H{
-			"id":           article.ID,
-			"biz_id":       article.BizID,
-			"biz_name":     article.ChannelName,
-			"title":        article.Title,
-			"desc":         article.Description,
-			"content":      article.Content,
-			"created":      article.PublishedAt.Format(time.RFC3339),
-			"link":         article.Link,
-			"cover":        article.Cover,
+			"id":        article.ID,
+			"biz_id":    article.BizID,
+			"biz_name":  article.ChannelName,
+			"title":     article.Title,
+			"desc":      article.Description,
+			"content":   h.fetcherSvc.RewriteMedia(article.Content, host),
+			"created":   article.PublishedAt.Format(time.RFC3339),
+			"link":      article.Link,
+			"cover":     article.Cover,
+			"starred":   article.Starred,
+			"pinned":    article.Pinned,
+			"status":    article.Status,
+			"updatedAt": article.UpdatedAt,
+			"checkedAt": article.CheckedAt,
 		},
 	})
 }
 
+// SetArticleFlags stars or pins an article, which exempts it from
+// retention. The body holds starred and/or pinned booleans.
+func (h *Handler) SetArticleFlags(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Invalid article ID"})
+		return
+	}
+
+	var req struct {
+		Starred *bool `json:"starred"`
+		Pinned  *bool `json:"pinned"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	if _, err := h.store.GetArticleByID(id); err != nil {
+		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Article not found"})
+		return
+	}
+	if err := h.store.SetArticleFlags(id, req.Starred, req.Pinned); err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.APIResponse{Err: ""})
+}
+
 // Config handlers
 func (h *Handler) GetConfig(c *gin.Context) {
 	config := model.Config{
-		Host:            viper.GetString("rss.host"),
-		Token:           viper.GetString("server.token"),
-		MaxItemCount:    viper.GetInt("rss.max_item_count"),
-		KeepOldCount:    viper.GetInt("rss.keep_old_count"),
-		EncFeedID:       viper.GetBool("rss.enc_feed_id"),
-		Static:          viper.GetBool("rss.static"),
-		SchedulerTimes:  viper.GetStringSlice("scheduler.times"),
-		NotifyEnabled:   viper.GetBool("notify.enabled"),
-		NotifyType:      viper.GetString("notify.type"),
+		Host:           viper.GetString("rss.host"),
+		Token:          viper.GetString("server.token"),
+		MaxItemCount:   viper.GetInt("rss.max_item_count"),
+		KeepOldCount:   viper.GetInt("rss.keep_old_count"),
+		EncFeedID:      viper.GetBool("rss.enc_feed_id"),
+		Static:         viper.GetBool("rss.static"),
+		SchedulerTimes: viper.GetStringSlice("scheduler.times"),
+		NotifyEnabled:  viper.GetBool("notify.enabled"),
+		NotifyType:     viper.GetString("notify.type"),
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -404,7 +492,7 @@ func (h *Handler) UpdateConfig(c *gin.Context) {
 }
 
 func (h *Handler) ExportOPML(c *gin.Context) {
-	channels, _, err := store.GetChannels(1, 1000, "")
+	channels, _, err := h.store.GetChannels(1, 1000, "")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
 		return
@@ -415,17 +503,54 @@ func (h *Handler) ExportOPML(c *gin.Context) {
 		host = "http://localhost:8080"
 	}
 
-	var items []string
-	for _, ch := range channels {
+	groups, err := h.store.GetGroups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	outline := func(ch model.Channel, category string) string {
 		feedID := ch.BizID
 		if viper.GetBool("rss.enc_feed_id") {
 			feedID = h.wechatSvc.EncryptFeedID(ch.BizID)
 		}
-		items = append(items, fmt.Sprintf(`<outline text="%s" title="%s" type="rss" xmlUrl="%s/feed/%s.xml"/>`, 
-			html.EscapeString(ch.Name), 
+		attrs := ""
+		if category != "" {
+			attrs = fmt.Sprintf(` category="/%s"`, html.EscapeString(category))
+		}
+		return fmt.Sprintf(`<outline text="%s" title="%s" type="rss" xmlUrl="%s"%s/>`,
 			html.EscapeString(ch.Name),
-			host,
-			feedID))
+			html.EscapeString(ch.Name),
+			html.EscapeString(fmt.Sprintf("%s/feed/%s.xml", host, feedID)),
+			attrs)
+	}
+
+	byBizID := make(map[string]model.Channel)
+	for _, ch := range channels {
+		byBizID[ch.BizID] = ch
+	}
+
+	// Each group becomes a category outline holding its channels; channels
+	// in no group stay at the top level
+	var items []string
+	grouped := make(map[string]bool)
+	for _, g := range groups {
+		var children []string
+		for _, bizID := range g.Channels {
+			if ch, ok := byBizID[bizID]; ok {
+				children = append(children, "  "+outline(ch, g.Name))
+				grouped[bizID] = true
+			}
+		}
+		items = append(items, fmt.Sprintf("<outline text=\"%s\" title=\"%s\">\n%s\n</outline>",
+			html.EscapeString(g.Name),
+			html.EscapeString(g.Name),
+			strings.Join(children, "\n")))
+	}
+	for _, ch := range channels {
+		if !grouped[ch.BizID] {
+			items = append(items, outline(ch, ""))
+		}
 	}
 
 	opml := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
@@ -453,284 +578,355 @@ func (h *Handler) GetVersion(c *gin.Context) {
 
 // RSS handlers
 func (h *Handler) GetRSSFeed(c *gin.Context) {
-	bizID := c.Param("id")
-	if bizID == "" {
+	if c.Param("id") == "" {
 		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Invalid ID"})
 		return
 	}
 
-	// Check format from path extension
-	format := "xml"
-	if strings.HasSuffix(bizID, ".json") {
-		format = "json"
-		bizID = strings.TrimSuffix(bizID, ".json")
-	} else if strings.HasSuffix(bizID, ".xml") {
-		bizID = strings.TrimSuffix(bizID, ".xml")
+	feed, err := h.loadFeed(c.Request.URL.Path, c.Request.URL.Query())
+	if err != nil {
+		c.JSON(feedErrorStatus(err), model.APIResponse{Err: err.Error()})
+		return
 	}
+	serveFeed(c, feed)
+}
 
-	// Parse biz_id (handle encrypted)
-	bizID = h.fetcherSvc.ParseBizID(bizID)
-
-	channel, err := store.GetChannelByBizID(bizID)
+func (h *Handler) GetRSSAll(c *gin.Context) {
+	feed, err := h.loadFeed(c.Request.URL.Path, c.Request.URL.Query())
 	if err != nil {
-		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Channel not found"})
+		c.JSON(feedErrorStatus(err), model.APIResponse{Err: err.Error()})
 		return
 	}
+	serveFeed(c, feed)
+}
 
-	maxItems := viper.GetInt("rss.max_item_count")
-	if maxItems == 0 {
-		maxItems = 20
+// Proxy handlers
+func (h *Handler) ImageProxy(c *gin.Context) {
+	imgURL, ok := proxyTarget(c)
+	if !ok {
+		return
 	}
 
-	articles, _, err := store.GetArticles(bizID, "", "", 1, maxItems, true)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+	// Serve from the media cache; ServeContent answers conditional requests
+	if h.mediaCache != nil {
+		entry, f, err := h.mediaCache.Open(imgURL)
+		if err != nil {
+			c.JSON(proxyErrorStatus(err), model.APIResponse{Err: err.Error()})
+			return
+		}
+		defer f.Close()
+
+		c.Header("Content-Type", entry.ContentType)
+		c.Header("ETag", `"`+entry.Hash+`"`)
+		c.Header("Cache-Control", "public, max-age=86400")
+		http.ServeContent(c.Writer, c.Request, "", entry.ModTime, f)
 		return
 	}
 
-	host := viper.GetString("rss.host")
-	if host == "" {
-		host = "http://localhost:8080"
-	}
+	h.streamProxy(c, imgURL, []string{"image/"})
+}
 
-	// Return JSON if requested
-	if format == "json" {
-		jsonFeed := h.buildJSONFeed(channel.Name, channel.Description, channel.Link, host+"/feed/"+bizID+".json", articles)
-		c.Header("Content-Type", "application/json; charset=utf-8")
-		c.JSON(http.StatusOK, jsonFeed)
+func (h *Handler) VideoProxy(c *gin.Context) {
+	videoURL, ok := proxyTarget(c)
+	if !ok {
 		return
 	}
 
-	rss := h.buildRSS(channel.Name, channel.Description, channel.Link, host+"/feed/"+bizID, articles, host)
+	// Videos bypass the media cache
+	h.streamProxy(c, videoURL, []string{"video/", "audio/", "application/octet-stream"})
+}
 
-	c.Header("Content-Type", "application/xml; charset=utf-8")
-	c.String(http.StatusOK, rss)
+// proxyTarget reads the target URL of a proxy request. c.Query has
+// already decoded it, so it must not be unescaped again.
+func proxyTarget(c *gin.Context) (string, bool) {
+	target := c.Query("u")
+	if target == "" {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "url is required"})
+		return "", false
+	}
+	return target, true
 }
 
-func (h *Handler) GetRSSFeedJSON(c *gin.Context) {
-	bizID := c.Param("id")
-	if bizID == "" {
-		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Invalid ID"})
+// streamProxy relays the upstream response to the client. Range and
+// conditional headers are forwarded so players can seek, upstream status
+// and length are passed through, and the upstream read stops when the
+// client disconnects.
+func (h *Handler) streamProxy(c *gin.Context, target string, allowedTypes []string) {
+	if err := h.proxyPolicy.CheckURL(target); err != nil {
+		c.JSON(http.StatusForbidden, model.APIResponse{Err: err.Error()})
 		return
 	}
 
-	// Parse biz_id (handle encrypted)
-	bizID = h.fetcherSvc.ParseBizID(bizID)
-
-	channel, err := store.GetChannelByBizID(bizID)
+	req, err := http.NewRequestWithContext(c.Request.Context(), "GET", target, nil)
 	if err != nil {
-		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Channel not found"})
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: err.Error()})
 		return
 	}
-
-	maxItems := viper.GetInt("rss.max_item_count")
-	if maxItems == 0 {
-		maxItems = 20
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	req.Header.Set("Referer", "https://mp.weixin.qq.com/")
+	for _, key := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+		if v := c.GetHeader(key); v != "" {
+			req.Header.Set(key, v)
+		}
 	}
 
-	articles, _, err := store.GetArticles(bizID, "", "", 1, maxItems, true)
+	resp, err := h.proxyPolicy.StreamClient().Do(req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		c.JSON(proxyErrorStatus(err), model.APIResponse{Err: err.Error()})
 		return
 	}
+	defer resp.Body.Close()
 
-	host := viper.GetString("rss.host")
-	if host == "" {
-		host = "http://localhost:8080"
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		copyHeaders(c, resp, "ETag", "Last-Modified", "Cache-Control")
+		c.Status(http.StatusNotModified)
+		return
+	case resp.StatusCode >= 400:
+		// Don't let readers cache upstream failures
+		c.Header("Cache-Control", "no-store")
+		copyHeaders(c, resp, "Content-Range")
+		c.JSON(resp.StatusCode, model.APIResponse{Err: fmt.Sprintf("upstream HTTP %d", resp.StatusCode)})
+		return
 	}
 
-	jsonFeed := h.buildJSONFeed(channel.Name, channel.Description, channel.Link, host+"/feed/"+bizID+".json", articles)
-
-	c.Header("Content-Type", "application/json; charset=utf-8")
-	c.JSON(http.StatusOK, jsonFeed)
-}
-
-func (h *Handler) GetRSSAll(c *gin.Context) {
-	// Check format from path
-	path := c.Request.URL.Path
-	format := "xml"
-	if strings.HasSuffix(path, ".json") {
-		format = "json"
+	if err := h.proxyPolicy.CheckResponse(resp, allowedTypes); err != nil {
+		c.JSON(http.StatusForbidden, model.APIResponse{Err: err.Error()})
+		return
 	}
 
-	maxItems := viper.GetInt("rss.max_item_count")
-	if maxItems == 0 {
-		maxItems = 50
+	copyHeaders(c, resp, "Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified")
+	if resp.Header.Get("Accept-Ranges") == "" && resp.StatusCode == http.StatusPartialContent {
+		c.Header("Accept-Ranges", "bytes")
 	}
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Status(resp.StatusCode)
 
-	articles, _, err := store.GetArticles("", "", "", 1, maxItems, true)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
-		return
-	}
+	io.Copy(c.Writer, h.proxyPolicy.LimitResponse(resp))
+}
 
-	host := viper.GetString("rss.host")
-	if host == "" {
-		host = "http://localhost:8080"
+func copyHeaders(c *gin.Context, resp *http.Response, keys ...string) {
+	for _, key := range keys {
+		if v := resp.Header.Get(key); v != "" {
+			c.Header(key, v)
+		}
 	}
+}
 
-	// Get channel names
-	for i := range articles {
-		ch, err := store.GetChannelByBizID(articles[i].BizID)
-		if err == nil && ch != nil {
-			articles[i].ChannelName = ch.Name
-		}
+// proxyErrorStatus maps an upstream fetch error to a response status
+func proxyErrorStatus(err error) int {
+	if service.IsProxyViolation(err) {
+		return http.StatusForbidden
 	}
+	return http.StatusBadGateway
+}
+
+func (h *Handler) GetProxyStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"err": "",
+		"data": gin.H{
+			"violations": h.proxyPolicy.Violations(),
+		},
+	})
+}
 
-	// Return JSON if requested
-	if format == "json" {
-		jsonFeed := h.buildJSONFeed("WeChatOArss All", "All subscribed channels", "", host+"/feed/all.json", articles)
-		c.Header("Content-Type", "application/json; charset=utf-8")
-		c.JSON(http.StatusOK, jsonFeed)
+func (h *Handler) LinkProxy(c *gin.Context) {
+	link, ok := proxyTarget(c)
+	if !ok {
 		return
 	}
 
-	rss := h.buildRSS("WeChatOArss All", "All subscribed channels", "", host+"/feed/all.xml", articles, host)
+	// A valid signature only authenticates the request; the target must
+	// still pass the policy
+	if err := h.proxyPolicy.CheckURL(link); err != nil {
+		c.JSON(http.StatusForbidden, model.APIResponse{Err: err.Error()})
+		return
+	}
 
-	c.Header("Content-Type", "application/xml; charset=utf-8")
-	c.String(http.StatusOK, rss)
+	c.Redirect(http.StatusFound, link)
 }
 
-func (h *Handler) GetRSSAllJSON(c *gin.Context) {
-	maxItems := viper.GetInt("rss.max_item_count")
-	if maxItems == 0 {
-		maxItems = 50
+// Helper functions
+func (h *Handler) buildRSS(info feedInfo, articles []model.Article, host string) ([]byte, error) {
+	link := info.HomePage
+	if link == "" {
+		link = host
 	}
 
-	articles, _, err := store.GetArticles("", "", "", 1, maxItems, true)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
-		return
+	channel := RSSChannel{
+		Title:       info.Title,
+		Link:        link,
+		Description: info.Description,
+		Language:    "zh-cn",
+		Generator:   "WeChatOArss",
+		AtomLinks:   []RSSAtomLink{{Href: info.FeedURL, Rel: "self", Type: "application/rss+xml"}},
 	}
-
-	host := viper.GetString("rss.host")
-	if host == "" {
-		host = "http://localhost:8080"
+	// The build date follows the articles rather than the clock so the
+	// same articles always render the same document and ETag
+	if !info.Updated.IsZero() {
+		channel.LastBuildDate = info.Updated.Format(time.RFC1123Z)
+	}
+	if info.Hub != "" {
+		channel.AtomLinks = append(channel.AtomLinks, RSSAtomLink{Href: info.Hub, Rel: "hub"})
+	}
+	for _, l := range info.Links.atomLinks("application/rss+xml") {
+		channel.AtomLinks = append(channel.AtomLinks, RSSAtomLink{Href: l.Href, Rel: l.Rel, Type: l.Type})
+	}
+	if info.Links.Archive {
+		channel.Archive = &struct{}{}
+	}
+	if channel.Description == "" {
+		channel.Description = info.Title
+	}
+	if info.Icon != "" {
+		channel.Image = &RSSImage{URL: proxiedImage(host, info.Icon), Title: info.Title, Link: link}
 	}
 
-	// Get channel names
-	for i := range articles {
-		ch, err := store.GetChannelByBizID(articles[i].BizID)
-		if err == nil && ch != nil {
-			articles[i].ChannelName = ch.Name
+	for _, a := range articles {
+		published := a.PublishedAt
+		if published.IsZero() {
+			published = a.CreatedAt
+		}
+		if published.IsZero() {
+			published = time.Now()
 		}
-	}
 
-	jsonFeed := h.buildJSONFeed("WeChatOArss All", "All subscribed channels", "", host+"/feed/all.json", articles)
+		item := RSSItem{
+			Title:       itemTitle(a),
+			Link:        a.Link,
+			GUID:        RSSGUID{IsPermaLink: "false", Value: articleGUID(a)},
+			Description: h.fetcherSvc.CleanDescription(a.Description),
+			PubDate:     published.Format(time.RFC1123Z),
+		}
 
-	c.Header("Content-Type", "application/json; charset=utf-8")
-	c.JSON(http.StatusOK, jsonFeed)
-}
+		// RSS <author> must be an e-mail address, so names go in dc:creator
+		item.Creator = itemAuthor(info, a)
+		if a.ChannelName != "" {
+			item.Categories = []string{a.ChannelName}
+		}
 
-// Proxy handlers
-func (h *Handler) ImageProxy(c *gin.Context) {
-	imgURL := c.Query("u")
-	if imgURL == "" {
-		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "url is required"})
-		return
+		if a.Cover != "" {
+			item.Enclosure = &RSSEnclosure{URL: proxiedImage(host, a.Cover), Length: "0", Type: imageType(a.Cover)}
+		}
+		if a.Content != "" {
+			item.Content = &RSSContent{Body: h.fetcherSvc.RewriteMedia(a.Content, host)}
+		}
+
+		channel.Items = append(channel.Items, item)
 	}
 
-	// Decode URL
-	decodedURL, err := url.QueryUnescape(imgURL)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Invalid url"})
-		return
+	feed := RSSFeed{
+		Version:   "2.0",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		DCNS:      "http://purl.org/dc/elements/1.1/",
+		Channel:   channel,
+	}
+	if channel.Archive != nil {
+		feed.FHNS = historyNS
 	}
 
-	// Fetch image
-	resp, err := http.Get(decodedURL)
+	out, err := xml.MarshalIndent(feed, "", "  ")
 	if err != nil {
-		c.JSON(http.StatusBadGateway, model.APIResponse{Err: err.Error()})
-		return
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// itemTitle returns the title of the feed item of a. With rss.flag_updated
+// set, it marks articles edited or removed after publication.
+func itemTitle(a model.Article) string {
+	if !viper.GetBool("rss.flag_updated") {
+		return a.Title
+	}
+	switch {
+	case a.Status == model.ArticleStatusDeleted:
+		return "[已删除] " + a.Title
+	case a.Status == model.ArticleStatusViolated:
+		return "[已违规] " + a.Title
+	case !a.UpdatedAt.IsZero():
+		return "[已更新] " + a.Title
+	}
+	return a.Title
+}
+
+// itemAuthor returns the byline of a, or the name of its channel or feed
+// when the article has none
+func itemAuthor(info feedInfo, a model.Article) string {
+	switch {
+	case a.Author != "":
+		return a.Author
+	case a.ChannelName != "":
+		return a.ChannelName
+	}
+	return info.Author
+}
+
+// articleGUID returns an id for a that stays the same when WeChat varies
+// the tracking parameters of its link
+func articleGUID(a model.Article) string {
+	key := a.Link
+	if u, err := url.Parse(a.Link); err == nil {
+		q := u.Query()
+		if q.Get("__biz") != "" && q.Get("mid") != "" {
+			key = "mp/" + q.Get("__biz") + "/" + q.Get("mid") + "/" + q.Get("idx")
+		} else {
+			key = u.Host + u.Path
+		}
+	}
+	if key == "" {
+		key = fmt.Sprintf("article/%d", a.ID)
 	}
-	defer resp.Body.Close()
 
-	c.Header("Content-Type", resp.Header.Get("Content-Type"))
-	c.Header("Cache-Control", "public, max-age=86400")
-	io.Copy(c.Writer, resp.Body)
+	sum := sha256.Sum256([]byte(key))
+	return "urn:wechatoarss:" + hex.EncodeToString(sum[:16])
 }
 
-func (h *Handler) VideoProxy(c *gin.Context) {
-	h.ImageProxy(c) // Same logic
-}
-
-func (h *Handler) LinkProxy(c *gin.Context) {
-	link := c.Query("u")
-	if link == "" {
-		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "url is required"})
-		return
+// proxiedImage routes an image URL through the image proxy unless
+// rss.proxy_disable_img is set
+func proxiedImage(host, imgURL string) string {
+	if strings.HasPrefix(imgURL, "//") {
+		imgURL = "https:" + imgURL
 	}
-
-	decodedURL, err := url.QueryUnescape(link)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Invalid url"})
-		return
+	if viper.GetBool("rss.proxy_disable_img") || !strings.HasPrefix(imgURL, "http") {
+		return imgURL
 	}
-
-	c.Redirect(http.StatusFound, decodedURL)
+	return service.ProxyURL(host, "/img-proxy", imgURL)
 }
 
-// Helper functions
-func (h *Handler) buildRSS(title, description, link, feedURL string, articles []model.Article, host string) string {
-	var items []string
-	for _, a := range articles {
-		pubDate := a.PublishedAt.Format(time.RFC1123)
-		if a.PublishedAt.IsZero() {
-			pubDate = time.Now().Format(time.RFC1123)
-		}
-
-		desc := h.fetcherSvc.CleanDescription(a.Description)
-		content := a.Content
-
-		item := fmt.Sprintf(`<item>
-<title><![CDATA[%s]]></title>
-<link>%s</link>
-<description><![CDATA[%s]]></description>
-<pubDate>%s</pubDate>
-<content:encoded><![CDATA[%s]]></content:encoded>
-</item>`, a.Title, a.Link, desc, pubDate, content)
-
-		items = append(items, item)
+// proxiedVideo routes a video or audio URL through the video proxy, unless
+// rss.proxy_disable_img turns the media proxies off
+func proxiedVideo(host, mediaURL string) string {
+	if strings.HasPrefix(mediaURL, "//") {
+		mediaURL = "https:" + mediaURL
 	}
-
-	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/">
-<channel>
-<title><![CDATA[%s]]></title>
-<link>%s</link>
-<description><![CDATA[%s]]></description>
-<language>zh-cn</language>
-<lastBuildDate>%s</lastBuildDate>
-%s
-</channel>
-</rss>`, title, link, description, time.Now().Format(time.RFC1123), strings.Join(items, "\n"))
+	if viper.GetBool("rss.proxy_disable_img") || !strings.HasPrefix(mediaURL, "http") {
+		return mediaURL
+	}
+	return service.ProxyURL(host, "/video-proxy", mediaURL)
 }
 
-func (h *Handler) buildJSONFeed(title, description, homePage, feedURL string, articles []model.Article) gin.H {
-	var items []gin.H
-	for _, a := range articles {
-		pubDate := a.PublishedAt.Format(time.RFC3339)
-		if a.PublishedAt.IsZero() {
-			pubDate = time.Now().Format(time.RFC3339)
-		}
-
-		items = append(items, gin.H{
-			"id":           fmt.Sprintf("%d", a.ID),
-			"url":          a.Link,
-			"title":        a.Title,
-			"content_html": a.Content,
-			"summary":      a.Description,
-			"date_published": pubDate,
-		})
+// imageType guesses the MIME type of a WeChat image from its wx_fmt parameter
+func imageType(imgURL string) string {
+	format := ""
+	if u, err := url.Parse(imgURL); err == nil {
+		format = strings.ToLower(u.Query().Get("wx_fmt"))
+	}
+	switch format {
+	case "png", "gif", "webp", "bmp":
+		return "image/" + format
+	default:
+		return "image/jpeg"
 	}
+}
 
-	return gin.H{
-		"version":       "https://jsonfeed.org/version/1",
-		"title":         title,
-		"home_page_url": homePage,
-		"feed_url":      feedURL,
-		"items":         items,
+// feedPage returns the 1-based page requested with ?page=
+func feedPage(query url.Values) int {
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		return 1
 	}
+	return page
 }
 
 func init() {
@@ -739,26 +935,66 @@ func init() {
 
 // XML feed struct for RSS 2.0
 type RSSFeed struct {
-	XMLName xml.Name `xml:"rss"`
-	Version string   `xml:"version,attr"`
-	Channel RSSChannel
+	XMLName   xml.Name `xml:"rss"`
+	Version   string   `xml:"version,attr"`
+	ContentNS string   `xml:"xmlns:content,attr"`
+	AtomNS    string   `xml:"xmlns:atom,attr"`
+	DCNS      string   `xml:"xmlns:dc,attr"`
+	FHNS      string   `xml:"xmlns:fh,attr,omitempty"`
+	Channel   RSSChannel
 }
 
 type RSSChannel struct {
-	XMLName     xml.Name `xml:"channel"`
-	Title       string   `xml:"title"`
-	Link        string   `xml:"link"`
-	Description string   `xml:"description"`
-	Language    string   `xml:"language"`
-	LastBuildDate string  `xml:"lastBuildDate"`
-	Items       []RSSItem
+	XMLName       xml.Name      `xml:"channel"`
+	Title         string        `xml:"title"`
+	Link          string        `xml:"link"`
+	Description   string        `xml:"description"`
+	Language      string        `xml:"language,omitempty"`
+	LastBuildDate string        `xml:"lastBuildDate,omitempty"`
+	Generator     string        `xml:"generator,omitempty"`
+	AtomLinks     []RSSAtomLink `xml:"atom:link"`
+	Archive       *struct{}     `xml:"fh:archive,omitempty"`
+	Image         *RSSImage     `xml:"image,omitempty"`
+	Items         []RSSItem
+}
+
+type RSSAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type RSSImage struct {
+	URL   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
 }
 
 type RSSItem struct {
-	XMLName     xml.Name `xml:"item"`
-	Title       string   `xml:"title"`
-	Link        string   `xml:"link"`
-	Description string   `xml:"description"`
-	PubDate     string   `xml:"pubDate"`
-	Content     string   `xml:"content:encoded"`
+	XMLName     xml.Name      `xml:"item"`
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        RSSGUID       `xml:"guid"`
+	Description string        `xml:"description"`
+	Creator     string        `xml:"dc:creator,omitempty"`
+	Categories  []string      `xml:"category"`
+	Enclosure   *RSSEnclosure `xml:"enclosure,omitempty"`
+	PubDate     string        `xml:"pubDate"`
+	Content     *RSSContent   `xml:"content:encoded,omitempty"`
+}
+
+type RSSGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type RSSEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// RSSContent is written as CDATA; the encoder splits any "]]>" in Body
+type RSSContent struct {
+	Body string `xml:",cdata"`
 }
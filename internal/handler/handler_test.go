@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"wechatoarss/internal/service"
+	"wechatoarss/internal/store"
+)
+
+// newTestHandler returns a Handler over a migrated scratch SQLite store,
+// with a feed cache and proxy policy but no WebSub hub or media cache
+func newTestHandler(t *testing.T, policy *service.ProxyPolicy) (*Handler, store.Store) {
+	t.Helper()
+	st, err := store.OpenSQLite(filepath.Join(t.TempDir(), "wechatoarss.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	if _, err := st.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if policy == nil {
+		policy = service.NewProxyPolicy(nil, false, 0)
+	}
+	feedCache := service.NewFeedCache(16)
+	wechatSvc := service.NewWechatService(st)
+	fetcherSvc := service.NewFetcherService(st, wechatSvc, service.NewSourceRegistry(), nil, feedCache, nil)
+	return NewHandler(st, wechatSvc, fetcherSvc, nil, policy, feedCache, nil, nil, nil, nil), st
+}
+
+// serve routes req through a router with the public feed and proxy routes
+// of h and returns the response
+func serve(h *Handler, req *http.Request) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/feed/:id", h.GetRSSFeed)
+	router.GET("/feed/all", h.GetRSSAll)
+	router.GET("/feed/group/:slug", h.GetGroupFeed)
+	router.GET("/video-proxy", h.VideoProxy)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestVideoProxy(t *testing.T) {
+	video := bytes.Repeat([]byte("0123456789"), 100)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/video.mp4":
+			w.Header().Set("Content-Type", "video/mp4")
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+		case "/blob":
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		http.ServeContent(w, r, "", time.Date(2024, 5, 20, 8, 0, 0, 0, time.UTC), bytes.NewReader(video))
+	}))
+	defer upstream.Close()
+
+	// The size limit is smaller than the whole video
+	h, _ := newTestHandler(t, service.NewProxyPolicy([]string{"127.0.0.1"}, true, 100))
+	proxy := func(target, byteRange string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/video-proxy?u="+url.QueryEscape(target), nil)
+		if byteRange != "" {
+			req.Header.Set("Range", byteRange)
+		}
+		return serve(h, req)
+	}
+
+	w := proxy(upstream.URL+"/video.mp4", "bytes=10-19")
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("range request got status %d: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 10-19/1000" {
+		t.Errorf("Content-Range = %q", got)
+	}
+	if w.Header().Get("Accept-Ranges") != "bytes" || w.Body.String() != string(video[10:20]) {
+		t.Errorf("range response Accept-Ranges %q body %q", w.Header().Get("Accept-Ranges"), w.Body)
+	}
+
+	// Video and audio are exempt from the size limit
+	w = proxy(upstream.URL+"/video.mp4", "")
+	if w.Code != http.StatusOK || w.Body.Len() != len(video) {
+		t.Errorf("whole video got status %d and %d bytes", w.Code, w.Body.Len())
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"wrong type", upstream.URL + "/page.html", http.StatusForbidden},
+		{"large blob", upstream.URL + "/blob", http.StatusForbidden},
+		{"host not allowed", "http://evil.example/video.mp4", http.StatusForbidden},
+		{"bad scheme", "file:///etc/passwd", http.StatusForbidden},
+		{"no target", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := proxy(tt.target, ""); w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"time"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/service"
+)
+
+// JSON Feed 1.1 structs
+type JSONFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url,omitempty"`
+	FeedURL     string           `json:"feed_url,omitempty"`
+	Description string           `json:"description,omitempty"`
+	NextURL     string           `json:"next_url,omitempty"`
+	Icon        string           `json:"icon,omitempty"`
+	Favicon     string           `json:"favicon,omitempty"`
+	Authors     []JSONFeedAuthor `json:"authors,omitempty"`
+	Language    string           `json:"language,omitempty"`
+	Hubs        []JSONFeedHub    `json:"hubs,omitempty"`
+	History     *JSONFeedHistory `json:"_rfc5005,omitempty"`
+	Items       []JSONFeedItem   `json:"items"`
+}
+
+type JSONFeedAuthor struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type JSONFeedHub struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// JSONFeedHistory is a JSON Feed extension carrying the RFC 5005 paging
+// and archive links, which JSON Feed has no fields for besides next_url
+type JSONFeedHistory struct {
+	About       string `json:"about"`
+	First       string `json:"first,omitempty"`
+	Previous    string `json:"previous,omitempty"`
+	Next        string `json:"next,omitempty"`
+	Current     string `json:"current,omitempty"`
+	PrevArchive string `json:"prev_archive,omitempty"`
+	NextArchive string `json:"next_archive,omitempty"`
+	Archive     bool   `json:"archive,omitempty"`
+}
+
+type JSONFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url,omitempty"`
+	ExternalURL   string               `json:"external_url,omitempty"`
+	Title         string               `json:"title,omitempty"`
+	ContentHTML   string               `json:"content_html,omitempty"`
+	Summary       string               `json:"summary,omitempty"`
+	Image         string               `json:"image,omitempty"`
+	BannerImage   string               `json:"banner_image,omitempty"`
+	DatePublished string               `json:"date_published,omitempty"`
+	DateModified  string               `json:"date_modified,omitempty"`
+	Authors       []JSONFeedAuthor     `json:"authors,omitempty"`
+	Tags          []string             `json:"tags,omitempty"`
+	Attachments   []JSONFeedAttachment `json:"attachments,omitempty"`
+}
+
+type JSONFeedAttachment struct {
+	URL      string `json:"url"`
+	MimeType string `json:"mime_type"`
+	Title    string `json:"title,omitempty"`
+}
+
+// buildJSONFeed builds a JSON Feed 1.1 document. next_url points at the
+// next, older page, or at the previous archive from an archive document.
+func (h *Handler) buildJSONFeed(info feedInfo, articles []model.Article, host string) JSONFeed {
+	nextURL := info.Links.Next
+	if info.Links.Archive {
+		nextURL = info.Links.PrevArchive
+	}
+
+	feed := JSONFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       info.Title,
+		HomePageURL: info.HomePage,
+		FeedURL:     info.FeedURL,
+		Description: info.Description,
+		NextURL:     nextURL,
+		Language:    "zh-CN",
+		Items:       []JSONFeedItem{},
+	}
+	if info.Icon != "" {
+		feed.Icon = proxiedImage(host, info.Icon)
+		feed.Favicon = feed.Icon
+	}
+	if info.Hub != "" {
+		feed.Hubs = []JSONFeedHub{{Type: "WebSub", URL: info.Hub}}
+	}
+	if l := info.Links; l.First != "" || l.Archive {
+		feed.History = &JSONFeedHistory{
+			About:       "https://www.rfc-editor.org/rfc/rfc5005",
+			First:       l.First,
+			Previous:    l.Previous,
+			Next:        l.Next,
+			PrevArchive: l.PrevArchive,
+			NextArchive: l.NextArchive,
+			Archive:     l.Archive,
+		}
+		if l.Archive {
+			feed.History.Current = l.Current
+		}
+	}
+	if info.Author != "" {
+		feed.Authors = []JSONFeedAuthor{{Name: info.Author, URL: info.HomePage, Avatar: feed.Icon}}
+	}
+
+	for _, a := range articles {
+		published := a.PublishedAt
+		if published.IsZero() {
+			published = a.CreatedAt
+		}
+		if published.IsZero() {
+			published = time.Now()
+		}
+
+		item := JSONFeedItem{
+			ID:            articleGUID(a),
+			URL:           a.Link,
+			ExternalURL:   a.SourceURL,
+			Title:         itemTitle(a),
+			ContentHTML:   h.fetcherSvc.RewriteMedia(a.Content, host),
+			Summary:       h.fetcherSvc.CleanDescription(a.Description),
+			DatePublished: published.Format(time.RFC3339),
+		}
+
+		if !a.UpdatedAt.IsZero() {
+			item.DateModified = a.UpdatedAt.Format(time.RFC3339)
+		}
+
+		// WeChat covers are wide, so they double as the banner
+		if a.Cover != "" {
+			item.Image = proxiedImage(host, a.Cover)
+			item.BannerImage = item.Image
+		} else if images := h.fetcherSvc.ExtractImages(a.Content); len(images) > 0 {
+			item.Image = proxiedImage(host, images[0])
+		}
+
+		if author := itemAuthor(info, a); author != "" {
+			item.Authors = []JSONFeedAuthor{{Name: author}}
+		}
+		if a.ChannelName != "" {
+			item.Tags = []string{a.ChannelName}
+		}
+
+		for _, m := range service.ExtractMedia(a.Content) {
+			item.Attachments = append(item.Attachments, JSONFeedAttachment{
+				URL:      proxiedVideo(host, m.URL),
+				MimeType: m.MimeType,
+				Title:    m.Title,
+			})
+		}
+
+		feed.Items = append(feed.Items, item)
+	}
+
+	return feed
+}
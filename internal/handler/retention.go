@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/service"
+)
+
+// Retention handlers
+func (h *Handler) GetRetention(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"err": "",
+		"data": gin.H{
+			"enabled":          viper.GetBool("retention.enabled"),
+			"time":             viper.GetString("retention.time"),
+			"keepCount":        viper.GetInt("rss.keep_old_count"),
+			"maxAge":           viper.GetDuration("retention.max_age").String(),
+			"vacuumMinDeleted": viper.GetInt("retention.vacuum_min_deleted"),
+			"reports":          h.retention.Reports(),
+		},
+	})
+}
+
+// RunRetention runs the retention job now. With ?dry_run=1 it only
+// reports what would be deleted.
+func (h *Handler) RunRetention(c *gin.Context) {
+	report, err := h.retention.Run(c.Query("dry_run") == "1")
+	if errors.Is(err, service.ErrRetentionRunning) {
+		c.JSON(http.StatusConflict, model.APIResponse{Err: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"err": err.Error(), "data": report})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": report,
+	})
+}
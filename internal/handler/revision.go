@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/service"
+)
+
+// Article revision handlers
+func (h *Handler) ListArticleRevisions(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Invalid article ID"})
+		return
+	}
+
+	revisions, err := h.store.GetArticleRevisions(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Article not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": revisions,
+	})
+}
+
+// GetArticleRevision returns a revision of an article with its content
+func (h *Handler) GetArticleRevision(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Invalid article ID"})
+		return
+	}
+	rev, err := strconv.Atoi(c.Param("rev"))
+	if err != nil || rev < 1 {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Invalid revision"})
+		return
+	}
+
+	revision, err := h.store.GetArticleRevision(id, rev)
+	if err != nil {
+		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Revision not found"})
+		return
+	}
+	revision.Content = h.fetcherSvc.RewriteMedia(revision.Content, feedHost())
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": revision,
+	})
+}
+
+// DiffArticleRevisions compares the text of two revisions of an article,
+// ?from= and ?to=. They default to the previous and the current revision.
+func (h *Handler) DiffArticleRevisions(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Invalid article ID"})
+		return
+	}
+
+	revisions, err := h.store.GetArticleRevisions(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Article not found"})
+		return
+	}
+	current := revisions[len(revisions)-1].Revision
+
+	to, ok := revisionParam(c, "to", current)
+	if !ok {
+		return
+	}
+	from, ok := revisionParam(c, "from", max(to-1, 1))
+	if !ok {
+		return
+	}
+
+	fromRev, err := h.store.GetArticleRevision(id, from)
+	if err != nil {
+		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Revision not found: " + strconv.Itoa(from)})
+		return
+	}
+	toRev, err := h.store.GetArticleRevision(id, to)
+	if err != nil {
+		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Revision not found: " + strconv.Itoa(to)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err": "",
+		"data": gin.H{
+			"from":  from,
+			"to":    to,
+			"lines": service.DiffRevisions(fromRev, toRev),
+		},
+	})
+}
+
+// revisionParam reads a revision number from the query, writing the
+// error response when it is invalid
+func revisionParam(c *gin.Context, key string, def int) (int, bool) {
+	value := c.Query(key)
+	if value == "" {
+		return def, true
+	}
+	rev, err := strconv.Atoi(value)
+	if err != nil || rev < 1 {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Invalid revision: " + value})
+		return 0, false
+	}
+	return rev, true
+}
+
+// Re-check handlers
+func (h *Handler) GetRecheck(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"err": "",
+		"data": gin.H{
+			"enabled":   viper.GetBool("revisions.enabled"),
+			"window":    viper.GetDuration("revisions.window").String(),
+			"interval":  viper.GetDuration("revisions.interval").String(),
+			"batchSize": viper.GetInt("revisions.batch_size"),
+			"reports":   h.recheck.Reports(),
+		},
+	})
+}
+
+// RunRecheck re-checks a batch of recent articles now
+func (h *Handler) RunRecheck(c *gin.Context) {
+	report, err := h.recheck.Run()
+	if errors.Is(err, service.ErrRecheckRunning) {
+		c.JSON(http.StatusConflict, model.APIResponse{Err: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"err": err.Error(), "data": report})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": report,
+	})
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/store"
+)
+
+// SearchArticles runs a full-text search. Query parameters: q (required),
+// bid (channel), group (id or slug), from and to (YYYYMMDD, inclusive),
+// sort (rank or date), page and size.
+func (h *Handler) SearchArticles(c *gin.Context) {
+	query := store.SearchQuery{
+		Query: c.Query("q"),
+		From:  c.Query("from"),
+		To:    c.Query("to"),
+		Sort:  c.DefaultQuery("sort", "rank"),
+	}
+	query.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	query.Size, _ = strconv.Atoi(c.DefaultQuery("size", "20"))
+	if query.Size <= 0 || query.Size > 100 {
+		query.Size = 20
+	}
+
+	if query.Sort != "rank" && query.Sort != "date" {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "sort must be rank or date"})
+		return
+	}
+	for _, date := range []string{query.From, query.To} {
+		if _, err := time.Parse("20060102", date); date != "" && err != nil {
+			c.JSON(http.StatusBadRequest, model.APIResponse{Err: "from and to must be dates such as 20240501"})
+			return
+		}
+	}
+
+	if bid := c.Query("bid"); bid != "" {
+		query.BizID = h.fetcherSvc.ParseBizID(bid)
+	}
+	if param := c.Query("group"); param != "" {
+		var group *model.Group
+		var err error
+		if id, convErr := strconv.ParseInt(param, 10, 64); convErr == nil {
+			group, err = h.store.GetGroupByID(id)
+		} else {
+			group, err = h.store.GetGroupBySlug(param)
+		}
+		if err != nil {
+			c.JSON(http.StatusNotFound, model.APIResponse{Err: "Group not found"})
+			return
+		}
+		query.GroupID = group.ID
+	}
+
+	hits, total, err := h.store.SearchArticles(query)
+	if err == store.ErrEmptySearch {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	names := make(map[string]string)
+	data := []gin.H{}
+	for _, hit := range hits {
+		name, ok := names[hit.BizID]
+		if !ok {
+			if ch, err := h.store.GetChannelByBizID(hit.BizID); err == nil && ch != nil {
+				name = ch.Name
+			}
+			names[hit.BizID] = name
+		}
+
+		data = append(data, gin.H{
+			"id":         hit.ID,
+			"biz_id":     hit.BizID,
+			"biz_name":   name,
+			"title":      hit.Title,
+			"title_html": hit.TitleHTML,
+			"snippet":    hit.Snippet,
+			"desc":       hit.Description,
+			"created":    hit.PublishedAt.Format(time.RFC3339),
+			"link":       hit.Link,
+			"cover":      hit.Cover,
+			"score":      hit.Score,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": data,
+		"meta": gin.H{
+			"total": total,
+			"page":  query.Page,
+			"size":  query.Size,
+		},
+	})
+}
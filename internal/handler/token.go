@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/service"
+)
+
+// tokenRequest is the body of feed token create requests. The token
+// expires at ExpiresAt, or ExpiresIn (a duration such as "720h") after its
+// creation; without either it never expires.
+type tokenRequest struct {
+	Label     string    `json:"label"`
+	Scope     string    `json:"scope"`
+	Target    string    `json:"target"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	ExpiresIn string    `json:"expiresIn"`
+}
+
+// Feed token handlers
+func (h *Handler) ListTokens(c *gin.Context) {
+	tokens, err := h.store.GetFeedTokens()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+	if tokens == nil {
+		tokens = []model.FeedToken{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":  "",
+		"data": tokens,
+	})
+}
+
+// CreateToken creates a feed token. The token itself is only returned
+// here, together with the URL of the feed it grants access to.
+func (h *Handler) CreateToken(c *gin.Context) {
+	var req tokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	feedPath, ok := h.validateToken(c, &req)
+	if !ok {
+		return
+	}
+
+	plain := service.GenerateFeedToken()
+	token, err := h.store.CreateFeedToken(service.HashFeedToken(plain), req.Label, req.Scope, req.Target, req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+	token.Token = plain
+
+	c.JSON(http.StatusOK, gin.H{
+		"err":     "",
+		"data":    token,
+		"feedUrl": feedHost() + feedPath + ".xml?token=" + url.QueryEscape(plain),
+	})
+}
+
+// RevokeToken revokes a feed token. The token is kept so its label and
+// last use stay visible.
+func (h *Handler) RevokeToken(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Token not found"})
+		return
+	}
+	if _, err := h.store.GetFeedTokenByID(id); err != nil {
+		c.JSON(http.StatusNotFound, model.APIResponse{Err: "Token not found"})
+		return
+	}
+
+	if err := h.store.RevokeFeedToken(id); err != nil {
+		c.JSON(http.StatusInternalServerError, model.APIResponse{Err: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.APIResponse{Err: ""})
+}
+
+// validateToken checks req, normalizes its target and expiry, and returns
+// the path of the feed the token grants access to
+func (h *Handler) validateToken(c *gin.Context, req *tokenRequest) (string, bool) {
+	req.Label = strings.TrimSpace(req.Label)
+	if req.Label == "" {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "label is required"})
+		return "", false
+	}
+
+	var feedPath string
+	switch req.Scope {
+	case model.TokenScopeAll:
+		req.Target = ""
+		feedPath = "/feed/all"
+
+	case model.TokenScopeChannel:
+		bizID := h.fetcherSvc.ParseBizID(req.Target)
+		if _, err := h.store.GetChannelByBizID(bizID); err != nil {
+			c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Channel not found: " + req.Target})
+			return "", false
+		}
+		req.Target = bizID
+		feedID := bizID
+		if viper.GetBool("rss.enc_feed_id") {
+			feedID = h.wechatSvc.EncryptFeedID(bizID)
+		}
+		feedPath = "/feed/" + feedID
+
+	case model.TokenScopeGroup:
+		var group *model.Group
+		var err error
+		if id, convErr := strconv.ParseInt(req.Target, 10, 64); convErr == nil {
+			group, err = h.store.GetGroupByID(id)
+		} else {
+			group, err = h.store.GetGroupBySlug(req.Target)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, model.APIResponse{Err: "Group not found: " + req.Target})
+			return "", false
+		}
+		req.Target = strconv.FormatInt(group.ID, 10)
+		feedPath = "/feed/group/" + group.Slug
+
+	default:
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "scope must be all, channel or group"})
+		return "", false
+	}
+
+	if req.ExpiresIn != "" {
+		ttl, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			c.JSON(http.StatusBadRequest, model.APIResponse{Err: "expiresIn must be a positive duration such as 720h"})
+			return "", false
+		}
+		req.ExpiresAt = time.Now().Add(ttl)
+	}
+	if !req.ExpiresAt.IsZero() && req.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, model.APIResponse{Err: "expiresAt is in the past"})
+		return "", false
+	}
+	return feedPath, true
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"wechatoarss/internal/service"
+)
+
+// WebSubHub accepts subscribe and unsubscribe requests for our feeds. The
+// subscriber's intent is verified asynchronously after the 202 response.
+func (h *Handler) WebSubHub(c *gin.Context) {
+	if h.hub == nil {
+		c.String(http.StatusNotFound, "WebSub hub is disabled")
+		return
+	}
+
+	var lease time.Duration
+	if s := c.PostForm("hub.lease_seconds"); s != "" {
+		seconds, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || seconds < 0 {
+			c.String(http.StatusBadRequest, "invalid hub.lease_seconds")
+			return
+		}
+		lease = time.Duration(seconds) * time.Second
+	}
+
+	err := h.hub.Request(service.WebSubRequest{
+		Mode:     c.PostForm("hub.mode"),
+		Callback: c.PostForm("hub.callback"),
+		Topic:    c.PostForm("hub.topic"),
+		Secret:   c.PostForm("hub.secret"),
+		Lease:    lease,
+	})
+	if errors.Is(err, service.ErrTooManySubscriptions) {
+		c.String(http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
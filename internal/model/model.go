@@ -4,15 +4,15 @@ import "time"
 
 // Account represents a WeChat account
 type Account struct {
-	ID         int64     `json:"id" db:"id"`
-	Name       string    `json:"name" db:"name"`
-	Cookie     string    `json:"-" db:"cookie"`
-	Token      string    `json:"-" db:"token"`
-	Available  bool      `json:"available" db:"available"`
-	NeedCheck  bool      `json:"needCheck" db:"need_check"`
-	WaitTime   time.Time `json:"waitTime" db:"wait_time"`
-	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt  time.Time `json:"updatedAt" db:"updated_at"`
+	ID        int64     `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	Cookie    string    `json:"-" db:"cookie"`
+	Token     string    `json:"-" db:"token"`
+	Available bool      `json:"available" db:"available"`
+	NeedCheck bool      `json:"needCheck" db:"need_check"`
+	WaitTime  time.Time `json:"waitTime" db:"wait_time"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
 }
 
 // Channel represents a subscribed WeChat public account
@@ -40,8 +40,93 @@ type Article struct {
 	Content     string    `json:"content" db:"content"`
 	Link        string    `json:"link" db:"link"`
 	Cover       string    `json:"cover" db:"cover"`
+	Author      string    `json:"author" db:"author"`        // byline of the article page
+	SourceURL   string    `json:"sourceUrl" db:"source_url"` // target of the "阅读原文" link
 	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
 	PublishedAt time.Time `json:"publishedAt" db:"published_at"`
+	Starred     bool      `json:"starred" db:"starred"`
+	Pinned      bool      `json:"pinned" db:"pinned"`
+	ContentHash string    `json:"-" db:"content_hash"`
+	Status      string    `json:"status" db:"status"`
+	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"` // zero until an edit is recorded
+	CheckedAt   time.Time `json:"checkedAt" db:"checked_at"`
+}
+
+// Article statuses
+const (
+	ArticleStatusPublished = "published"
+	ArticleStatusDeleted   = "deleted"  // deleted by its author
+	ArticleStatusViolated  = "violated" // taken down by WeChat
+)
+
+// ArticleRevision is a version of an article. Revisions are numbered from
+// 1, the version first saved; the highest is the current one.
+type ArticleRevision struct {
+	Revision    int       `json:"revision" db:"revision"`
+	Title       string    `json:"title" db:"title"`
+	Description string    `json:"desc" db:"description"`
+	Content     string    `json:"content,omitempty" db:"content"`
+	ContentHash string    `json:"contentHash" db:"content_hash"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"` // when this version was saved
+	Current     bool      `json:"current" db:"-"`
+}
+
+// DiffLine is a line of a diff between two revisions. Op is "=" for a
+// line both have, "-" for a removed line and "+" for an added one.
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// RecheckReport describes one run of the article re-check job
+type RecheckReport struct {
+	StartedAt time.Time `json:"startedAt"`
+	Duration  string    `json:"duration"`
+	Checked   int       `json:"checked"`
+	Updated   int       `json:"updated"`
+	Deleted   int       `json:"deleted"`
+	Violated  int       `json:"violated"`
+	Failed    int       `json:"failed"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// SearchHit is an article matching a search. TitleHTML and Snippet are
+// escaped HTML with the matches wrapped in <mark>.
+type SearchHit struct {
+	Article
+	TitleHTML string  `json:"titleHtml"`
+	Snippet   string  `json:"snippet"`
+	Score     float64 `json:"score"`
+}
+
+// RetentionReport describes one run of the retention job
+type RetentionReport struct {
+	StartedAt  time.Time      `json:"startedAt"`
+	Duration   string         `json:"duration"`
+	DryRun     bool           `json:"dryRun"`
+	KeepCount  int            `json:"keepCount"`
+	MaxAge     string         `json:"maxAge"`
+	Deleted    int64          `json:"deleted"`
+	Channels   []ChannelPrune `json:"channels"`
+	Vacuumed   bool           `json:"vacuumed"`
+	FreedBytes int64          `json:"freedBytes"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// ChannelPrune counts the articles the retention job removed from a
+// channel
+type ChannelPrune struct {
+	BizID   string `json:"biz_id"`
+	Name    string `json:"name"`
+	ByAge   int64  `json:"byAge"`
+	ByCount int64  `json:"byCount"`
+}
+
+// Backup is a database snapshot in the backup directory
+type Backup struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
 }
 
 // RSSItem represents an item in RSS feed
@@ -54,23 +139,77 @@ type RSSItem struct {
 	Cover       string
 }
 
+// ArticleFilter holds include/exclude rules for the articles of a feed.
+// An article passes when it matches at least one include rule (if any),
+// no exclude rule, and has at least MinLength characters of text.
+type ArticleFilter struct {
+	Include      []string `json:"include,omitempty"` // keywords
+	Exclude      []string `json:"exclude,omitempty"` // keywords
+	IncludeRegex string   `json:"includeRegex,omitempty"`
+	ExcludeRegex string   `json:"excludeRegex,omitempty"`
+	Fields       []string `json:"fields,omitempty"` // title, digest, content; all when empty
+	MinLength    int      `json:"minLength,omitempty"`
+}
+
+// Group is a named set of channels with its own aggregate feed
+type Group struct {
+	ID          int64     `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	Slug        string    `json:"slug" db:"slug"`
+	Description string    `json:"description" db:"description"`
+	Channels    []string  `json:"channels" db:"-"` // biz ids
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+}
+
+// Feed token scopes
+const (
+	TokenScopeAll     = "all"
+	TokenScopeChannel = "channel"
+	TokenScopeGroup   = "group"
+)
+
+// FeedToken grants read access to the feeds in its scope. Target is the
+// biz id of a channel scope or the id of a group scope.
+type FeedToken struct {
+	ID         int64     `json:"id" db:"id"`
+	Token      string    `json:"token,omitempty" db:"-"` // only returned on creation
+	Label      string    `json:"label" db:"label"`
+	Scope      string    `json:"scope" db:"scope"`
+	Target     string    `json:"target,omitempty" db:"target"`
+	ExpiresAt  time.Time `json:"expiresAt" db:"expires_at"`
+	LastUsedAt time.Time `json:"lastUsedAt" db:"last_used_at"`
+	RevokedAt  time.Time `json:"revokedAt" db:"revoked_at"`
+	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
+}
+
+// WebSubSubscription represents a subscriber of the built-in WebSub hub
+type WebSubSubscription struct {
+	ID           int64     `json:"id" db:"id"`
+	Callback     string    `json:"callback" db:"callback"`
+	Topic        string    `json:"topic" db:"topic"`
+	Secret       string    `json:"-" db:"secret"`
+	LeaseSeconds int64     `json:"leaseSeconds" db:"lease_seconds"`
+	ExpiresAt    time.Time `json:"expiresAt" db:"expires_at"`
+	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+}
+
 // Config represents system configuration
 type Config struct {
-	Host                 string   `json:"host" yaml:"host"`
-	Token               string   `json:"token" yaml:"token"`
-	RSSToken            string   `json:"rssToken" yaml:"rss_token"`
-	MaxItemCount        int      `json:"maxItemCount" yaml:"max_item_count"`
-	KeepOldCount        int      `json:"keepOldCount" yaml:"keep_old_count"`
-	EncFeedID           bool     `json:"encFeedId" yaml:"enc_feed_id"`
-	Static              bool     `json:"static" yaml:"static"`
-	SchedulerTimes      []string `json:"schedulerTimes" yaml:"scheduler_times"`
-	NotifyEnabled       bool     `json:"notifyEnabled" yaml:"notify_enabled"`
-	NotifyType          string   `json:"notifyType" yaml:"notify_type"`
-	TelegramToken       string   `json:"telegramToken" yaml:"telegram_token"`
-	TelegramAdminUID    string   `json:"telegramAdminUid" yaml:"telegram_admin_uid"`
-	ServerChanKey       string   `json:"serverChanKey" yaml:"server_chan_key"`
-	WebhookURL          string   `json:"webhookUrl" yaml:"webhook_url"`
-	BarkURL             string   `json:"barkUrl" yaml:"bark_url"`
+	Host             string   `json:"host" yaml:"host"`
+	Token            string   `json:"token" yaml:"token"`
+	RSSToken         string   `json:"rssToken" yaml:"rss_token"`
+	MaxItemCount     int      `json:"maxItemCount" yaml:"max_item_count"`
+	KeepOldCount     int      `json:"keepOldCount" yaml:"keep_old_count"`
+	EncFeedID        bool     `json:"encFeedId" yaml:"enc_feed_id"`
+	Static           bool     `json:"static" yaml:"static"`
+	SchedulerTimes   []string `json:"schedulerTimes" yaml:"scheduler_times"`
+	NotifyEnabled    bool     `json:"notifyEnabled" yaml:"notify_enabled"`
+	NotifyType       string   `json:"notifyType" yaml:"notify_type"`
+	TelegramToken    string   `json:"telegramToken" yaml:"telegram_token"`
+	TelegramAdminUID string   `json:"telegramAdminUid" yaml:"telegram_admin_uid"`
+	ServerChanKey    string   `json:"serverChanKey" yaml:"server_chan_key"`
+	WebhookURL       string   `json:"webhookUrl" yaml:"webhook_url"`
+	BarkURL          string   `json:"barkUrl" yaml:"bark_url"`
 }
 
 // LoginResponse represents login QR code response
@@ -81,14 +220,14 @@ type LoginResponse struct {
 
 // LoginResult represents login result
 type LoginResult struct {
-	Err   string `json:"err"`
-	Tips  string `json:"tips"`
+	Err      string `json:"err"`
+	Tips     string `json:"tips"`
 	RedirURL string `json:"redir_url"`
 }
 
 // APIResponse represents standard API response
 type APIResponse struct {
-	Err   string      `json:"err"`
-	Data  interface{} `json:"data,omitempty"`
-	Meta  interface{} `json:"meta,omitempty"`
+	Err  string      `json:"err"`
+	Data interface{} `json:"data,omitempty"`
+	Meta interface{} `json:"meta,omitempty"`
 }
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/store"
+)
+
+// Snapshots are named after the time they were taken, so they sort by age
+const (
+	backupPrefix = "wechatoarss-"
+	backupSuffix = ".db"
+)
+
+// BackupService takes online backups of the database. Snapshots go to
+// backup.dir, where the newest backup.keep are kept.
+type BackupService struct {
+	store store.Store
+	mu    sync.Mutex
+}
+
+func NewBackupService(st store.Store) *BackupService {
+	return &BackupService{store: st}
+}
+
+// BackupDir returns backup.dir, by default the backups directory next to
+// the database
+func BackupDir() string {
+	if dir := viper.GetString("backup.dir"); dir != "" {
+		return dir
+	}
+	return filepath.Join(store.DataDir(), "backups")
+}
+
+// BackupName returns the file name of a snapshot taken at t
+func BackupName(t time.Time) string {
+	return backupPrefix + t.Format("20060102-150405") + backupSuffix
+}
+
+// WriteBackup writes a backup of the database to path, replacing any file
+// there only once the backup passes verification
+func (s *BackupService) WriteBackup(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := s.store.Backup(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := store.VerifyBackup(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Snapshot writes a backup into the backup directory, then removes the
+// oldest snapshots beyond backup.keep
+func (s *BackupService) Snapshot() (*model.Backup, error) {
+	dir := BackupDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	path := filepath.Join(dir, BackupName(start))
+	if err := s.WriteBackup(path); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Backup: wrote %s (%d KB) in %s", path, info.Size()/1024, time.Since(start).Round(time.Millisecond))
+
+	if err := s.rotate(); err != nil {
+		log.Printf("Backup: failed to remove old snapshots: %v", err)
+	}
+	return &model.Backup{Name: info.Name(), Size: info.Size(), CreatedAt: info.ModTime()}, nil
+}
+
+// rotate removes the oldest snapshots beyond backup.keep
+func (s *BackupService) rotate() error {
+	keep := viper.GetInt("backup.keep")
+	if keep <= 0 {
+		return nil
+	}
+
+	backups, err := s.Snapshots()
+	if err != nil {
+		return err
+	}
+	for _, b := range backups[min(keep, len(backups)):] {
+		if err := os.Remove(filepath.Join(BackupDir(), b.Name)); err != nil {
+			return err
+		}
+		log.Printf("Backup: removed old snapshot %s", b.Name)
+	}
+	return nil
+}
+
+// Snapshots returns the snapshots in the backup directory, newest first
+func (s *BackupService) Snapshots() ([]model.Backup, error) {
+	entries, err := os.ReadDir(BackupDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return []model.Backup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []model.Backup{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, model.Backup{Name: name, Size: info.Size(), CreatedAt: info.ModTime()})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name > backups[j].Name
+	})
+	return backups, nil
+}
+
+// SnapshotPath returns the path of the snapshot called name
+func (s *BackupService) SnapshotPath(name string) (string, error) {
+	if name != filepath.Base(name) || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+		return "", fmt.Errorf("invalid backup name: %s", name)
+	}
+	path := filepath.Join(BackupDir(), name)
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/store"
+)
+
+// maxDiffLines bounds the lines compared by DiffRevisions. Past it the
+// texts are shown as wholly removed and added rather than aligned.
+const maxDiffLines = 5000
+
+// DiffRevisions compares the text of two revisions line by line. The
+// title is compared as the first line.
+func DiffRevisions(from, to *model.ArticleRevision) []model.DiffLine {
+	a := append([]string{from.Title}, store.ContentLines(from.Content)...)
+	b := append([]string{to.Title}, store.ContentLines(to.Content)...)
+	return diffLines(a, b)
+}
+
+// diffLines returns a shortest edit script turning a into b, from their
+// longest common subsequence
+func diffLines(a, b []string) []model.DiffLine {
+	// Common prefix and suffix need no table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var diff []model.DiffLine
+	for _, line := range a[:prefix] {
+		diff = append(diff, model.DiffLine{Op: "=", Text: line})
+	}
+
+	x, y := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(x) > maxDiffLines || len(y) > maxDiffLines {
+		for _, line := range x {
+			diff = append(diff, model.DiffLine{Op: "-", Text: line})
+		}
+		for _, line := range y {
+			diff = append(diff, model.DiffLine{Op: "+", Text: line})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of
+		// x[i:] and y[j:]
+		lcs := make([][]int32, len(x)+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, len(y)+1)
+		}
+		for i := len(x) - 1; i >= 0; i-- {
+			for j := len(y) - 1; j >= 0; j-- {
+				if x[i] == y[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(x) || j < len(y) {
+			switch {
+			case i < len(x) && j < len(y) && x[i] == y[j]:
+				diff = append(diff, model.DiffLine{Op: "=", Text: x[i]})
+				i++
+				j++
+			case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+				diff = append(diff, model.DiffLine{Op: "-", Text: x[i]})
+				i++
+			default:
+				diff = append(diff, model.DiffLine{Op: "+", Text: y[j]})
+				j++
+			}
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		diff = append(diff, model.DiffLine{Op: "=", Text: line})
+	}
+	return diff
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"wechatoarss/internal/model"
+)
+
+// ArticlePage is the content and metadata extracted from an article page
+type ArticlePage struct {
+	Title       string
+	Author      string
+	PublishedAt time.Time
+	SourceURL   string // target of the "阅读原文" link
+	Cover       string
+	Content     string // inner HTML of #js_content
+	Removed     string // model.ArticleStatusDeleted or ArticleStatusViolated for a takedown notice
+}
+
+// removalNotices are the messages WeChat shows instead of a removed
+// article, and the status each one means
+var removalNotices = []struct {
+	text   string
+	status string
+}{
+	{"该内容已被发布者删除", model.ArticleStatusDeleted},
+	{"此内容已被发布者删除", model.ArticleStatusDeleted},
+	{"此内容因违规无法查看", model.ArticleStatusViolated},
+	{"此内容被多人投诉", model.ArticleStatusViolated},
+	{"涉嫌违反相关法律法规和政策", model.ArticleStatusViolated},
+}
+
+var (
+	scriptCreateTimeRegex = regexp.MustCompile(`var\s+(?:ct|create_time)\s*=\s*["']?(\d{9,10})`)
+	scriptSourceURLRegex  = regexp.MustCompile(`var\s+msg_source_url\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	scriptTitleRegex      = regexp.MustCompile(`var\s+msg_title\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// ParseArticlePage parses an article page into its content and metadata
+func ParseArticlePage(r io.Reader) (*ArticlePage, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ArticlePage{}
+
+	if content := findByID(doc, "js_content"); content != nil {
+		page.Content = renderChildren(content)
+	} else {
+		// Removed articles are served as a notice page with status 200
+		text := textContent(doc)
+		for _, notice := range removalNotices {
+			if strings.Contains(text, notice.text) {
+				page.Removed = notice.status
+				return page, nil
+			}
+		}
+	}
+
+	// Meta tags carry most fields; the rich_media elements are fallbacks
+	walk(doc, func(n *html.Node) {
+		if n.DataAtom != atom.Meta {
+			return
+		}
+		key := attr(n, "property")
+		if key == "" {
+			key = attr(n, "name")
+		}
+		value := strings.TrimSpace(attr(n, "content"))
+		switch key {
+		case "og:title", "twitter:title":
+			if page.Title == "" {
+				page.Title = value
+			}
+		case "author", "og:article:author":
+			if page.Author == "" {
+				page.Author = value
+			}
+		case "og:image", "twitter:image":
+			if page.Cover == "" {
+				page.Cover = value
+			}
+		}
+	})
+
+	if page.Title == "" {
+		if n := findByID(doc, "activity-name"); n != nil {
+			page.Title = strings.TrimSpace(textContent(n))
+		}
+	}
+	if page.Author == "" {
+		if n := findByID(doc, "js_author_name"); n != nil {
+			page.Author = strings.TrimSpace(textContent(n))
+		}
+	}
+	if n := findByID(doc, "js_view_source"); n != nil {
+		page.SourceURL = attr(n, "href")
+	}
+
+	// Publish time and source URL are only present in inline scripts
+	walk(doc, func(n *html.Node) {
+		if n.DataAtom != atom.Script {
+			return
+		}
+		script := textContent(n)
+
+		if page.PublishedAt.IsZero() {
+			if m := scriptCreateTimeRegex.FindStringSubmatch(script); m != nil {
+				if ts, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+					page.PublishedAt = time.Unix(ts, 0)
+				}
+			}
+		}
+		if page.SourceURL == "" || page.SourceURL == "javascript:;" {
+			if m := scriptSourceURLRegex.FindStringSubmatch(script); m != nil {
+				page.SourceURL = unescapeJSString(m[1] + m[2])
+			}
+		}
+		if page.Title == "" {
+			if m := scriptTitleRegex.FindStringSubmatch(script); m != nil {
+				page.Title = unescapeJSString(m[1] + m[2])
+			}
+		}
+	})
+
+	if page.SourceURL == "javascript:;" {
+		page.SourceURL = ""
+	}
+
+	return page, nil
+}
+
+// walk calls fn for n and every node below it, in document order
+func walk(n *html.Node, fn func(*html.Node)) {
+	fn(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
+
+func findByID(n *html.Node, id string) *html.Node {
+	if n.Type == html.ElementNode && attr(n, "id") == id {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findByID(c, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	var buf strings.Builder
+	walk(n, func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+	})
+	return buf.String()
+}
+
+// renderChildren renders the inner HTML of n
+func renderChildren(n *html.Node) string {
+	var buf bytes.Buffer
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		html.Render(&buf, c)
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// unescapeJSString decodes the escapes WeChat uses in inline script strings
+func unescapeJSString(s string) string {
+	replacer := strings.NewReplacer(
+		`\x26`, "&",
+		`\x3d`, "=",
+		`\x3c`, "<",
+		`\x3e`, ">",
+		`\x22`, `"`,
+		`\x27`, "'",
+		`\/`, "/",
+	)
+	return html.UnescapeString(replacer.Replace(s))
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wechatoarss/internal/model"
+)
+
+// expectedPage is an entry of testdata/articles/expected.json
+type expectedPage struct {
+	Title           string   `json:"title"`
+	Author          string   `json:"author"`
+	PublishedAt     int64    `json:"publishedAt"`
+	SourceURL       string   `json:"sourceURL"`
+	Cover           string   `json:"cover"`
+	ContentContains []string `json:"contentContains"`
+}
+
+func TestParseArticlePage(t *testing.T) {
+	dir := filepath.Join("testdata", "articles")
+	data, err := os.ReadFile(filepath.Join(dir, "expected.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected map[string]expectedPage
+	if err := json.Unmarshal(data, &expected); err != nil {
+		t.Fatal(err)
+	}
+	if len(expected) == 0 {
+		t.Fatal("expected.json lists no pages")
+	}
+
+	for name, want := range expected {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			page, err := ParseArticlePage(f)
+			if err != nil {
+				t.Fatalf("ParseArticlePage: %v", err)
+			}
+
+			if page.Title != want.Title {
+				t.Errorf("Title = %q, want %q", page.Title, want.Title)
+			}
+			if page.Author != want.Author {
+				t.Errorf("Author = %q, want %q", page.Author, want.Author)
+			}
+			if got := page.PublishedAt.Unix(); page.PublishedAt.IsZero() || got != want.PublishedAt {
+				t.Errorf("PublishedAt = %v (%d), want %d", page.PublishedAt, got, want.PublishedAt)
+			}
+			if page.SourceURL != want.SourceURL {
+				t.Errorf("SourceURL = %q, want %q", page.SourceURL, want.SourceURL)
+			}
+			if page.Cover != want.Cover {
+				t.Errorf("Cover = %q, want %q", page.Cover, want.Cover)
+			}
+			for _, s := range want.ContentContains {
+				if !strings.Contains(page.Content, s) {
+					t.Errorf("Content does not contain %q", s)
+				}
+			}
+			if page.Removed != "" {
+				t.Errorf("Removed = %q, want none", page.Removed)
+			}
+		})
+	}
+}
+
+func TestParseArticlePageRemoved(t *testing.T) {
+	page, err := ParseArticlePage(strings.NewReader(
+		`<html><body><div class="weui-msg"><p>该内容已被发布者删除</p></div></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Removed != model.ArticleStatusDeleted {
+		t.Errorf("Removed = %q, want deleted", page.Removed)
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// RenderedFeed is a feed document ready to be served
+type RenderedFeed struct {
+	BizIDs       []string // channels in the feed; nil when it has every channel
+	FeedURL      string
+	HubURL       string
+	ContentType  string
+	Body         []byte
+	Gzip         []byte
+	ETag         string
+	LastModified time.Time
+	Archive      bool // an RFC 5005 archive document
+	storedAt     time.Time
+}
+
+// Includes reports whether the channel bizID contributes to the feed
+func (f *RenderedFeed) Includes(bizID string) bool {
+	if f.BizIDs == nil {
+		return true
+	}
+	for _, id := range f.BizIDs {
+		if strings.EqualFold(id, bizID) {
+			return true
+		}
+	}
+	return false
+}
+
+// FeedCache keeps rendered feeds in memory so polling readers do not
+// re-query the database and re-render every article. Entries are dropped
+// when their channel gets new articles, and expire after rss.cache_ttl in
+// case something else changed underneath them.
+type FeedCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*RenderedFeed
+}
+
+func NewFeedCache(maxEntries int) *FeedCache {
+	return &FeedCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*RenderedFeed),
+	}
+}
+
+// NewFeedCacheFromConfig builds the cache configured under rss, or returns
+// nil if it is disabled
+func NewFeedCacheFromConfig() *FeedCache {
+	if !viper.GetBool("rss.cache_enabled") {
+		return nil
+	}
+	maxEntries := viper.GetInt("rss.cache_max_entries")
+	if maxEntries <= 0 {
+		maxEntries = 256
+	}
+	return NewFeedCache(maxEntries)
+}
+
+// ttl is rss.cache_ttl, shortened so cached feeds never carry expired
+// proxy signatures
+func (c *FeedCache) ttl() time.Duration {
+	ttl := viper.GetDuration("rss.cache_ttl")
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	if urlTTL := viper.GetDuration("rss.proxy_url_ttl"); urlTTL > 0 && urlTTL/2 < ttl {
+		ttl = urlTTL / 2
+	}
+	return ttl
+}
+
+// Get returns the feed cached under key, if it has not expired
+func (c *FeedCache) Get(key string) (*RenderedFeed, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	feed, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(feed.storedAt) > c.ttl() {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return feed, true
+}
+
+// Set compresses feed and caches it under key
+func (c *FeedCache) Set(key string, feed *RenderedFeed) {
+	if feed.Gzip == nil {
+		feed.Gzip = gzipBytes(feed.Body)
+	}
+	if c == nil {
+		return
+	}
+	feed.storedAt = time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evictOldest()
+	}
+	c.entries[key] = feed
+}
+
+// Invalidate drops every cached feed that includes the channel bizID
+func (c *FeedCache) Invalidate(bizID string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, feed := range c.entries {
+		if feed.Includes(bizID) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// InvalidateAll empties the cache
+func (c *FeedCache) InvalidateAll() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.entries = make(map[string]*RenderedFeed)
+	c.mu.Unlock()
+}
+
+func (c *FeedCache) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, feed := range c.entries {
+		if oldestKey == "" || feed.storedAt.Before(oldest) {
+			oldestKey = key
+			oldest = feed.storedAt
+		}
+	}
+	delete(c.entries, oldestKey)
+}
+
+func gzipBytes(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/store"
+)
+
+// Feed token errors
+var (
+	ErrTokenInvalid = errors.New("invalid feed token")
+	ErrTokenExpired = errors.New("feed token expired")
+	ErrTokenRevoked = errors.New("feed token revoked")
+	ErrTokenScope   = errors.New("feed token does not grant access to this feed")
+)
+
+// feedTokenPrefix marks feed tokens so they are easy to recognize in URLs
+// and logs
+const feedTokenPrefix = "ft_"
+
+// lastUsedInterval limits how often reading a feed records the token as
+// used, so polling readers do not write to the database on every request
+const lastUsedInterval = time.Minute
+
+// GenerateFeedToken returns a new random feed token
+func GenerateFeedToken() string {
+	return feedTokenPrefix + randomHex(20)
+}
+
+// HashFeedToken returns the hash feed tokens are stored under
+func HashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckFeedToken returns the feed token matching token if it is active and
+// its scope covers the feed of the given scope and target, and records it
+// as used. target is the biz id of a channel feed or the id of a group
+// feed.
+func CheckFeedToken(st store.Store, token, scope, target string) (*model.FeedToken, error) {
+	if !strings.HasPrefix(token, feedTokenPrefix) {
+		return nil, ErrTokenInvalid
+	}
+	t, err := st.GetFeedTokenByHash(HashFeedToken(token))
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	switch {
+	case !t.RevokedAt.IsZero():
+		return nil, ErrTokenRevoked
+	case !t.ExpiresAt.IsZero() && time.Now().After(t.ExpiresAt):
+		return nil, ErrTokenExpired
+	case t.Scope != model.TokenScopeAll && (t.Scope != scope || t.Target != target):
+		return nil, ErrTokenScope
+	}
+
+	if time.Since(t.LastUsedAt) > lastUsedInterval {
+		if err := st.TouchFeedToken(t.ID); err != nil {
+			log.Printf("Failed to record use of feed token %d: %v", t.ID, err)
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/store"
+)
+
+func TestCheckFeedToken(t *testing.T) {
+	st, err := store.OpenSQLite(filepath.Join(t.TempDir(), "wechatoarss.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if _, err := st.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// create stores a token of the given scope and returns it
+	create := func(scope, target string, expiresAt time.Time) string {
+		token := GenerateFeedToken()
+		if _, err := st.CreateFeedToken(HashFeedToken(token), "", scope, target, expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	all := create(model.TokenScopeAll, "", time.Time{})
+	channel := create(model.TokenScopeChannel, "MzA5BIZ_A==", time.Now().Add(time.Hour))
+	group := create(model.TokenScopeGroup, "7", time.Time{})
+	expired := create(model.TokenScopeAll, "", time.Now().Add(-time.Minute))
+	revoked := create(model.TokenScopeAll, "", time.Time{})
+	r, err := st.GetFeedTokenByHash(HashFeedToken(revoked))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RevokeFeedToken(r.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		token         string
+		scope, target string
+		want          error
+	}{
+		{"all feeds", all, model.TokenScopeAll, "", nil},
+		{"all covers a channel", all, model.TokenScopeChannel, "MzA5BIZ_B==", nil},
+		{"all covers a group", all, model.TokenScopeGroup, "8", nil},
+		{"channel", channel, model.TokenScopeChannel, "MzA5BIZ_A==", nil},
+		{"other channel", channel, model.TokenScopeChannel, "MzA5BIZ_B==", ErrTokenScope},
+		{"channel in other case", channel, model.TokenScopeChannel, "MZA5BIZ_A==", ErrTokenScope},
+		{"channel token for all feeds", channel, model.TokenScopeAll, "", ErrTokenScope},
+		{"channel token for a group", channel, model.TokenScopeGroup, "MzA5BIZ_A==", ErrTokenScope},
+		{"group", group, model.TokenScopeGroup, "7", nil},
+		{"other group", group, model.TokenScopeGroup, "70", ErrTokenScope},
+		{"expired", expired, model.TokenScopeAll, "", ErrTokenExpired},
+		{"revoked", revoked, model.TokenScopeAll, "", ErrTokenRevoked},
+		{"unknown", GenerateFeedToken(), model.TokenScopeAll, "", ErrTokenInvalid},
+		{"no prefix", all[len(feedTokenPrefix):], model.TokenScopeAll, "", ErrTokenInvalid},
+		{"empty", "", model.TokenScopeAll, "", ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CheckFeedToken(st, tt.token, tt.scope, tt.target)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("CheckFeedToken = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Using a token records it
+	got, err := st.GetFeedTokenByHash(HashFeedToken(channel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastUsedAt.IsZero() {
+		t.Error("checking a token did not record its use")
+	}
+}
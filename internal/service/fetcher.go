@@ -1,24 +1,38 @@
 package service
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 
 	"wechatoarss/internal/model"
 	"wechatoarss/internal/store"
 )
 
 type FetcherService struct {
-	wechatSvc *WechatService
+	store      store.Store
+	wechatSvc  *WechatService
+	sources    *SourceRegistry
+	mediaCache *MediaCache
+	feedCache  *FeedCache
+	hub        *WebSubHub
 }
 
-func NewFetcherService(wechatSvc *WechatService) *FetcherService {
+func NewFetcherService(st store.Store, wechatSvc *WechatService, sources *SourceRegistry, mediaCache *MediaCache, feedCache *FeedCache, hub *WebSubHub) *FetcherService {
 	return &FetcherService{
-		wechatSvc: wechatSvc,
+		store:      st,
+		wechatSvc:  wechatSvc,
+		sources:    sources,
+		mediaCache: mediaCache,
+		feedCache:  feedCache,
+		hub:        hub,
 	}
 }
 
@@ -27,14 +41,24 @@ func (s *FetcherService) FetchChannel(bizID string) error {
 	log.Printf("Fetching channel: %s", bizID)
 
 	// Get channel info
-	ch, err := store.GetChannelByBizID(bizID)
+	ch, err := s.store.GetChannelByBizID(bizID)
 	if err != nil {
 		return err
 	}
 	_ = ch // use the channel
 
+	src, err := s.sources.ForChannel(bizID)
+	if err != nil {
+		return err
+	}
+
 	// Get articles
-	articles, err := s.wechatSvc.GetArticles(bizID, 0, 20)
+	var articles []model.Article
+	err = s.withAccount(src, func(src Source) error {
+		var err error
+		articles, err = src.GetArticles(bizID, 0, 20)
+		return err
+	})
 	if err != nil {
 		log.Printf("Failed to get articles for %s: %v", bizID, err)
 		return err
@@ -42,44 +66,128 @@ func (s *FetcherService) FetchChannel(bizID string) error {
 
 	// Save articles
 	count := 0
+	inserted := 0
+	var newMedia []string
 	for _, article := range articles {
+		// Get full content if needed, filling in what the listing lacked
+		content := article.Content
+		if content == "" {
+			page, err := s.wechatSvc.GetArticlePage(article.Link)
+			if err != nil {
+				log.Printf("Failed to get content of %s: %v", article.Link, err)
+			} else {
+				content = page.Content
+				if article.Title == "" {
+					article.Title = page.Title
+				}
+				if article.Cover == "" {
+					article.Cover = page.Cover
+				}
+				if article.PublishedAt.IsZero() {
+					article.PublishedAt = page.PublishedAt
+				}
+				if article.Author == "" {
+					article.Author = page.Author
+				}
+				if article.SourceURL == "" {
+					article.SourceURL = page.SourceURL
+				}
+			}
+		}
+		content = s.ParseArticleContent(content)
+
 		// Parse published time
 		publishedAt := article.PublishedAt
 		if publishedAt.IsZero() {
 			publishedAt = time.Now()
 		}
 
-		// Get full content if needed
-		content := article.Content
-		if content == "" {
-			content, _ = s.wechatSvc.GetArticleContent(article.Link)
-		}
-
-		_, err := store.CreateArticle(
+		created, err := s.store.CreateArticle(
 			bizID,
 			article.Title,
 			article.Description,
 			content,
 			article.Link,
 			article.Cover,
+			article.Author,
+			article.SourceURL,
 			publishedAt,
 		)
 		if err != nil {
 			continue
 		}
+		if created != nil {
+			inserted++
+			newMedia = append(newMedia, article.Cover)
+			newMedia = append(newMedia, s.ExtractImages(content)...)
+		}
 		count++
 	}
 
+	if inserted > 0 {
+		s.feedCache.Invalidate(bizID)
+		go s.hub.Publish(bizID)
+	}
+
+	// Cache images of new articles so they survive WeChat CDN expiry
+	if s.mediaCache != nil && viper.GetBool("media_cache.prefetch") && len(newMedia) > 0 {
+		go s.mediaCache.Prefetch(newMedia)
+	}
+
 	// Update channel article count
-	store.UpdateChannelArticleCount(bizID, count)
+	s.store.UpdateChannelArticleCount(bizID, count)
 	log.Printf("Fetched %d articles for channel %s", count, bizID)
 
 	return nil
 }
 
+// withAccount runs fn against src. For sources that need a logged-in
+// account it tries usable accounts least recently used first, putting an
+// account on cooldown when upstream signals frequency control and flagging
+// it for a check when its session is invalid, then failing over to the next.
+func (s *FetcherService) withAccount(src Source, fn func(Source) error) error {
+	accSrc, ok := src.(AccountSource)
+	if !ok {
+		return fn(src)
+	}
+
+	accounts, err := s.store.GetUsableAccounts()
+	if err != nil {
+		return err
+	}
+	if len(accounts) == 0 {
+		return ErrNoAccount
+	}
+
+	cooldown := viper.GetDuration("accounts.freq_cooldown")
+	if cooldown <= 0 {
+		cooldown = time.Hour
+	}
+
+	for i := range accounts {
+		account := &accounts[i]
+		err = fn(accSrc.WithAccount(account))
+		switch {
+		case err == nil:
+			s.store.TouchAccount(account.ID)
+			return nil
+		case errors.Is(err, ErrFreqControl):
+			log.Printf("Account %s hit frequency control, cooling down for %s", account.Name, cooldown)
+			s.store.SetAccountWaitTime(account.ID, time.Now().Add(cooldown))
+		case errors.Is(err, ErrInvalidSession):
+			log.Printf("Account %s session is invalid, marking for check", account.Name)
+			s.store.SetAccountNeedCheck(account.ID, true)
+		default:
+			return err
+		}
+	}
+
+	return fmt.Errorf("all accounts failed: %w", err)
+}
+
 // FetchAll fetches all active channels
 func (s *FetcherService) FetchAll() error {
-	channels, err := store.GetActiveChannels()
+	channels, err := s.store.GetActiveChannels()
 	if err != nil {
 		return err
 	}
@@ -102,27 +210,37 @@ func (s *FetcherService) FetchAll() error {
 // AddChannel adds a new channel
 func (s *FetcherService) AddChannel(bizID string) (string, error) {
 	// Check if already exists
-	existing, err := store.GetChannelByBizID(bizID)
+	existing, err := s.store.GetChannelByBizID(bizID)
 	if err == nil && existing != nil {
 		// Trigger update
 		go s.FetchChannel(bizID)
 		return existing.Link, nil
 	}
 
+	src, err := s.sources.ForChannel(bizID)
+	if err != nil {
+		return "", err
+	}
+
 	// Get channel info
-	channel, err := s.wechatSvc.GetChannelInfo(bizID)
+	var channel *model.Channel
+	err = s.withAccount(src, func(src Source) error {
+		var err error
+		channel, err = src.GetChannelInfo(bizID)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
 
 	// Create channel
-	newChannel, err := store.CreateChannel(
+	newChannel, err := s.store.CreateChannel(
 		channel.BizID,
 		channel.Name,
 		channel.Description,
 		channel.Avatar,
 		channel.Link,
-		1, // Default account
+		channel.AccountID,
 	)
 	if err != nil {
 		return "", err
@@ -145,20 +263,33 @@ func (s *FetcherService) AddChannelByURL(articleURL string) (string, error) {
 	return s.AddChannel(bizID)
 }
 
-// ParseArticleContent parses article HTML content
-func (s *FetcherService) ParseArticleContent(html string) string {
-	// Remove script tags
-	re := regexp.MustCompile(`<script[^>]*>[\s\S]*?</script>`)
-	html = re.ReplaceAllString(html, "")
+// ParseArticleContent parses article HTML content, dropping scripts,
+// styles and comments and promoting lazy-loaded media
+func (s *FetcherService) ParseArticleContent(content string) string {
+	root, err := parseContent(content)
+	if err != nil {
+		return strings.TrimSpace(content)
+	}
 
-	// Remove style tags
-	re = regexp.MustCompile(`<style[^>]*>[\s\S]*?</style>`)
-	html = re.ReplaceAllString(html, "")
+	removeNodes(root, func(n *html.Node) bool {
+		return n.Type == html.CommentNode || n.DataAtom == atom.Script || n.DataAtom == atom.Style
+	})
+	promoteLazyMedia(root)
 
-	// Clean up
-	html = strings.TrimSpace(html)
+	return renderChildren(root)
+}
 
-	return html
+// removeNodes detaches every node below n that matches drop
+func removeNodes(n *html.Node, drop func(*html.Node) bool) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if drop(c) {
+			n.RemoveChild(c)
+		} else {
+			removeNodes(c, drop)
+		}
+		c = next
+	}
 }
 
 // FormatArticleDate formats date for RSS
@@ -211,20 +342,28 @@ func (s *FetcherService) CleanDescription(desc string) string {
 
 // GetArticleCount gets total article count for a channel
 func (s *FetcherService) GetArticleCount(bizID string) (int, error) {
-	_, total, err := store.GetArticles(bizID, "", "", 1, 1, false)
+	_, total, err := s.store.GetArticles(bizID, "", "", 1, 1, false)
 	return total, err
 }
 
 // SearchChannels searches channels
 func (s *FetcherService) SearchChannels(keyword string) ([]model.Channel, error) {
-	channels, total, err := store.GetChannels(1, 20, keyword)
+	channels, total, err := s.store.GetChannels(1, 20, keyword)
 	if err != nil {
 		return nil, err
 	}
 
 	if total == 0 {
-		// Try search via wechat API
-		return s.wechatSvc.SearchChannels(keyword)
+		// Try search via the configured source
+		src, err := s.sources.Default()
+		if err != nil {
+			return nil, err
+		}
+		err = s.withAccount(src, func(src Source) error {
+			channels, err = src.SearchChannels(keyword)
+			return err
+		})
+		return channels, err
 	}
 
 	return channels, nil
@@ -232,7 +371,11 @@ func (s *FetcherService) SearchChannels(keyword string) ([]model.Channel, error)
 
 // DeleteChannel deletes a channel
 func (s *FetcherService) DeleteChannel(bizID string) error {
-	return store.DeleteChannel(bizID)
+	if err := s.store.DeleteChannel(bizID); err != nil {
+		return err
+	}
+	s.feedCache.Invalidate(bizID)
+	return nil
 }
 
 // PauseChannel pauses a channel
@@ -241,7 +384,7 @@ func (s *FetcherService) PauseChannel(bizID string, pause bool) error {
 	if pause {
 		status = "paused"
 	}
-	return store.UpdateChannelStatus(bizID, status)
+	return s.store.UpdateChannelStatus(bizID, status)
 }
 
 // ParseBizID parses biz_id from string (handles both plain and encrypted)
@@ -255,6 +398,6 @@ func (s *FetcherService) ParseBizID(id string) string {
 
 // IsValidBizID checks if biz_id is valid
 func (s *FetcherService) IsValidBizID(bizID string) bool {
-	_, err := store.GetChannelByBizID(bizID)
+	_, err := s.store.GetChannelByBizID(bizID)
 	return err == nil
 }
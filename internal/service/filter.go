@@ -0,0 +1,232 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/store"
+)
+
+// Filters whose regex or length rules need evaluating in Go scan at most
+// this many batches of candidate articles per page
+const maxFilterBatches = 20
+
+// ValidateFilter checks the fields and regexes of f
+func ValidateFilter(f model.ArticleFilter) error {
+	for _, field := range f.Fields {
+		switch field {
+		case "title", "digest", "content":
+		default:
+			return fmt.Errorf("unknown filter field %q", field)
+		}
+	}
+	if _, err := compileFilter(store.ScopedFilter{Filter: f}); err != nil {
+		return err
+	}
+	if f.MinLength < 0 {
+		return fmt.Errorf("minLength must not be negative")
+	}
+	return nil
+}
+
+// ArticleFilters returns the filters to apply to articles of bizID, or of
+// every channel when bizID is empty: the saved channel filters, plus extra
+// if it is not nil
+func (s *FetcherService) ArticleFilters(bizID string, extra *model.ArticleFilter) ([]store.ScopedFilter, error) {
+	var filters []store.ScopedFilter
+
+	if bizID != "" {
+		f, err := s.store.GetChannelFilter(bizID)
+		switch {
+		case err == nil:
+			filters = append(filters, store.ScopedFilter{BizID: bizID, Filter: *f})
+		case !errors.Is(err, sql.ErrNoRows):
+			return nil, err
+		}
+	} else {
+		saved, err := s.store.GetChannelFilters()
+		if err != nil {
+			return nil, err
+		}
+		for id, f := range saved {
+			filters = append(filters, store.ScopedFilter{BizID: id, Filter: f})
+		}
+	}
+
+	if extra != nil {
+		filters = append(filters, store.ScopedFilter{Filter: *extra})
+	}
+	return filters, nil
+}
+
+// QueryArticles runs q. Keyword rules are evaluated by the store; when a
+// filter also has regex or length rules, candidates are scanned in batches
+// so pages are still filled with matching articles. In that case the total
+// counts the matches seen so far, plus one if more remain.
+func (s *FetcherService) QueryArticles(q store.ArticleQuery) ([]model.Article, int, error) {
+	var compiled []*compiledFilter
+	for _, f := range q.Filters {
+		cf, err := compileFilter(f)
+		if err != nil {
+			return nil, 0, err
+		}
+		if cf.needsScan() {
+			compiled = append(compiled, cf)
+		}
+	}
+	if len(compiled) == 0 {
+		return s.store.QueryArticles(q)
+	}
+
+	page, size := q.Page, q.Size
+	if page < 1 {
+		page = 1
+	}
+	skip := (page - 1) * size
+
+	batch := q
+	batch.Size = size * 2
+	if batch.Size < 50 {
+		batch.Size = 50
+	}
+
+	var articles []model.Article
+	matched := 0
+	more := false
+	for batch.Page = 1; batch.Page <= maxFilterBatches && !more; batch.Page++ {
+		candidates, total, err := s.store.QueryArticles(batch)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, a := range candidates {
+			if !matchesAll(compiled, a) {
+				continue
+			}
+			if matched++; matched <= skip {
+				continue
+			}
+			if len(articles) == size {
+				more = true
+				break
+			}
+			articles = append(articles, a)
+		}
+
+		if batch.Page*batch.Size >= total {
+			break
+		}
+	}
+
+	total := skip + len(articles)
+	if more {
+		total++
+	}
+	return articles, total, nil
+}
+
+type compiledFilter struct {
+	bizID   string
+	filter  model.ArticleFilter
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+func compileFilter(f store.ScopedFilter) (*compiledFilter, error) {
+	cf := &compiledFilter{bizID: f.BizID, filter: f.Filter}
+
+	var err error
+	if f.Filter.IncludeRegex != "" {
+		if cf.include, err = regexp.Compile(f.Filter.IncludeRegex); err != nil {
+			return nil, fmt.Errorf("invalid include regex: %w", err)
+		}
+	}
+	if f.Filter.ExcludeRegex != "" {
+		if cf.exclude, err = regexp.Compile(f.Filter.ExcludeRegex); err != nil {
+			return nil, fmt.Errorf("invalid exclude regex: %w", err)
+		}
+	}
+	return cf, nil
+}
+
+// needsScan reports whether the filter has rules the store cannot evaluate
+func (cf *compiledFilter) needsScan() bool {
+	return cf.include != nil || cf.exclude != nil || cf.filter.MinLength > 0
+}
+
+func matchesAll(filters []*compiledFilter, a model.Article) bool {
+	var text *string
+	contentText := func() string {
+		if text == nil {
+			t := articleText(a.Content)
+			text = &t
+		}
+		return *text
+	}
+
+	for _, cf := range filters {
+		if cf.bizID != "" && !strings.EqualFold(cf.bizID, a.BizID) {
+			continue
+		}
+		if !cf.matches(a, contentText) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cf *compiledFilter) matches(a model.Article, contentText func() string) bool {
+	if cf.filter.MinLength > 0 && countLetters(contentText()) < cf.filter.MinLength {
+		return false
+	}
+	if cf.include == nil && cf.exclude == nil {
+		return true
+	}
+
+	fields := cf.filter.Fields
+	if len(fields) == 0 {
+		fields = []string{"title", "digest", "content"}
+	}
+	var values []string
+	for _, field := range fields {
+		switch field {
+		case "title":
+			values = append(values, a.Title)
+		case "digest":
+			values = append(values, a.Description)
+		case "content":
+			values = append(values, contentText())
+		}
+	}
+	joined := strings.Join(values, "\n")
+
+	if cf.include != nil && !cf.include.MatchString(joined) {
+		return false
+	}
+	if cf.exclude != nil && cf.exclude.MatchString(joined) {
+		return false
+	}
+	return true
+}
+
+// articleText returns the visible text of article content, as the store
+// sees it when matching keywords
+func articleText(content string) string {
+	return store.StripHTML(content)
+}
+
+// countLetters counts the non-space characters of s
+func countLetters(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
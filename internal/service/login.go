@@ -0,0 +1,395 @@
+package service
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Login states reported by CheckLoginStatus
+const (
+	LoginStateWaiting   = "waiting"
+	LoginStateScanned   = "scanned"
+	LoginStateConfirmed = "confirmed"
+	LoginStateExpired   = "expired"
+	LoginStateRejected  = "rejected"
+)
+
+// Status codes returned by /login/status, one per login state
+var loginStateCodes = map[string]int{
+	LoginStateWaiting:   402,
+	LoginStateScanned:   201,
+	LoginStateConfirmed: 0,
+	LoginStateExpired:   408,
+	LoginStateRejected:  403,
+}
+
+var loginStateTips = map[string]string{
+	LoginStateWaiting:   "请使用微信扫描二维码登录",
+	LoginStateScanned:   "已扫码，请在手机上确认登录",
+	LoginStateConfirmed: "登录成功",
+	LoginStateExpired:   "二维码已过期，请刷新",
+	LoginStateRejected:  "登录已取消",
+}
+
+const loginSessionTTL = 5 * time.Minute
+
+// LoginQRCode represents login QR code response
+type LoginQRCode struct {
+	ErrMsg      string `json:"errMsg"`
+	UUID        string `json:"uuid"`
+	Tips        string `json:"tips"`
+	IsLogin     bool   `json:"isLogin"`
+	QRCode      string `json:"qrcode"` // Base64 image
+	RedirectURL string `json:"redirectUrl"`
+}
+
+// LoginStatus represents login status
+type LoginStatus struct {
+	ErrMsg      string `json:"errMsg"`
+	Code        int    `json:"code"`
+	State       string `json:"state"`
+	Tips        string `json:"tips"`
+	RedirectURL string `json:"redirect_url"`
+	Cookie      string `json:"cookie"`
+}
+
+// loginSession holds the cookies of one QR scan attempt
+type loginSession struct {
+	mu        sync.Mutex
+	client    *http.Client
+	state     string
+	createdAt time.Time
+}
+
+func loginBaseURL() string {
+	base := viper.GetString("login.url")
+	if base == "" {
+		base = "https://mp.weixin.qq.com"
+	}
+	return strings.TrimSuffix(base, "/")
+}
+
+func newLoginStatus(state string) *LoginStatus {
+	return &LoginStatus{
+		Code:   loginStateCodes[state],
+		State:  state,
+		Tips:   loginStateTips[state],
+		ErrMsg: state,
+	}
+}
+
+// GetLoginQRCode starts a scan-login session and returns its QR code
+func (s *WechatService) GetLoginQRCode() (*LoginQRCode, error) {
+	s.pruneLoginSessions()
+
+	jar, _ := cookiejar.New(nil)
+	sess := &loginSession{
+		client:    &http.Client{Jar: jar, Timeout: 15 * time.Second},
+		state:     LoginStateWaiting,
+		createdAt: time.Now(),
+	}
+	base := loginBaseURL()
+
+	form := url.Values{}
+	form.Set("userlang", "zh_CN")
+	form.Set("redirect_url", "")
+	form.Set("login_type", "3")
+	form.Set("sessionid", fmt.Sprintf("%d%d", time.Now().UnixMilli(), rand.Intn(100)))
+	form.Set("token", "")
+	form.Set("lang", "zh_CN")
+	form.Set("f", "json")
+	form.Set("ajax", "1")
+
+	var start struct {
+		BaseResp mpBaseResp `json:"base_resp"`
+	}
+	if err := sess.postJSON(base+"/cgi-bin/bizlogin?action=startlogin", form, &start); err != nil {
+		return nil, err
+	}
+	if err := start.BaseResp.err(); err != nil {
+		return nil, err
+	}
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/cgi-bin/scanloginqrcode?action=getqrcode&random=%d", base, time.Now().UnixMilli()), nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	req.Header.Set("Referer", base+"/")
+	resp, err := sess.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	img, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = "image/png"
+	}
+
+	uuid, err := generateUUID()
+	if err != nil {
+		return nil, err
+	}
+	s.loginMu.Lock()
+	s.logins[uuid] = sess
+	s.loginMu.Unlock()
+
+	return &LoginQRCode{
+		UUID:    uuid,
+		IsLogin: false,
+		Tips:    loginStateTips[LoginStateWaiting],
+		QRCode:  "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(img),
+	}, nil
+}
+
+// generateUUID returns a random login session ID. It is the only secret
+// of a pending login, so it comes from crypto/rand.
+func generateUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := cryptorand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// CheckLoginStatus polls the scan state of a login session.
+// Once confirmed, the session cookie and token are saved as an account.
+func (s *WechatService) CheckLoginStatus(uuid string) (*LoginStatus, error) {
+	s.loginMu.Lock()
+	sess, ok := s.logins[uuid]
+	s.loginMu.Unlock()
+
+	if !ok || time.Since(sess.createdAt) > loginSessionTTL {
+		return newLoginStatus(LoginStateExpired), nil
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	// Final states are sticky so repeated polls don't hit upstream
+	switch sess.state {
+	case LoginStateConfirmed:
+		status := newLoginStatus(LoginStateConfirmed)
+		status.RedirectURL = "/"
+		return status, nil
+	case LoginStateExpired, LoginStateRejected:
+		return newLoginStatus(sess.state), nil
+	}
+
+	base := loginBaseURL()
+	req, _ := http.NewRequest("GET", base+"/cgi-bin/scanloginqrcode?action=ask&token=&lang=zh_CN&f=json&ajax=1", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	req.Header.Set("Referer", base+"/")
+	resp, err := sess.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ask struct {
+		BaseResp mpBaseResp `json:"base_resp"`
+		Status   int        `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ask); err != nil {
+		return nil, err
+	}
+	if err := ask.BaseResp.err(); err != nil {
+		return nil, err
+	}
+
+	switch ask.Status {
+	case 0:
+		sess.state = LoginStateWaiting
+	case 4, 6:
+		sess.state = LoginStateScanned
+	case 1:
+		if err := s.finishLogin(sess); err != nil {
+			return nil, err
+		}
+		sess.state = LoginStateConfirmed
+	case 2, 3:
+		sess.state = LoginStateExpired
+	default:
+		// 5: the scanning WeChat user has no bound official account
+		sess.state = LoginStateRejected
+	}
+
+	status := newLoginStatus(sess.state)
+	if sess.state == LoginStateConfirmed {
+		status.RedirectURL = "/"
+	}
+	return status, nil
+}
+
+var nickNameRegex = regexp.MustCompile(`nick_name\s*[:=]\s*["']([^"']+)["']`)
+
+// finishLogin exchanges a confirmed scan for a token and stores the account
+func (s *WechatService) finishLogin(sess *loginSession) error {
+	base := loginBaseURL()
+
+	form := url.Values{}
+	form.Set("userlang", "zh_CN")
+	form.Set("redirect_url", "")
+	form.Set("cookie_forbidden", "0")
+	form.Set("cookie_cleaned", "0")
+	form.Set("plugin_used", "0")
+	form.Set("login_type", "3")
+	form.Set("token", "")
+	form.Set("lang", "zh_CN")
+	form.Set("f", "json")
+	form.Set("ajax", "1")
+
+	var login struct {
+		BaseResp    mpBaseResp `json:"base_resp"`
+		RedirectURL string     `json:"redirect_url"`
+	}
+	if err := sess.postJSON(base+"/cgi-bin/bizlogin?action=login", form, &login); err != nil {
+		return err
+	}
+	if err := login.BaseResp.err(); err != nil {
+		return err
+	}
+
+	redirect, err := url.Parse(login.RedirectURL)
+	if err != nil {
+		return err
+	}
+	token := redirect.Query().Get("token")
+	if token == "" {
+		return fmt.Errorf("token not found in login redirect")
+	}
+
+	baseURL, _ := url.Parse(base)
+	var cookies []string
+	for _, c := range sess.client.Jar.Cookies(baseURL) {
+		cookies = append(cookies, c.Name+"="+c.Value)
+	}
+	cookie := strings.Join(cookies, "; ")
+
+	// The token changes with every login; the bizuin cookie identifies the
+	// official account
+	bizUin := accountBizUin(cookie)
+	nickName := s.loginNickName(sess, base, token)
+	name := nickName
+	if name == "" && bizUin != "" {
+		name = "account-" + bizUin
+	}
+	if name == "" {
+		// Never the token: account names are listed to every admin
+		suffix := make([]byte, 4)
+		if _, err := cryptorand.Read(suffix); err != nil {
+			return err
+		}
+		name = fmt.Sprintf("account-%x", suffix)
+	}
+
+	// Logging in again with the same account refreshes it. Names are not
+	// unique, so only the bizuin identifies the account.
+	accounts, err := s.store.GetAccounts()
+	if err != nil {
+		return err
+	}
+	for _, a := range accounts {
+		if bizUin != "" && accountBizUin(a.Cookie) == bizUin {
+			if nickName == "" {
+				name = a.Name
+			}
+			log.Printf("Account %s logged in again", name)
+			return s.store.UpdateAccount(a.ID, name, cookie, token, true)
+		}
+	}
+
+	if _, err := s.store.CreateAccount(name, cookie, token); err != nil {
+		return err
+	}
+	log.Printf("Account %s logged in", name)
+	return nil
+}
+
+// accountBizUin returns the bizuin an account's cookie was issued for
+func accountBizUin(cookie string) string {
+	if uin := cookieValue(cookie, "bizuin"); uin != "" {
+		return uin
+	}
+	return cookieValue(cookie, "data_bizuin")
+}
+
+// cookieValue returns the value of the cookie called name in a Cookie
+// header
+func cookieValue(cookie, name string) string {
+	for _, part := range strings.Split(cookie, ";") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(part), "="); ok && k == name {
+			return v
+		}
+	}
+	return ""
+}
+
+// loginNickName reads the account nickname from the home page
+func (s *WechatService) loginNickName(sess *loginSession, base, token string) string {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/cgi-bin/home?t=home/index&lang=zh_CN&token=%s", base, url.QueryEscape(token)), nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	resp, err := sess.client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if matches := nickNameRegex.FindSubmatch(body); len(matches) > 1 {
+		return string(matches[1])
+	}
+	return ""
+}
+
+func (s *WechatService) pruneLoginSessions() {
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+
+	for uuid, sess := range s.logins {
+		if time.Since(sess.createdAt) > loginSessionTTL {
+			delete(s.logins, uuid)
+		}
+	}
+}
+
+func (sess *loginSession) postJSON(rawURL string, form url.Values, out interface{}) error {
+	req, err := http.NewRequest("POST", rawURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	req.Header.Set("Referer", loginBaseURL()+"/")
+
+	resp, err := sess.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
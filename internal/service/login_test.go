@@ -0,0 +1,118 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/store"
+)
+
+// loginTestServer answers the login and home page requests made after a
+// confirmed scan, for the account bizUin called nickName. Both may be empty.
+type loginTestServer struct {
+	*httptest.Server
+	bizUin   string
+	nickName string
+	token    string
+}
+
+func newLoginTestServer(t *testing.T) *loginTestServer {
+	s := &loginTestServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cgi-bin/bizlogin":
+			http.SetCookie(w, &http.Cookie{Name: "slave_sid", Value: "sid-" + s.token, Path: "/"})
+			if s.bizUin != "" {
+				http.SetCookie(w, &http.Cookie{Name: "bizuin", Value: s.bizUin, Path: "/"})
+			}
+			fmt.Fprintf(w, `{"base_resp":{"ret":0},"redirect_url":"/cgi-bin/home?t=home/index&token=%s"}`, s.token)
+		case "/cgi-bin/home":
+			if s.nickName != "" {
+				fmt.Fprintf(w, `<script>var nick_name = "%s";</script>`, s.nickName)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	url := viper.Get("login.url")
+	viper.Set("login.url", s.URL)
+	t.Cleanup(func() { viper.Set("login.url", url) })
+	return s
+}
+
+// login finishes a login as bizUin called nickName and returns the
+// accounts afterwards
+func (s *loginTestServer) login(t *testing.T, svc *WechatService, bizUin, nickName, token string) []model.Account {
+	t.Helper()
+	s.bizUin, s.nickName, s.token = bizUin, nickName, token
+
+	jar, _ := cookiejar.New(nil)
+	if err := svc.finishLogin(&loginSession{client: &http.Client{Jar: jar}}); err != nil {
+		t.Fatalf("login as %q: %v", bizUin, err)
+	}
+	accounts, err := svc.store.GetAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accounts
+}
+
+func TestFinishLogin(t *testing.T) {
+	st, err := store.OpenSQLite(filepath.Join(t.TempDir(), "wechatoarss.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if _, err := st.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewWechatService(st)
+	srv := newLoginTestServer(t)
+
+	accounts := srv.login(t, svc, "3001", "", "token-1")
+	if len(accounts) != 1 || accounts[0].Name != "account-3001" || accounts[0].Token != "token-1" {
+		t.Fatalf("accounts after the first login: %+v", accounts)
+	}
+
+	// Another account with the same nickname is a new account
+	if err := st.UpdateAccount(accounts[0].ID, "Alpha", accounts[0].Cookie, accounts[0].Token, true); err != nil {
+		t.Fatal(err)
+	}
+	accounts = srv.login(t, svc, "3002", "Alpha", "token-2")
+	if len(accounts) != 2 {
+		t.Fatalf("login to another account named like an existing one gave accounts %+v", accounts)
+	}
+
+	// Logging in again refreshes the account and keeps its name when the
+	// nickname is unknown
+	accounts = srv.login(t, svc, "3001", "", "token-3")
+	if len(accounts) != 2 {
+		t.Fatalf("login again added an account: %+v", accounts)
+	}
+	for _, a := range accounts {
+		if accountBizUin(a.Cookie) == "3001" && (a.Name != "Alpha" || a.Token != "token-3") {
+			t.Errorf("account after logging in again: %+v", a)
+		}
+	}
+
+	// Without a bizuin or nickname the name must not reveal the token
+	accounts = srv.login(t, svc, "", "", "token-4")
+	if len(accounts) != 3 {
+		t.Fatalf("accounts %+v", accounts)
+	}
+	for _, a := range accounts {
+		if a.Token == "token-4" && (!strings.HasPrefix(a.Name, "account-") || strings.Contains(a.Name, "token")) {
+			t.Errorf("account without a bizuin is called %q", a.Name)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"crypto/hmac"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// voiceURL is where WeChat serves the audio of an <mpvoice> element
+const voiceURL = "https://res.wx.qq.com/voice/getvoice?mediaid="
+
+// parseContent parses an article body into a detached root node
+func parseContent(content string) (*html.Node, error) {
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(content), context)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+	return root, nil
+}
+
+// promoteLazyMedia makes lazy-loaded media visible outside WeChat: data-src
+// becomes src and the styles hiding content until scripts run are dropped
+func promoteLazyMedia(root *html.Node) {
+	walk(root, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+
+		if dataSrc := attr(n, "data-src"); dataSrc != "" {
+			switch n.DataAtom {
+			case atom.Img, atom.Iframe, atom.Video, atom.Source:
+				setAttr(n, "src", dataSrc)
+				removeAttr(n, "data-src")
+			}
+		}
+
+		if style := attr(n, "style"); style != "" {
+			if cleaned := stripHiddenStyle(style); cleaned == "" {
+				removeAttr(n, "style")
+			} else {
+				setAttr(n, "style", cleaned)
+			}
+		}
+	})
+}
+
+// stripHiddenStyle removes visibility:hidden and opacity:0 declarations
+func stripHiddenStyle(style string) string {
+	var kept []string
+	for _, decl := range strings.Split(style, ";") {
+		parts := strings.SplitN(decl, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		prop := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.ToLower(strings.TrimSpace(parts[1]))
+		if prop == "visibility" && value == "hidden" {
+			continue
+		}
+		if prop == "opacity" && (value == "0" || value == "0.0") {
+			continue
+		}
+		kept = append(kept, strings.TrimSpace(decl))
+	}
+	return strings.Join(kept, "; ")
+}
+
+// RewriteMedia prepares stored article content for feed readers. Lazy
+// media is promoted, and unless rss.proxy_disable_img is set, image, video
+// and mpvoice URLs are routed through the proxies under host so they pass
+// WeChat's Referer checks.
+func (s *FetcherService) RewriteMedia(content, host string) string {
+	if content == "" {
+		return content
+	}
+
+	root, err := parseContent(content)
+	if err != nil {
+		return content
+	}
+	promoteLazyMedia(root)
+
+	if !viper.GetBool("rss.proxy_disable_img") {
+		proxyMedia(root, host)
+	}
+
+	return renderChildren(root)
+}
+
+func proxyMedia(root *html.Node, host string) {
+	var voices []*html.Node
+
+	walk(root, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+
+		switch n.DataAtom {
+		case atom.Img:
+			proxyAttr(n, "src", host, "/img-proxy")
+		case atom.Video:
+			proxyAttr(n, "src", host, "/video-proxy")
+			proxyAttr(n, "poster", host, "/img-proxy")
+		case atom.Source, atom.Audio:
+			proxyAttr(n, "src", host, "/video-proxy")
+		default:
+			if n.Data == "mpvoice" {
+				voices = append(voices, n)
+			}
+		}
+	})
+
+	// Replace each <mpvoice> with a playable <audio> element
+	for _, n := range voices {
+		fileID := attr(n, "voice_encode_fileid")
+		if fileID == "" {
+			continue
+		}
+		audio := &html.Node{
+			Type:     html.ElementNode,
+			Data:     "audio",
+			DataAtom: atom.Audio,
+			Attr: []html.Attribute{
+				{Key: "controls", Val: ""},
+				{Key: "src", Val: ProxyURL(host, "/video-proxy", voiceURL+url.QueryEscape(fileID))},
+			},
+		}
+		if name := attr(n, "name"); name != "" {
+			setAttr(audio, "title", name)
+		}
+		n.Parent.InsertBefore(audio, n)
+		n.Parent.RemoveChild(n)
+	}
+}
+
+func proxyAttr(n *html.Node, key, host, path string) {
+	target := attr(n, key)
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") && !strings.HasPrefix(target, "//") {
+		return
+	}
+	if strings.HasPrefix(target, "//") {
+		target = "https:" + target
+	}
+	// Already routed through our own proxy
+	if strings.HasPrefix(target, strings.TrimSuffix(host, "/")+"/") {
+		return
+	}
+	setAttr(n, key, ProxyURL(host, path, target))
+}
+
+// ProxyURL builds a signed URL of the media proxy endpoint at path for
+// target. The signature lets feed readers load media without the admin
+// token, through that endpoint only.
+func ProxyURL(host, path, target string) string {
+	var expires int64
+	if ttl := viper.GetDuration("rss.proxy_url_ttl"); ttl > 0 {
+		expires = time.Now().Add(ttl).Unix()
+	}
+
+	params := url.Values{}
+	params.Set("u", target)
+	if expires > 0 {
+		params.Set("e", strconv.FormatInt(expires, 10))
+	}
+	params.Set("s", signProxyTarget(path, target, expires))
+	return strings.TrimSuffix(host, "/") + path + "?" + params.Encode()
+}
+
+func signProxyTarget(path, target string, expires int64) string {
+	return generateHMAC("proxy\n" + path + "\n" + target + "\n" + strconv.FormatInt(expires, 10))
+}
+
+// VerifyProxySignature checks the signature of a request to the proxy
+// endpoint at path, made with a URL built by ProxyURL. expires is the raw
+// e parameter and may be empty.
+func VerifyProxySignature(path, target, expires, signature string) bool {
+	if target == "" || signature == "" {
+		return false
+	}
+
+	var exp int64
+	if expires != "" {
+		var err error
+		exp, err = strconv.ParseInt(expires, 10, 64)
+		if err != nil || time.Now().Unix() > exp {
+			return false
+		}
+	}
+
+	expected := signProxyTarget(path, target, exp)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeAttr(n *html.Node, key string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			return
+		}
+	}
+}
+
+// MediaRef is an audio or video file referenced by an article
+type MediaRef struct {
+	URL      string
+	MimeType string
+	Title    string
+}
+
+// ExtractMedia returns the audio and video files in content, including
+// <mpvoice> recordings
+func ExtractMedia(content string) []MediaRef {
+	root, err := parseContent(content)
+	if err != nil {
+		return nil
+	}
+	promoteLazyMedia(root)
+
+	var refs []MediaRef
+	seen := make(map[string]bool)
+	add := func(src, mimeType, title string) {
+		if strings.HasPrefix(src, "//") {
+			src = "https:" + src
+		}
+		if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") || seen[src] {
+			return
+		}
+		seen[src] = true
+		refs = append(refs, MediaRef{URL: src, MimeType: mimeType, Title: title})
+	}
+
+	walk(root, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+
+		switch n.DataAtom {
+		case atom.Video:
+			add(attr(n, "src"), "video/mp4", "")
+		case atom.Audio:
+			add(attr(n, "src"), "audio/mpeg", attr(n, "title"))
+		case atom.Source:
+			mimeType := attr(n, "type")
+			if mimeType == "" {
+				mimeType = "video/mp4"
+				if n.Parent != nil && n.Parent.DataAtom == atom.Audio {
+					mimeType = "audio/mpeg"
+				}
+			}
+			add(attr(n, "src"), mimeType, "")
+		default:
+			if n.Data == "mpvoice" {
+				if fileID := attr(n, "voice_encode_fileid"); fileID != "" {
+					add(voiceURL+url.QueryEscape(fileID), "audio/mpeg", attr(n, "name"))
+				}
+			}
+		}
+	})
+	return refs
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestVerifyProxySignature(t *testing.T) {
+	ttl := viper.Get("rss.proxy_url_ttl")
+	viper.Set("rss.proxy_url_ttl", "1h")
+	t.Cleanup(func() { viper.Set("rss.proxy_url_ttl", ttl) })
+
+	const target = "https://mmbiz.qpic.cn/mmbiz_jpg/a/0?wx_fmt=jpeg"
+	signed, err := url.Parse(ProxyURL("https://rss.example.org", "/img-proxy", target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := signed.Query()
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name                        string
+		path, target, expires, sign string
+		want                        bool
+	}{
+		{"signed", "/img-proxy", target, q.Get("e"), q.Get("s"), true},
+		{"other endpoint", "/link-proxy", target, q.Get("e"), q.Get("s"), false},
+		{"video endpoint", "/video-proxy", target, q.Get("e"), q.Get("s"), false},
+		{"other target", "/img-proxy", target + "&x=1", q.Get("e"), q.Get("s"), false},
+		{"extended", "/img-proxy", target, strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10), q.Get("s"), false},
+		{"no expiry", "/img-proxy", target, "", q.Get("s"), false},
+		{"expired", "/img-proxy", target, expired, signProxyTarget("/img-proxy", target, time.Now().Add(-time.Minute).Unix()), false},
+		{"unsigned", "/img-proxy", target, q.Get("e"), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyProxySignature(tt.path, tt.target, tt.expires, tt.sign); got != tt.want {
+				t.Errorf("VerifyProxySignature = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package service
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// MediaCache is an on-disk cache of proxied media. Blobs are stored under
+// the SHA-256 of their content, so the same image reached through several
+// URLs is stored once, and an index maps each URL to its blob and
+// Content-Type. Blobs are evicted least recently used first once the
+// cache grows past its maximum size.
+type MediaCache struct {
+	dir         string
+	maxSize     int64
+	maxItemSize int64
+	policy      *ProxyPolicy
+
+	mu       sync.Mutex
+	size     int64
+	lru      *list.List // of *cacheBlob, most recently used at the front
+	blobs    map[string]*list.Element
+	inflight map[string]*sync.WaitGroup
+}
+
+type cacheBlob struct {
+	hash    string
+	size    int64
+	indexes []string // index files that may point at the blob
+}
+
+// MediaEntry is a cached media file
+type MediaEntry struct {
+	Path        string
+	Hash        string
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+type mediaIndex struct {
+	URL         string `json:"url"`
+	Hash        string `json:"hash"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	FetchedAt   int64  `json:"fetchedAt"`
+}
+
+// NewMediaCache opens the cache in dir, creating it if needed. Downloads
+// go through policy.
+func NewMediaCache(dir string, maxSize, maxItemSize int64, policy *ProxyPolicy) (*MediaCache, error) {
+	c := &MediaCache{
+		dir:         dir,
+		maxSize:     maxSize,
+		maxItemSize: maxItemSize,
+		policy:      policy,
+		lru:         list.New(),
+		blobs:       make(map[string]*list.Element),
+		inflight:    make(map[string]*sync.WaitGroup),
+	}
+
+	for _, sub := range []string{"blobs", "index", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.evict()
+
+	log.Printf("Media cache at %s: %d files, %d bytes", dir, c.lru.Len(), c.size)
+	return c, nil
+}
+
+// NewMediaCacheFromConfig opens the cache configured under media_cache,
+// or returns nil if it is disabled
+func NewMediaCacheFromConfig(dataDir string, policy *ProxyPolicy) (*MediaCache, error) {
+	if !viper.GetBool("media_cache.enabled") {
+		return nil, nil
+	}
+
+	dir := viper.GetString("media_cache.dir")
+	if dir == "" {
+		dir = filepath.Join(dataDir, "media")
+	}
+	maxSize := viper.GetInt64("media_cache.max_size_mb") << 20
+	if maxSize <= 0 {
+		maxSize = 1024 << 20
+	}
+	maxItemSize := viper.GetInt64("media_cache.max_item_size_mb") << 20
+	if maxItemSize <= 0 {
+		maxItemSize = 20 << 20
+	}
+
+	return NewMediaCache(dir, maxSize, maxItemSize, policy)
+}
+
+// load rebuilds the LRU list from blob modification times and drops
+// index entries whose blob is gone
+func (c *MediaCache) load() error {
+	files, err := filepath.Glob(filepath.Join(c.dir, "blobs", "*", "*"))
+	if err != nil {
+		return err
+	}
+
+	type found struct {
+		blob    *cacheBlob
+		modTime time.Time
+	}
+	var blobs []found
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		blobs = append(blobs, found{
+			blob:    &cacheBlob{hash: filepath.Base(file), size: info.Size()},
+			modTime: info.ModTime(),
+		})
+	}
+
+	// Oldest first, so pushing to the front leaves the newest there
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].modTime.Before(blobs[j].modTime)
+	})
+	for _, b := range blobs {
+		c.blobs[b.blob.hash] = c.lru.PushFront(b.blob)
+		c.size += b.blob.size
+	}
+
+	indexes, err := filepath.Glob(filepath.Join(c.dir, "index", "*", "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range indexes {
+		hash := readIndexHash(path)
+		if elem, ok := c.blobs[hash]; ok {
+			blob := elem.Value.(*cacheBlob)
+			blob.indexes = append(blob.indexes, path)
+		} else {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+// readIndexHash returns the blob hash of the index file at path, or ""
+// if it can't be read
+func readIndexHash(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var idx mediaIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return ""
+	}
+	return idx.Hash
+}
+
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *MediaCache) blobPath(hash string) string {
+	return filepath.Join(c.dir, "blobs", hash[:2], hash)
+}
+
+func (c *MediaCache) indexPath(rawURL string) string {
+	key := hashString(rawURL)
+	return filepath.Join(c.dir, "index", key[:2], key+".json")
+}
+
+// Get returns the cached entry for rawURL, if any
+func (c *MediaCache) Get(rawURL string) (*MediaEntry, bool) {
+	data, err := os.ReadFile(c.indexPath(rawURL))
+	if err != nil {
+		return nil, false
+	}
+
+	var idx mediaIndex
+	if err := json.Unmarshal(data, &idx); err != nil || idx.Hash == "" {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	elem, ok := c.blobs[idx.Hash]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+
+	path := c.blobPath(idx.Hash)
+	if !ok {
+		// Blob was evicted; drop the dangling index entry
+		os.Remove(c.indexPath(rawURL))
+		return nil, false
+	}
+
+	// Persist recency so LRU order survives restarts
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return &MediaEntry{
+		Path:        path,
+		Hash:        idx.Hash,
+		ContentType: idx.ContentType,
+		Size:        idx.Size,
+		ModTime:     time.Unix(idx.FetchedAt, 0),
+	}, true
+}
+
+// Fetch returns the cached entry for rawURL, downloading it on a miss.
+// Concurrent fetches of the same URL share one download.
+func (c *MediaCache) Fetch(rawURL string) (*MediaEntry, error) {
+	if err := c.policy.CheckURL(rawURL); err != nil {
+		return nil, err
+	}
+
+	if entry, ok := c.Get(rawURL); ok {
+		return entry, nil
+	}
+
+	c.mu.Lock()
+	if wg, ok := c.inflight[rawURL]; ok {
+		c.mu.Unlock()
+		wg.Wait()
+		if entry, ok := c.Get(rawURL); ok {
+			return entry, nil
+		}
+		return nil, fmt.Errorf("fetch %s failed", rawURL)
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	c.inflight[rawURL] = wg
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, rawURL)
+		c.mu.Unlock()
+		wg.Done()
+	}()
+
+	return c.download(rawURL)
+}
+
+// Open returns the cached entry for rawURL like Fetch, with its blob open
+// for reading. A blob evicted between the lookup and the open is a miss,
+// and is downloaded again.
+func (c *MediaCache) Open(rawURL string) (*MediaEntry, *os.File, error) {
+	for attempt := 0; ; attempt++ {
+		entry, err := c.Fetch(rawURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		f, err := os.Open(entry.Path)
+		if err == nil {
+			return entry, f, nil
+		}
+		if attempt > 0 || !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		c.forget(entry.Hash)
+	}
+}
+
+// forget drops a blob that is gone from disk, so the next Get misses
+func (c *MediaCache) forget(hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.blobs[hash]; ok {
+		c.lru.Remove(elem)
+		delete(c.blobs, hash)
+		c.size -= elem.Value.(*cacheBlob).size
+	}
+}
+
+func (c *MediaCache) download(rawURL string) (*MediaEntry, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	req.Header.Set("Referer", "https://mp.weixin.qq.com/")
+
+	resp, err := c.policy.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if err := c.policy.CheckResponse(resp, []string{"image/"}); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, "tmp"), "dl-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(resp.Body, c.maxItemSize+1))
+	tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+	if size > c.maxItemSize {
+		return nil, fmt.Errorf("media larger than %d bytes", c.maxItemSize)
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))
+	path := c.blobPath(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	idx := mediaIndex{
+		URL:         rawURL,
+		Hash:        hash,
+		ContentType: contentType,
+		Size:        size,
+		FetchedAt:   time.Now().Unix(),
+	}
+	indexPath := c.indexPath(rawURL)
+	if err := c.writeIndex(rawURL, idx); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if elem, ok := c.blobs[hash]; ok {
+		c.lru.MoveToFront(elem)
+		blob := elem.Value.(*cacheBlob)
+		if !slices.Contains(blob.indexes, indexPath) {
+			blob.indexes = append(blob.indexes, indexPath)
+		}
+	} else {
+		c.blobs[hash] = c.lru.PushFront(&cacheBlob{hash: hash, size: size, indexes: []string{indexPath}})
+		c.size += size
+	}
+	c.mu.Unlock()
+	c.evict()
+
+	return &MediaEntry{
+		Path:        path,
+		Hash:        hash,
+		ContentType: contentType,
+		Size:        size,
+		ModTime:     time.Unix(idx.FetchedAt, 0),
+	}, nil
+}
+
+func (c *MediaCache) writeIndex(rawURL string, idx mediaIndex) error {
+	path := c.indexPath(rawURL)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// evict removes least recently used blobs until the cache fits maxSize,
+// along with the index entries pointing at them. An index entry rewritten
+// for another blob since is kept.
+func (c *MediaCache) evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		elem := c.lru.Back()
+		blob := elem.Value.(*cacheBlob)
+		c.lru.Remove(elem)
+		delete(c.blobs, blob.hash)
+		c.size -= blob.size
+		os.Remove(c.blobPath(blob.hash))
+		for _, path := range blob.indexes {
+			if readIndexHash(path) == blob.hash {
+				os.Remove(path)
+			}
+		}
+	}
+}
+
+// Prefetch downloads every URL not yet cached, logging failures
+func (c *MediaCache) Prefetch(urls []string) {
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			continue
+		}
+		if _, err := c.Fetch(u); err != nil {
+			log.Printf("Failed to prefetch %s: %v", u, err)
+		}
+	}
+}
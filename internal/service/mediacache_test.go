@@ -0,0 +1,94 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// indexFiles returns the index entries stored in the cache at dir
+func indexFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "index", "*", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestMediaCacheEvictsIndex(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		// Each path is a different 100 byte image
+		w.Write(bytes.Repeat([]byte(r.URL.Path[1:2]), 100))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	policy := NewProxyPolicy([]string{"127.0.0.1"}, true, 1<<20)
+	cache, err := NewMediaCache(dir, 250, 1<<20, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two URLs of the same image share a blob
+	for _, path := range []string{"/a.png", "/a.png?v=2", "/b.png"} {
+		if _, err := cache.Fetch(srv.URL + path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(indexFiles(t, dir)); n != 3 {
+		t.Fatalf("%d index entries, want 3", n)
+	}
+
+	// Caching a third image evicts a and both of its index entries
+	if _, err := cache.Fetch(srv.URL + "/c.png"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(indexFiles(t, dir)); n != 2 {
+		t.Errorf("%d index entries after evicting a blob, want 2", n)
+	}
+	for _, path := range []string{"/b.png", "/c.png"} {
+		if _, ok := cache.Get(srv.URL + path); !ok {
+			t.Errorf("%s is not cached", path)
+		}
+	}
+}
+
+func TestMediaCacheSweepsOrphanedIndex(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	policy := NewProxyPolicy([]string{"127.0.0.1"}, true, 1<<20)
+	cache, err := NewMediaCache(dir, 1<<20, 1<<20, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := cache.Fetch(srv.URL + "/kept.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := cache.Fetch(srv.URL + "/gone.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A blob removed while the server was down leaves its index behind
+	if err := os.Remove(gone.Path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMediaCache(dir, 1<<20, 1<<20, policy); err != nil {
+		t.Fatal(err)
+	}
+	files := indexFiles(t, dir)
+	if len(files) != 1 || readIndexHash(files[0]) != kept.Hash {
+		t.Errorf("index entries after reopening the cache: %v", files)
+	}
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Hosts the media and link proxies may reach when proxy.allowed_hosts is
+// not configured. A leading "*." matches the domain and any subdomain.
+var defaultProxyHosts = []string{
+	"mmbiz.qpic.cn",
+	"mmbiz.qlogo.cn",
+	"wx.qlogo.cn",
+	"mp.weixin.qq.com",
+	"res.wx.qq.com",
+	"*.qpic.cn",
+	"*.video.qq.com",
+	"*.weixin.qq.com",
+}
+
+// Proxy policy violation reasons
+const (
+	ViolationScheme      = "scheme"
+	ViolationHost        = "host"
+	ViolationAddress     = "address"
+	ViolationContentType = "content_type"
+	ViolationSize        = "size"
+)
+
+// ProxyViolation is returned when a proxied request breaks the policy
+type ProxyViolation struct {
+	Reason string
+	Detail string
+}
+
+func (v *ProxyViolation) Error() string {
+	return fmt.Sprintf("proxy policy violation (%s): %s", v.Reason, v.Detail)
+}
+
+// IsProxyViolation reports whether err was caused by the proxy policy
+func IsProxyViolation(err error) bool {
+	var v *ProxyViolation
+	return errors.As(err, &v)
+}
+
+// ProxyPolicy decides which upstream URLs the proxies may fetch. Hosts
+// must match an allowed pattern, and connections to private, loopback or
+// link-local addresses are refused at dial time, so the check applies
+// after DNS resolution and again on every redirect.
+type ProxyPolicy struct {
+	allowedHosts []string
+	allowPrivate bool
+	maxSize      int64
+	client       *http.Client
+	streamClient *http.Client
+
+	mu         sync.Mutex
+	violations map[string]int64
+}
+
+func NewProxyPolicy(allowedHosts []string, allowPrivate bool, maxSize int64) *ProxyPolicy {
+	p := &ProxyPolicy{
+		allowedHosts: allowedHosts,
+		allowPrivate: allowPrivate,
+		maxSize:      maxSize,
+		violations:   make(map[string]int64),
+	}
+
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: p.checkDial,
+	}
+	transport := &http.Transport{
+		// No environment proxy: it would bypass the dial check
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	checkRedirect := func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("stopped after 5 redirects")
+		}
+		return p.CheckURL(req.URL.String())
+	}
+
+	p.client = &http.Client{
+		Timeout:       60 * time.Second,
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
+	}
+	// Streams can run for as long as the client keeps reading, so they
+	// rely on the request context instead of an overall timeout
+	p.streamClient = &http.Client{
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
+	}
+	return p
+}
+
+// NewProxyPolicyFromConfig builds the policy configured under proxy
+func NewProxyPolicyFromConfig() *ProxyPolicy {
+	hosts := viper.GetStringSlice("proxy.allowed_hosts")
+	if len(hosts) == 0 {
+		hosts = defaultProxyHosts
+	}
+	maxSize := viper.GetInt64("proxy.max_size_mb") << 20
+	if maxSize <= 0 {
+		maxSize = 100 << 20
+	}
+	return NewProxyPolicy(hosts, viper.GetBool("proxy.allow_private"), maxSize)
+}
+
+// Client returns an HTTP client that enforces the policy
+func (p *ProxyPolicy) Client() *http.Client {
+	return p.client
+}
+
+// StreamClient returns a client like Client without an overall timeout
+func (p *ProxyPolicy) StreamClient() *http.Client {
+	return p.streamClient
+}
+
+// MaxSize returns the largest response body the proxies will relay
+func (p *ProxyPolicy) MaxSize() int64 {
+	return p.maxSize
+}
+
+// CheckURL checks the scheme and host of rawURL
+func (p *ProxyPolicy) CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return p.violation(ViolationScheme, rawURL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return p.violation(ViolationScheme, rawURL)
+	}
+	if !p.HostAllowed(u.Hostname()) {
+		return p.violation(ViolationHost, rawURL)
+	}
+	return nil
+}
+
+// HostAllowed reports whether host matches an allowed pattern
+func (p *ProxyPolicy) HostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.allowedHosts {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") {
+			domain := pattern[2:]
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckResponse checks the upstream Content-Type against the allowed
+// prefixes and the declared Content-Length against the size limit, which
+// does not apply to streamed media
+func (p *ProxyPolicy) CheckResponse(resp *http.Response, allowedTypes []string) error {
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	allowed := len(allowedTypes) == 0
+	for _, prefix := range allowedTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return p.violation(ViolationContentType, fmt.Sprintf("%s from %s", contentType, resp.Request.URL))
+	}
+
+	if !streamedType(contentType) && resp.ContentLength > p.maxSize {
+		return p.violation(ViolationSize, fmt.Sprintf("%d bytes from %s", resp.ContentLength, resp.Request.URL))
+	}
+	return nil
+}
+
+// LimitBody wraps body so reading past the size limit fails
+func (p *ProxyPolicy) LimitBody(body io.Reader) io.Reader {
+	return &limitedReader{policy: p, r: io.LimitReader(body, p.maxSize+1)}
+}
+
+// LimitResponse returns the body of resp wrapped by LimitBody, unless it
+// is streamed media
+func (p *ProxyPolicy) LimitResponse(resp *http.Response) io.Reader {
+	if streamedType(strings.ToLower(resp.Header.Get("Content-Type"))) {
+		return resp.Body
+	}
+	return p.LimitBody(resp.Body)
+}
+
+// streamedType reports whether contentType is video or audio. Players
+// fetch those range by range and a whole video is often larger than the
+// size limit, so they are bounded only by the allowed hosts.
+func streamedType(contentType string) bool {
+	return strings.HasPrefix(contentType, "video/") || strings.HasPrefix(contentType, "audio/")
+}
+
+type limitedReader struct {
+	policy *ProxyPolicy
+	r      io.Reader
+	n      int64
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	n, err := l.r.Read(b)
+	l.n += int64(n)
+	if l.n > l.policy.maxSize {
+		return n, l.policy.violation(ViolationSize, fmt.Sprintf("body exceeds %d bytes", l.policy.maxSize))
+	}
+	return n, err
+}
+
+// Violations returns the number of violations counted per reason
+func (p *ProxyPolicy) Violations() map[string]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := make(map[string]int64, len(p.violations))
+	for reason, n := range p.violations {
+		counts[reason] = n
+	}
+	return counts
+}
+
+func (p *ProxyPolicy) violation(reason, detail string) error {
+	p.mu.Lock()
+	p.violations[reason]++
+	p.mu.Unlock()
+
+	log.Printf("Proxy policy violation (%s): %s", reason, detail)
+	return &ProxyViolation{Reason: reason, Detail: detail}
+}
+
+// checkDial runs on the resolved address of every connection
+func (p *ProxyPolicy) checkDial(network, address string, _ syscall.RawConn) error {
+	if p.allowPrivate {
+		return nil
+	}
+
+	if !isPublicAddress(address) {
+		return p.violation(ViolationAddress, address)
+	}
+	return nil
+}
+
+// dialPublicOnly is a net.Dialer Control that refuses connections to
+// private, loopback or link-local addresses
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	if !isPublicAddress(address) {
+		return fmt.Errorf("refusing to connect to non-public address %s", address)
+	}
+	return nil
+}
+
+// isPublicAddress reports whether the host:port address is a public IP
+func isPublicAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && isPublicIP(ip)
+}
+
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil && (ip4[0] == 0 || cgnatNet.Contains(ip4)) {
+		return false
+	}
+	return true
+}
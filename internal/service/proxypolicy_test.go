@@ -0,0 +1,228 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHostAllowed(t *testing.T) {
+	p := NewProxyPolicy(defaultProxyHosts, false, 1<<20)
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"mmbiz.qpic.cn", true},
+		{"MMBIZ.QPIC.CN", true},
+		{"mmbiz.qpic.cn.", true},
+		{"qpic.cn", true},
+		{"a.b.qpic.cn", true},
+		{"vd1.video.qq.com", true},
+		{"mp.weixin.qq.com", true},
+		{"res.wx.qq.com", true},
+		{"wx.qq.com", false},
+		{"evilqpic.cn", false},
+		{"qpic.cn.evil.com", false},
+		{"mmbiz.qpic.cn.evil.com", false},
+		{"video.qq.com.evil.com", false},
+		{"127.0.0.1", false},
+		{"localhost", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := p.HostAllowed(tt.host); got != tt.want {
+			t.Errorf("HostAllowed(%q) = %t, want %t", tt.host, got, tt.want)
+		}
+	}
+
+	if !NewProxyPolicy([]string{"*"}, false, 0).HostAllowed("example.org") {
+		t.Error("* does not allow every host")
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	p := NewProxyPolicy(defaultProxyHosts, false, 1<<20)
+	tests := []struct {
+		url    string
+		reason string
+	}{
+		{"https://mmbiz.qpic.cn/a/0?wx_fmt=png", ""},
+		{"http://mmbiz.qpic.cn/a/0", ""},
+		{"ftp://mmbiz.qpic.cn/a/0", ViolationScheme},
+		{"file:///etc/passwd", ViolationScheme},
+		{"//mmbiz.qpic.cn/a/0", ViolationScheme},
+		{"https://mmbiz.qpic.cn@evil.com/a", ViolationHost},
+		{"https://evil.com/?u=https://mmbiz.qpic.cn/", ViolationHost},
+		{"https://169.254.169.254/latest/meta-data", ViolationHost},
+	}
+	for _, tt := range tests {
+		err := p.CheckURL(tt.url)
+		var v *ProxyViolation
+		switch {
+		case tt.reason == "" && err != nil:
+			t.Errorf("CheckURL(%q) = %v, want it allowed", tt.url, err)
+		case tt.reason != "" && (!errors.As(err, &v) || v.Reason != tt.reason):
+			t.Errorf("CheckURL(%q) = %v, want a %s violation", tt.url, err, tt.reason)
+		}
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"101.91.22.57", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"100.127.255.255", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"::", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"fd12:3456::1", false},
+		{"ff02::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:8.8.8.8", true},
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip == nil {
+			t.Fatalf("bad test IP %q", tt.ip)
+		}
+		if got := isPublicIP(ip); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %t, want %t", tt.ip, got, tt.want)
+		}
+	}
+
+	for _, address := range []string{"127.0.0.1", "localhost:80", "[::1]:443"} {
+		if isPublicAddress(address) {
+			t.Errorf("isPublicAddress(%q) = true", address)
+		}
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	const maxSize = 1000
+	p := NewProxyPolicy(defaultProxyHosts, false, maxSize)
+	imageTypes := []string{"image/"}
+	videoTypes := []string{"video/", "audio/", "application/octet-stream"}
+
+	tests := []struct {
+		name        string
+		contentType string
+		length      int64
+		allowed     []string
+		reason      string
+	}{
+		{"image", "image/png", 10, imageTypes, ""},
+		{"image type case", "Image/JPEG", 10, imageTypes, ""},
+		{"unknown length", "image/png", -1, imageTypes, ""},
+		{"image at limit", "image/png", maxSize, imageTypes, ""},
+		{"image over limit", "image/png", maxSize + 1, imageTypes, ViolationSize},
+		{"html as image", "text/html", 10, imageTypes, ViolationContentType},
+		{"no type", "", 10, imageTypes, ViolationContentType},
+		{"svg script", "application/xml", 10, imageTypes, ViolationContentType},
+		{"video over limit", "video/mp4", 100 * maxSize, videoTypes, ""},
+		{"audio over limit", "audio/mpeg", 100 * maxSize, videoTypes, ""},
+		{"octet stream over limit", "application/octet-stream", maxSize + 1, videoTypes, ViolationSize},
+		{"html as video", "text/html", 10, videoTypes, ViolationContentType},
+		{"any type", "text/plain", 10, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header:        http.Header{"Content-Type": {tt.contentType}},
+				ContentLength: tt.length,
+				Request:       &http.Request{URL: &url.URL{Scheme: "https", Host: "mmbiz.qpic.cn", Path: "/a"}},
+			}
+			err := p.CheckResponse(resp, tt.allowed)
+			var v *ProxyViolation
+			switch {
+			case tt.reason == "" && err != nil:
+				t.Errorf("CheckResponse = %v, want it allowed", err)
+			case tt.reason != "" && (!errors.As(err, &v) || v.Reason != tt.reason):
+				t.Errorf("CheckResponse = %v, want a %s violation", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestLimitResponse(t *testing.T) {
+	const maxSize = 100
+	p := NewProxyPolicy(nil, false, maxSize)
+	body := strings.Repeat("x", 3*maxSize)
+
+	for _, tt := range []struct {
+		contentType string
+		limited     bool
+	}{
+		{"image/png", true},
+		{"application/octet-stream", true},
+		{"video/mp4", false},
+		{"audio/mpeg", false},
+	} {
+		resp := &http.Response{
+			Header: http.Header{"Content-Type": {tt.contentType}},
+			Body:   io.NopCloser(strings.NewReader(body)),
+		}
+		data, err := io.ReadAll(p.LimitResponse(resp))
+		if tt.limited && (!IsProxyViolation(err) || len(data) > maxSize+1) {
+			t.Errorf("%s: read %d bytes past the limit: %v", tt.contentType, len(data), err)
+		}
+		if !tt.limited && (err != nil || len(data) != len(body)) {
+			t.Errorf("%s: streamed %d of %d bytes: %v", tt.contentType, len(data), len(body), err)
+		}
+	}
+}
+
+func TestProxyClientRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "internal")
+	}))
+	defer srv.Close()
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p := NewProxyPolicy([]string{"*"}, false, 1<<20)
+	for _, host := range []string{"127.0.0.1", "localhost"} {
+		_, err := p.Client().Get("http://" + net.JoinHostPort(host, port) + "/")
+		if !IsProxyViolation(err) {
+			t.Errorf("fetching from %s: %v, want a violation", host, err)
+		}
+	}
+	if n := p.Violations()[ViolationAddress]; n < 2 {
+		t.Errorf("counted %d address violations", n)
+	}
+
+	allowed := NewProxyPolicy([]string{"*"}, true, 1<<20)
+	resp, err := allowed.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("proxy.allow_private: %v", err)
+	}
+	resp.Body.Close()
+
+	// Redirects are checked against the allowed hosts again
+	redirect := httptest.NewServer(http.RedirectHandler("http://evil.example/", http.StatusFound))
+	defer redirect.Close()
+	loopback := NewProxyPolicy([]string{"127.0.0.1"}, true, 1<<20)
+	if _, err := loopback.Client().Get(redirect.URL); !IsProxyViolation(err) {
+		t.Errorf("followed a redirect to a host that is not allowed: %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/store"
+)
+
+// ErrRecheckRunning is returned when a re-check run is already going on
+var ErrRecheckRunning = errors.New("re-check is already running")
+
+// maxRecheckReports is how many past runs RecheckService remembers
+const maxRecheckReports = 10
+
+// RecheckService fetches recent articles again to catch what happens to
+// them after publication. Articles published within revisions.window are
+// checked at most every revisions.interval: an edited article gets a new
+// revision, and one its author deleted or WeChat took down is marked so,
+// keeping the copy we saved.
+type RecheckService struct {
+	store      store.Store
+	wechatSvc  *WechatService
+	fetcherSvc *FetcherService
+	feedCache  *FeedCache
+	hub        *WebSubHub
+
+	mu      sync.Mutex
+	running bool
+	reports []model.RecheckReport
+}
+
+func NewRecheckService(st store.Store, wechatSvc *WechatService, fetcherSvc *FetcherService, feedCache *FeedCache, hub *WebSubHub) *RecheckService {
+	return &RecheckService{
+		store:      st,
+		wechatSvc:  wechatSvc,
+		fetcherSvc: fetcherSvc,
+		feedCache:  feedCache,
+		hub:        hub,
+	}
+}
+
+// Run re-checks a batch of recent articles and returns the report of the
+// run
+func (s *RecheckService) Run() (*model.RecheckReport, error) {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return nil, ErrRecheckRunning
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	report := s.run()
+
+	s.mu.Lock()
+	s.running = false
+	s.reports = append(s.reports, report)
+	if len(s.reports) > maxRecheckReports {
+		s.reports = s.reports[len(s.reports)-maxRecheckReports:]
+	}
+	s.mu.Unlock()
+
+	if report.Error != "" {
+		return &report, errors.New(report.Error)
+	}
+	return &report, nil
+}
+
+// Reports returns the reports of the last runs, newest first
+func (s *RecheckService) Reports() []model.RecheckReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reports := make([]model.RecheckReport, 0, len(s.reports))
+	for i := len(s.reports) - 1; i >= 0; i-- {
+		reports = append(reports, s.reports[i])
+	}
+	return reports
+}
+
+func (s *RecheckService) run() (report model.RecheckReport) {
+	start := time.Now()
+	report = model.RecheckReport{StartedAt: start}
+	defer func() {
+		report.Duration = time.Since(start).Round(time.Millisecond).String()
+	}()
+
+	window := viper.GetDuration("revisions.window")
+	if window <= 0 {
+		window = 72 * time.Hour
+	}
+	batch := viper.GetInt("revisions.batch_size")
+	if batch <= 0 {
+		batch = 50
+	}
+	interval := viper.GetDuration("revisions.request_interval")
+
+	articles, err := s.store.GetArticlesToRecheck(start.Add(-window), start.Add(-viper.GetDuration("revisions.interval")), batch)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	changed := map[string]bool{}
+	for i, a := range articles {
+		if i > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+
+		status, err := s.check(a)
+		if err != nil {
+			log.Printf("Failed to re-check %s: %v", a.Link, err)
+			report.Failed++
+			continue
+		}
+		report.Checked++
+
+		switch status {
+		case "":
+			continue
+		case model.ArticleStatusDeleted:
+			report.Deleted++
+		case model.ArticleStatusViolated:
+			report.Violated++
+		default:
+			report.Updated++
+		}
+		changed[a.BizID] = true
+	}
+
+	for bizID := range changed {
+		s.feedCache.Invalidate(bizID)
+		go s.hub.Publish(bizID)
+	}
+
+	log.Printf("Re-check: checked %d articles, %d updated, %d deleted, %d violated, %d failed",
+		report.Checked, report.Updated, report.Deleted, report.Violated, report.Failed)
+	return report
+}
+
+// check fetches an article again and records what changed. It returns
+// the new status of a removed article, "updated" for an edited one, or ""
+// when nothing changed.
+func (s *RecheckService) check(a model.Article) (string, error) {
+	page, err := s.wechatSvc.GetArticlePage(a.Link)
+	if err != nil {
+		return "", err
+	}
+
+	if page.Removed != "" {
+		if err := s.store.MarkArticleChecked(a.ID, page.Removed); err != nil {
+			return "", err
+		}
+		log.Printf("Article %d (%s) was removed: %s", a.ID, a.Title, page.Removed)
+		return page.Removed, nil
+	}
+
+	// A page without content is most likely a verification prompt, not an
+	// edit that emptied the article
+	if page.Content == "" {
+		return "", errors.New("no content in article page")
+	}
+
+	title := page.Title
+	if title == "" {
+		title = a.Title
+	}
+	content := s.fetcherSvc.ParseArticleContent(page.Content)
+
+	if a.ContentHash == store.ContentHash(title, content) {
+		return "", s.store.MarkArticleChecked(a.ID, model.ArticleStatusPublished)
+	}
+
+	revision, err := s.store.AddArticleRevision(a.ID, title, content)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Article %d (%s) was edited, saved revision %d", a.ID, a.Title, revision)
+	return "updated", nil
+}
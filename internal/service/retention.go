@@ -0,0 +1,150 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/store"
+)
+
+// ErrRetentionRunning is returned when a retention run is already going on
+var ErrRetentionRunning = errors.New("retention is already running")
+
+// maxRetentionReports is how many past runs RetentionService remembers
+const maxRetentionReports = 10
+
+// RetentionService prunes old articles. Each channel keeps its newest
+// rss.keep_old_count articles, and articles older than retention.max_age
+// are dropped; starred and pinned articles are always kept. Runs deleting
+// at least retention.vacuum_min_deleted articles also vacuum and analyze
+// the database.
+type RetentionService struct {
+	store     store.Store
+	feedCache *FeedCache
+
+	mu      sync.Mutex
+	running bool
+	reports []model.RetentionReport
+}
+
+func NewRetentionService(st store.Store, feedCache *FeedCache) *RetentionService {
+	return &RetentionService{store: st, feedCache: feedCache}
+}
+
+// Run applies the retention limits, or with dryRun set only counts what
+// they would delete, and returns the report of the run
+func (s *RetentionService) Run(dryRun bool) (*model.RetentionReport, error) {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return nil, ErrRetentionRunning
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	report := s.run(dryRun)
+
+	s.mu.Lock()
+	s.running = false
+	s.reports = append(s.reports, report)
+	if len(s.reports) > maxRetentionReports {
+		s.reports = s.reports[len(s.reports)-maxRetentionReports:]
+	}
+	s.mu.Unlock()
+
+	if report.Error != "" {
+		return &report, errors.New(report.Error)
+	}
+	return &report, nil
+}
+
+// Reports returns the reports of the last runs, newest first
+func (s *RetentionService) Reports() []model.RetentionReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reports := make([]model.RetentionReport, 0, len(s.reports))
+	for i := len(s.reports) - 1; i >= 0; i-- {
+		reports = append(reports, s.reports[i])
+	}
+	return reports
+}
+
+func (s *RetentionService) run(dryRun bool) (report model.RetentionReport) {
+	start := time.Now()
+	keep := viper.GetInt("rss.keep_old_count")
+	maxAge := viper.GetDuration("retention.max_age")
+
+	report = model.RetentionReport{
+		StartedAt: start,
+		DryRun:    dryRun,
+		KeepCount: keep,
+		MaxAge:    maxAge.String(),
+		Channels:  []model.ChannelPrune{},
+	}
+	defer func() {
+		report.Duration = time.Since(start).Round(time.Millisecond).String()
+	}()
+
+	channels, _, err := s.store.GetChannels(1, 10000, "")
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	for _, ch := range channels {
+		prune := model.ChannelPrune{BizID: ch.BizID, Name: ch.Name}
+		var cutoff time.Time
+		if maxAge > 0 {
+			cutoff = start.Add(-maxAge)
+			if prune.ByAge, err = s.store.PruneArticlesByAge(ch.BizID, cutoff, dryRun); err != nil {
+				report.Error = err.Error()
+				return report
+			}
+		}
+		if keep > 0 {
+			if prune.ByCount, err = s.store.PruneArticlesByCount(ch.BizID, keep, cutoff, dryRun); err != nil {
+				report.Error = err.Error()
+				return report
+			}
+		}
+
+		if n := prune.ByAge + prune.ByCount; n > 0 {
+			report.Deleted += n
+			report.Channels = append(report.Channels, prune)
+			if !dryRun {
+				s.feedCache.Invalidate(ch.BizID)
+			}
+		}
+	}
+
+	verb := "Pruned"
+	if dryRun {
+		verb = "Would prune"
+	}
+	log.Printf("Retention: %s %d articles from %d channels (keep %d, max age %s)",
+		verb, report.Deleted, len(report.Channels), keep, maxAge)
+
+	if dryRun || report.Deleted == 0 || report.Deleted < viper.GetInt64("retention.vacuum_min_deleted") {
+		return report
+	}
+
+	if err := s.store.Analyze(); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	freed, err := s.store.Vacuum()
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Vacuumed = true
+	report.FreedBytes = freed
+	log.Printf("Retention: vacuumed database, freed %d KB", freed/1024)
+	return report
+}
@@ -8,14 +8,20 @@ import (
 )
 
 type SchedulerService struct {
-	fetcherSvc *FetcherService
-	stopChan   chan bool
+	fetcherSvc   *FetcherService
+	retentionSvc *RetentionService
+	recheckSvc   *RecheckService
+	backupSvc    *BackupService
+	stopChan     chan bool
 }
 
-func NewSchedulerService(fetcherSvc *FetcherService) *SchedulerService {
+func NewSchedulerService(fetcherSvc *FetcherService, retentionSvc *RetentionService, recheckSvc *RecheckService, backupSvc *BackupService) *SchedulerService {
 	return &SchedulerService{
-		fetcherSvc: fetcherSvc,
-		stopChan:   make(chan bool),
+		fetcherSvc:   fetcherSvc,
+		retentionSvc: retentionSvc,
+		recheckSvc:   recheckSvc,
+		backupSvc:    backupSvc,
+		stopChan:     make(chan bool),
 	}
 }
 
@@ -83,6 +89,12 @@ func (s *SchedulerService) run(times []string) {
 					s.runFetch()
 				}
 			}
+			if viper.GetBool("retention.enabled") && now.Format("15:04") == viper.GetString("retention.time") {
+				s.runRetention()
+			}
+			if viper.GetBool("backup.enabled") && now.Format("15:04") == viper.GetString("backup.time") {
+				s.runBackup()
+			}
 		case <-s.stopChan:
 			log.Println("Scheduler stopped")
 			return
@@ -99,6 +111,31 @@ func (s *SchedulerService) runFetch() {
 		} else {
 			log.Println("Scheduled fetch completed")
 		}
+
+		// Re-check recent articles once the new ones are in
+		if viper.GetBool("revisions.enabled") {
+			if _, err := s.recheckSvc.Run(); err != nil {
+				log.Printf("Scheduled re-check failed: %v", err)
+			}
+		}
+	}()
+}
+
+func (s *SchedulerService) runRetention() {
+	go func() {
+		log.Println("Starting scheduled retention...")
+		if _, err := s.retentionSvc.Run(false); err != nil {
+			log.Printf("Scheduled retention failed: %v", err)
+		}
+	}()
+}
+
+func (s *SchedulerService) runBackup() {
+	go func() {
+		log.Println("Starting scheduled backup...")
+		if _, err := s.backupSvc.Snapshot(); err != nil {
+			log.Printf("Scheduled backup failed: %v", err)
+		}
 	}()
 }
 
@@ -118,10 +155,10 @@ func (s *SchedulerService) GetSchedulerStatus() map[string]interface{} {
 	}
 
 	return map[string]interface{}{
-		"enabled":   true,
-		"times":     times,
-		"lastRun":   time.Now().Format("2006-01-02 15:04:05"),
-		"nextRun":   "N/A",
+		"enabled": true,
+		"times":   times,
+		"lastRun": time.Now().Format("2006-01-02 15:04:05"),
+		"nextRun": "N/A",
 	}
 }
 
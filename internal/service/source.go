@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+)
+
+// Source is a backend that lists articles of a public account
+type Source interface {
+	// Name returns the name used to select the source in config
+	Name() string
+	// GetChannelInfo gets channel info by biz_id
+	GetChannelInfo(bizID string) (*model.Channel, error)
+	// GetArticles gets articles of a channel, newest first
+	GetArticles(bizID string, offset, count int) ([]model.Article, error)
+	// SearchChannels searches for public accounts
+	SearchChannels(keyword string) ([]model.Channel, error)
+}
+
+// AccountSource is a Source that talks to WeChat as a logged-in account.
+// The fetcher binds an account to it for each request so it can rotate
+// accounts and fail over when one is rate-limited or logged out.
+type AccountSource interface {
+	Source
+	// WithAccount returns a Source that uses the given account
+	WithAccount(account *model.Account) Source
+}
+
+// SourceRegistry holds the available sources and picks one per channel
+type SourceRegistry struct {
+	mu      sync.RWMutex
+	sources map[string]Source
+}
+
+func NewSourceRegistry(sources ...Source) *SourceRegistry {
+	r := &SourceRegistry{
+		sources: make(map[string]Source),
+	}
+	for _, src := range sources {
+		r.Register(src)
+	}
+	return r
+}
+
+// Register adds a source, replacing any source with the same name
+func (r *SourceRegistry) Register(src Source) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources[src.Name()] = src
+}
+
+// Get returns the source with the given name
+func (r *SourceRegistry) Get(name string) (Source, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	src, ok := r.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown source: %s", name)
+	}
+	return src, nil
+}
+
+// Default returns the globally configured source
+func (r *SourceRegistry) Default() (Source, error) {
+	name := viper.GetString("source.default")
+	if name == "" {
+		name = "wechat2rss"
+	}
+	return r.Get(name)
+}
+
+// ForChannel returns the source configured for a channel.
+// A channel listed under source.channels overrides source.default.
+func (r *SourceRegistry) ForChannel(bizID string) (Source, error) {
+	// Viper lowercases map keys
+	if name := viper.GetStringMapString("source.channels")[strings.ToLower(bizID)]; name != "" {
+		return r.Get(name)
+	}
+	return r.Default()
+}
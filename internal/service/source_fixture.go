@@ -0,0 +1,123 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+)
+
+// FixtureSource reads channels and articles from JSON files on disk.
+// Each channel lives in <dir>/<biz_id>.json:
+//
+//	{"channel": {...}, "articles": [...]}
+type FixtureSource struct {
+	dir string
+}
+
+type fixtureFile struct {
+	Channel  model.Channel   `json:"channel"`
+	Articles []model.Article `json:"articles"`
+}
+
+func NewFixtureSource(dir string) *FixtureSource {
+	if dir == "" {
+		dir = viper.GetString("source.fixture.dir")
+	}
+	if dir == "" {
+		dir = "./fixtures"
+	}
+	return &FixtureSource{
+		dir: dir,
+	}
+}
+
+func (s *FixtureSource) Name() string {
+	return "fixture"
+}
+
+func (s *FixtureSource) load(bizID string) (*fixtureFile, error) {
+	// biz_id is used as a file name, so reject anything that could escape dir
+	if bizID == "" || strings.ContainsAny(bizID, `/\`) || strings.Contains(bizID, "..") {
+		return nil, fmt.Errorf("invalid biz_id: %q", bizID)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, bizID+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var f fixtureFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("fixture %s: %v", bizID, err)
+	}
+	if f.Channel.BizID == "" {
+		f.Channel.BizID = bizID
+	}
+	return &f, nil
+}
+
+// GetChannelInfo gets channel info by biz_id
+func (s *FixtureSource) GetChannelInfo(bizID string) (*model.Channel, error) {
+	f, err := s.load(bizID)
+	if err != nil {
+		return nil, err
+	}
+
+	channel := f.Channel
+	if channel.Name == "" {
+		channel.Name = bizID
+	}
+	if channel.Status == "" {
+		channel.Status = "active"
+	}
+	return &channel, nil
+}
+
+// GetArticles gets articles from a channel
+func (s *FixtureSource) GetArticles(bizID string, offset, count int) ([]model.Article, error) {
+	f, err := s.load(bizID)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset >= len(f.Articles) {
+		return nil, nil
+	}
+	end := offset + count
+	if count <= 0 || end > len(f.Articles) {
+		end = len(f.Articles)
+	}
+
+	articles := f.Articles[offset:end]
+	for i := range articles {
+		articles[i].BizID = bizID
+	}
+	return articles, nil
+}
+
+// SearchChannels searches fixture channels by name
+func (s *FixtureSource) SearchChannels(keyword string) ([]model.Channel, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var channels []model.Channel
+	for _, file := range files {
+		bizID := strings.TrimSuffix(filepath.Base(file), ".json")
+		ch, err := s.GetChannelInfo(bizID)
+		if err != nil {
+			continue
+		}
+		if keyword == "" || strings.Contains(ch.Name, keyword) {
+			channels = append(channels, *ch)
+		}
+	}
+	return channels, nil
+}
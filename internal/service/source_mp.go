@@ -0,0 +1,303 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/parnurzeal/gorequest"
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/store"
+)
+
+var (
+	ErrNoAccount      = errors.New("no usable account")
+	ErrFreqControl    = errors.New("frequency control")
+	ErrInvalidSession = errors.New("invalid session")
+)
+
+// mpError is an error returned in base_resp by the official-account platform
+type mpError struct {
+	Ret int
+	Msg string
+}
+
+func (e *mpError) Error() string {
+	return fmt.Sprintf("mp error %d: %s", e.Ret, e.Msg)
+}
+
+func (e *mpError) Unwrap() error {
+	switch e.Ret {
+	case 200013:
+		return ErrFreqControl
+	case 200003, 200040:
+		return ErrInvalidSession
+	}
+	return nil
+}
+
+type mpBaseResp struct {
+	Ret    int    `json:"ret"`
+	ErrMsg string `json:"err_msg"`
+}
+
+func (r mpBaseResp) err() error {
+	if r.Ret == 0 {
+		return nil
+	}
+	return &mpError{Ret: r.Ret, Msg: r.ErrMsg}
+}
+
+type mpBiz struct {
+	FakeID       string `json:"fakeid"`
+	Nickname     string `json:"nickname"`
+	Alias        string `json:"alias"`
+	RoundHeadImg string `json:"round_head_img"`
+	Signature    string `json:"signature"`
+}
+
+type mpAppMsg struct {
+	AID        string `json:"aid"`
+	Title      string `json:"title"`
+	Link       string `json:"link"`
+	Cover      string `json:"cover"`
+	Digest     string `json:"digest"`
+	CreateTime int64  `json:"create_time"`
+	UpdateTime int64  `json:"update_time"`
+}
+
+// MPSource lists articles directly from mp.weixin.qq.com using the cookie
+// and token of a logged-in account
+type MPSource struct {
+	store   store.Store
+	baseURL string
+	bound   *model.Account
+}
+
+func NewMPSource(st store.Store, baseURL string) *MPSource {
+	if baseURL == "" {
+		baseURL = viper.GetString("source.mp.url")
+	}
+	if baseURL == "" {
+		baseURL = "https://mp.weixin.qq.com"
+	}
+	return &MPSource{
+		store:   st,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (s *MPSource) Name() string {
+	return "mp"
+}
+
+// WithAccount returns a copy of the source that always uses account
+func (s *MPSource) WithAccount(account *model.Account) Source {
+	return &MPSource{
+		store:   s.store,
+		baseURL: s.baseURL,
+		bound:   account,
+	}
+}
+
+// account returns the bound account, or else the first usable one
+func (s *MPSource) account() (*model.Account, error) {
+	if s.bound != nil {
+		return s.bound, nil
+	}
+
+	accounts, err := s.store.GetUsableAccounts()
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return nil, ErrNoAccount
+	}
+	return &accounts[0], nil
+}
+
+// get calls a cgi-bin endpoint and decodes the JSON response into out
+func (s *MPSource) get(account *model.Account, path string, params url.Values, out interface{}) error {
+	params.Set("token", account.Token)
+	params.Set("lang", "zh_CN")
+	params.Set("f", "json")
+	params.Set("ajax", "1")
+
+	resp, body, errs := gorequest.New().Get(s.baseURL+path+"?"+params.Encode()).
+		Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36").
+		Set("Referer", s.baseURL+"/cgi-bin/appmsg").
+		Set("Cookie", account.Cookie).
+		End()
+
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	return json.Unmarshal([]byte(body), out)
+}
+
+func (s *MPSource) searchBiz(account *model.Account, query string, begin, count int) ([]mpBiz, error) {
+	params := url.Values{}
+	params.Set("action", "search_biz")
+	params.Set("query", query)
+	params.Set("begin", fmt.Sprint(begin))
+	params.Set("count", fmt.Sprint(count))
+
+	var result struct {
+		BaseResp mpBaseResp `json:"base_resp"`
+		List     []mpBiz    `json:"list"`
+	}
+	if err := s.get(account, "/cgi-bin/searchbiz", params, &result); err != nil {
+		return nil, err
+	}
+	if err := result.BaseResp.err(); err != nil {
+		return nil, err
+	}
+	return result.List, nil
+}
+
+// GetChannelInfo gets channel info by biz_id
+func (s *MPSource) GetChannelInfo(bizID string) (*model.Channel, error) {
+	account, err := s.account()
+	if err != nil {
+		return nil, err
+	}
+
+	channel := &model.Channel{
+		BizID:       bizID,
+		Name:        bizID,
+		Description: "公众号",
+		Link:        fmt.Sprintf("%s/mp/profile_ext?action=home&__biz=%s", s.baseURL, url.QueryEscape(bizID)),
+		AccountID:   account.ID,
+		Status:      "active",
+	}
+
+	// The platform has no lookup by fakeid, so search for it and match
+	list, err := s.searchBiz(account, bizID, 0, 5)
+	if err != nil {
+		return nil, err
+	}
+	for _, biz := range list {
+		if biz.FakeID == bizID {
+			channel.Name = biz.Nickname
+			channel.Description = biz.Signature
+			channel.Avatar = biz.RoundHeadImg
+			break
+		}
+	}
+
+	return channel, nil
+}
+
+// GetArticles gets articles from a channel, paging through list_ex
+func (s *MPSource) GetArticles(bizID string, offset, count int) ([]model.Article, error) {
+	account, err := s.account()
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := viper.GetInt("source.mp.page_size")
+	if pageSize <= 0 {
+		pageSize = 5
+	}
+	interval := viper.GetDuration("source.mp.page_interval")
+
+	var articles []model.Article
+	for begin := offset; len(articles) < count; begin += pageSize {
+		if begin > offset && interval > 0 {
+			time.Sleep(interval)
+		}
+
+		size := pageSize
+		if remaining := count - len(articles); remaining < size {
+			size = remaining
+		}
+
+		params := url.Values{}
+		params.Set("action", "list_ex")
+		params.Set("type", "9")
+		params.Set("query", "")
+		params.Set("fakeid", bizID)
+		params.Set("begin", fmt.Sprint(begin))
+		params.Set("count", fmt.Sprint(size))
+
+		var result struct {
+			BaseResp   mpBaseResp `json:"base_resp"`
+			AppMsgCnt  int        `json:"app_msg_cnt"`
+			AppMsgList []mpAppMsg `json:"app_msg_list"`
+		}
+		if err := s.get(account, "/cgi-bin/appmsg", params, &result); err != nil {
+			return articles, err
+		}
+		if err := result.BaseResp.err(); err != nil {
+			return articles, err
+		}
+
+		for _, msg := range result.AppMsgList {
+			articles = append(articles, msg.article(bizID))
+		}
+
+		if len(result.AppMsgList) < size || begin+size >= result.AppMsgCnt {
+			break
+		}
+	}
+
+	return articles, nil
+}
+
+// SearchChannels searches for public accounts
+func (s *MPSource) SearchChannels(keyword string) ([]model.Channel, error) {
+	account, err := s.account()
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := s.searchBiz(account, keyword, 0, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	var channels []model.Channel
+	for _, biz := range list {
+		channels = append(channels, model.Channel{
+			BizID:       biz.FakeID,
+			Name:        biz.Nickname,
+			Description: biz.Signature,
+			Avatar:      biz.RoundHeadImg,
+			AccountID:   account.ID,
+			Status:      "active",
+		})
+	}
+	return channels, nil
+}
+
+func (m mpAppMsg) article(bizID string) model.Article {
+	published := m.CreateTime
+	if published == 0 {
+		published = m.UpdateTime
+	}
+
+	a := model.Article{
+		BizID:       bizID,
+		Title:       m.Title,
+		Description: m.Digest,
+		Link:        m.Link,
+		Cover:       m.Cover,
+	}
+	if strings.HasPrefix(a.Link, "http://") {
+		a.Link = "https://" + strings.TrimPrefix(a.Link, "http://")
+	}
+	if published > 0 {
+		a.PublishedAt = time.Unix(published, 0)
+	}
+	return a
+}
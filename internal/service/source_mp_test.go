@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/model"
+)
+
+const testFakeID = "MzA5MDAwMDAwMA=="
+
+// mpTestServer serves the recorded list_ex pages in testdata/mp by begin,
+// or fixture for every request when it is set, and records the begin of
+// each request
+type mpTestServer struct {
+	*httptest.Server
+	t       *testing.T
+	fixture string
+
+	mu     sync.Mutex
+	begins []string
+}
+
+func newMPTestServer(t *testing.T, fixture string) *mpTestServer {
+	s := &mpTestServer{t: t, fixture: fixture}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *mpTestServer) serve(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if r.URL.Path != "/cgi-bin/appmsg" || q.Get("action") != "list_ex" || q.Get("fakeid") != testFakeID {
+		s.t.Errorf("unexpected request %s", r.URL)
+		http.NotFound(w, r)
+		return
+	}
+	if q.Get("token") != "test-token" || r.Header.Get("Cookie") != "slave_sid=test" {
+		s.t.Errorf("request %s does not carry the account's token and cookie", r.URL)
+	}
+
+	s.mu.Lock()
+	s.begins = append(s.begins, q.Get("begin"))
+	s.mu.Unlock()
+
+	name := s.fixture
+	if name == "" {
+		name = "appmsg_begin" + q.Get("begin") + ".json"
+	}
+	data, err := os.ReadFile(filepath.Join("testdata", "mp", name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// testMPSource returns an MPSource bound to a test account that pages
+// through srv two articles at a time
+func testMPSource(t *testing.T, srv *mpTestServer) Source {
+	pageSize, interval := viper.Get("source.mp.page_size"), viper.Get("source.mp.page_interval")
+	viper.Set("source.mp.page_size", 2)
+	viper.Set("source.mp.page_interval", "0s")
+	t.Cleanup(func() {
+		viper.Set("source.mp.page_size", pageSize)
+		viper.Set("source.mp.page_interval", interval)
+	})
+
+	account := &model.Account{ID: 1, Name: "test", Token: "test-token", Cookie: "slave_sid=test"}
+	return NewMPSource(nil, srv.URL).WithAccount(account)
+}
+
+func TestMPSourceGetArticles(t *testing.T) {
+	srv := newMPTestServer(t, "")
+	articles, err := testMPSource(t, srv).GetArticles(testFakeID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := srv.begins, []string{"0", "2", "4"}; !slices.Equal(got, want) {
+		t.Errorf("requested pages at begin %v, want %v", got, want)
+	}
+	if len(articles) != 5 {
+		t.Fatalf("got %d articles, want 5", len(articles))
+	}
+
+	first := articles[0]
+	if first.BizID != testFakeID || first.Title != "第五期：本周要闻" || first.Description != "本周要闻速览" {
+		t.Errorf("first article is %+v", first)
+	}
+	if first.Cover != "https://mmbiz.qpic.cn/mmbiz_jpg/cover05/0?wx_fmt=jpeg" {
+		t.Errorf("Cover = %q", first.Cover)
+	}
+	if want := time.Unix(1712728800, 0); !first.PublishedAt.Equal(want) {
+		t.Errorf("PublishedAt = %v, want %v", first.PublishedAt, want)
+	}
+	if first.Link != "https://mp.weixin.qq.com/s?__biz=MzA5MDAwMDAwMA==&mid=2650000005&idx=1&sn=e5" {
+		t.Errorf("Link = %q, want it upgraded to https", first.Link)
+	}
+
+	// Without a create_time the update_time is the publish time
+	fourth := articles[3]
+	if want := time.Unix(1712469600, 0); !fourth.PublishedAt.Equal(want) {
+		t.Errorf("PublishedAt without create_time = %v, want %v", fourth.PublishedAt, want)
+	}
+	if fourth.Cover != "" || fourth.Description != "转载文章" {
+		t.Errorf("fourth article is %+v", fourth)
+	}
+
+	if last := articles[4]; last.Title != "第一期：创刊" {
+		t.Errorf("last article is %q", last.Title)
+	}
+}
+
+func TestMPSourceGetArticlesOffset(t *testing.T) {
+	srv := newMPTestServer(t, "")
+	articles, err := testMPSource(t, srv).GetArticles(testFakeID, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := srv.begins, []string{"2", "4"}; !slices.Equal(got, want) {
+		t.Errorf("requested pages at begin %v, want %v", got, want)
+	}
+	if len(articles) != 3 || articles[0].Title != "第三期：读者来信" {
+		t.Errorf("got %d articles starting with %+v", len(articles), articles)
+	}
+}
+
+func TestMPSourceErrors(t *testing.T) {
+	tests := []struct {
+		fixture string
+		ret     int
+		want    error
+	}{
+		{"freq_control.json", 200013, ErrFreqControl},
+		{"invalid_session.json", 200003, ErrInvalidSession},
+		{"invalid_csrf_token.json", 200040, ErrInvalidSession},
+		{"invalid_args.json", 200002, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			srv := newMPTestServer(t, tt.fixture)
+			_, err := testMPSource(t, srv).GetArticles(testFakeID, 0, 10)
+
+			var mpErr *mpError
+			if !errors.As(err, &mpErr) || mpErr.Ret != tt.ret {
+				t.Fatalf("err = %v, want mp error %d", err, tt.ret)
+			}
+			for _, sentinel := range []error{ErrFreqControl, ErrInvalidSession} {
+				if got := errors.Is(err, sentinel); got != (sentinel == tt.want) {
+					t.Errorf("errors.Is(err, %v) = %t", sentinel, got)
+				}
+			}
+			if len(srv.begins) != 1 {
+				t.Errorf("made %d requests, want 1 as paging stops at the error", len(srv.begins))
+			}
+		})
+	}
+}
+
+func TestMPAppMsgLink(t *testing.T) {
+	tests := map[string]string{
+		"http://mp.weixin.qq.com/s?__biz=MzA5&mid=1":          "https://mp.weixin.qq.com/s?__biz=MzA5&mid=1",
+		"https://mp.weixin.qq.com/s?__biz=MzA5&mid=1":         "https://mp.weixin.qq.com/s?__biz=MzA5&mid=1",
+		"https://mp.weixin.qq.com/s?src=http://example.org/a": "https://mp.weixin.qq.com/s?src=http://example.org/a",
+		"http://mp.weixin.qq.com/s?src=http://example.org/a":  "https://mp.weixin.qq.com/s?src=http://example.org/a",
+		"": "",
+	}
+	for link, want := range tests {
+		if got := (mpAppMsg{Link: link}).article(testFakeID).Link; got != want {
+			t.Errorf("link %q became %q, want %q", link, got, want)
+		}
+	}
+}
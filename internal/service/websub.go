@@ -0,0 +1,336 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"wechatoarss/internal/store"
+)
+
+// WebSub modes
+const (
+	WebSubSubscribe   = "subscribe"
+	WebSubUnsubscribe = "unsubscribe"
+)
+
+// ErrUnknownTopic is returned for subscriptions to a URL that is not one
+// of our feeds
+var ErrUnknownTopic = errors.New("topic is not a feed served by this hub")
+
+// ErrTooManySubscriptions is returned for subscriptions past
+// websub.max_per_topic or websub.max_per_callback
+var ErrTooManySubscriptions = errors.New("too many subscriptions for this topic or callback")
+
+// TopicRenderer renders the feed published at topic
+type TopicRenderer func(topic string) (*RenderedFeed, error)
+
+// WebSubRequest is a subscribe or unsubscribe request sent to the hub
+type WebSubRequest struct {
+	Mode     string
+	Callback string
+	Topic    string
+	Secret   string
+	Lease    time.Duration
+}
+
+// WebSubHub is a WebSub (W3C) hub for our own feeds. Subscribers register
+// a callback for a feed URL; after their intent is verified, every update
+// of that feed is POSTed to the callback, signed with the subscriber's
+// secret when one was given. Callbacks are chosen by whoever subscribes,
+// so unless websub.allow_private is set the hub will not connect to
+// private, loopback or link-local addresses.
+type WebSubHub struct {
+	store    store.Store
+	client   *http.Client
+	render   TopicRenderer
+	leaseDef time.Duration
+	leaseMin time.Duration
+	leaseMax time.Duration
+
+	maxPerTopic    int // 0 for no limit
+	maxPerCallback int
+}
+
+func NewWebSubHub(st store.Store, allowPrivate bool, leaseDef, leaseMin, leaseMax time.Duration, maxPerTopic, maxPerCallback int) *WebSubHub {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = dialPublicOnly
+	}
+
+	return &WebSubHub{
+		store: st,
+		client: &http.Client{
+			Timeout: 15 * time.Second,
+			Transport: &http.Transport{
+				// No environment proxy: it would bypass the dial check
+				Proxy:               nil,
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 10 * time.Second,
+				MaxIdleConns:        10,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+		leaseDef: leaseDef,
+		leaseMin: leaseMin,
+		leaseMax: leaseMax,
+
+		maxPerTopic:    maxPerTopic,
+		maxPerCallback: maxPerCallback,
+	}
+}
+
+// NewWebSubHubFromConfig builds the hub configured under websub, or
+// returns nil if it is disabled
+func NewWebSubHubFromConfig(st store.Store) *WebSubHub {
+	if !viper.GetBool("websub.enabled") {
+		return nil
+	}
+	return NewWebSubHub(
+		st,
+		viper.GetBool("websub.allow_private"),
+		viper.GetDuration("websub.lease_default"),
+		viper.GetDuration("websub.lease_min"),
+		viper.GetDuration("websub.lease_max"),
+		viper.GetInt("websub.max_per_topic"),
+		viper.GetInt("websub.max_per_callback"),
+	)
+}
+
+// SetRenderer sets how the hub renders the feed of a topic
+func (h *WebSubHub) SetRenderer(render TopicRenderer) {
+	if h != nil {
+		h.render = render
+	}
+}
+
+// HubURL returns the URL feeds advertise as their hub, or "" when the hub
+// is disabled
+func (h *WebSubHub) HubURL(host string) string {
+	if h == nil {
+		return ""
+	}
+	return strings.TrimSuffix(host, "/") + "/websub"
+}
+
+// Request validates a subscription request and verifies the subscriber's
+// intent in the background, as the spec requires the hub to answer
+// 202 Accepted before verifying
+func (h *WebSubHub) Request(req WebSubRequest) error {
+	if req.Mode != WebSubSubscribe && req.Mode != WebSubUnsubscribe {
+		return fmt.Errorf("unsupported hub.mode %q", req.Mode)
+	}
+
+	callback, err := url.Parse(req.Callback)
+	if err != nil || (callback.Scheme != "http" && callback.Scheme != "https") || callback.Host == "" {
+		return errors.New("hub.callback must be an absolute http(s) URL")
+	}
+	if len(req.Secret) >= 200 {
+		return errors.New("hub.secret must be shorter than 200 bytes")
+	}
+
+	if req.Mode == WebSubSubscribe {
+		if h.render == nil {
+			return ErrUnknownTopic
+		}
+		if _, err := h.render(req.Topic); err != nil {
+			return ErrUnknownTopic
+		}
+		if err := h.checkLimits(req.Callback, req.Topic); err != nil {
+			return err
+		}
+	}
+
+	req.Lease = h.clampLease(req.Lease)
+	go h.verify(req)
+	return nil
+}
+
+// checkLimits refuses a new subscription of callback to topic when either
+// already has as many subscriptions as allowed. Renewing an existing
+// subscription is always allowed.
+func (h *WebSubHub) checkLimits(callback, topic string) error {
+	if h.maxPerTopic <= 0 && h.maxPerCallback <= 0 {
+		return nil
+	}
+	byCallback, byTopic, err := h.store.CountSubscriptions(callback, topic)
+	if err != nil {
+		return err
+	}
+	if (h.maxPerTopic > 0 && byTopic >= h.maxPerTopic) || (h.maxPerCallback > 0 && byCallback >= h.maxPerCallback) {
+		return ErrTooManySubscriptions
+	}
+	return nil
+}
+
+func (h *WebSubHub) clampLease(lease time.Duration) time.Duration {
+	if lease <= 0 {
+		lease = h.leaseDef
+	}
+	if h.leaseMin > 0 && lease < h.leaseMin {
+		lease = h.leaseMin
+	}
+	if h.leaseMax > 0 && lease > h.leaseMax {
+		lease = h.leaseMax
+	}
+	return lease
+}
+
+// verify confirms the request with the subscriber and applies it
+func (h *WebSubHub) verify(req WebSubRequest) {
+	challenge := randomHex(16)
+
+	params := url.Values{}
+	params.Set("hub.mode", req.Mode)
+	params.Set("hub.topic", req.Topic)
+	params.Set("hub.challenge", challenge)
+	if req.Mode == WebSubSubscribe {
+		params.Set("hub.lease_seconds", fmt.Sprintf("%d", int64(req.Lease/time.Second)))
+	}
+
+	verifyURL := req.Callback
+	if strings.Contains(verifyURL, "?") {
+		verifyURL += "&" + params.Encode()
+	} else {
+		verifyURL += "?" + params.Encode()
+	}
+
+	resp, err := h.client.Get(verifyURL)
+	if err != nil {
+		log.Printf("WebSub: failed to verify %s of %s: %v", req.Mode, req.Callback, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || strings.TrimSpace(string(body)) != challenge {
+		log.Printf("WebSub: %s of %s to %s not confirmed (HTTP %d)", req.Mode, req.Callback, req.Topic, resp.StatusCode)
+		return
+	}
+
+	if req.Mode == WebSubUnsubscribe {
+		err = h.store.DeleteSubscription(req.Callback, req.Topic)
+	} else if err = h.checkLimits(req.Callback, req.Topic); err == nil {
+		// Checked again as other requests may have been verified meanwhile
+		err = h.store.UpsertSubscription(req.Callback, req.Topic, req.Secret, req.Lease)
+	}
+	if err != nil {
+		log.Printf("WebSub: failed to save %s of %s: %v", req.Mode, req.Callback, err)
+		return
+	}
+	log.Printf("WebSub: %s of %s to %s verified", req.Mode, req.Callback, req.Topic)
+}
+
+// Publish pushes every feed that includes the channel bizID to its
+// subscribers
+func (h *WebSubHub) Publish(bizID string) {
+	if h == nil || h.render == nil {
+		return
+	}
+
+	if n, err := h.store.DeleteExpiredSubscriptions(); err == nil && n > 0 {
+		log.Printf("WebSub: removed %d expired subscriptions", n)
+	}
+
+	subs, err := h.store.GetActiveSubscriptions()
+	if err != nil {
+		log.Printf("WebSub: failed to load subscriptions: %v", err)
+		return
+	}
+
+	feeds := make(map[string]*RenderedFeed)
+	for _, sub := range subs {
+		feed, ok := feeds[sub.Topic]
+		if !ok {
+			feed, err = h.render(sub.Topic)
+			if err != nil {
+				log.Printf("WebSub: failed to render %s: %v", sub.Topic, err)
+			}
+			feeds[sub.Topic] = feed
+		}
+		if feed == nil || !feed.Includes(bizID) {
+			continue
+		}
+
+		if err := h.deliver(sub.Callback, sub.Topic, sub.Secret, feed); err != nil {
+			log.Printf("WebSub: failed to deliver %s to %s: %v", sub.Topic, sub.Callback, err)
+		}
+	}
+}
+
+// deliver POSTs feed to callback, retrying transient failures. A 410 Gone
+// response ends the subscription.
+func (h *WebSubHub) deliver(callback, topic, secret string, feed *RenderedFeed) error {
+	var lastErr error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 2 * time.Second)
+		}
+
+		req, err := http.NewRequest("POST", callback, bytes.NewReader(feed.Body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", feed.ContentType)
+		req.Header.Add("Link", fmt.Sprintf(`<%s>; rel="hub"`, h.hubURL()))
+		req.Header.Add("Link", fmt.Sprintf(`<%s>; rel="self"`, topic))
+		if secret != "" {
+			req.Header.Set("X-Hub-Signature", "sha256="+SignWebSubBody(secret, feed.Body))
+		}
+
+		resp, err := h.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+			return nil
+		case resp.StatusCode == http.StatusGone:
+			return h.store.DeleteSubscription(callback, topic)
+		case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+			return fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		lastErr = fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return lastErr
+}
+
+// hubURL returns the hub URL under rss.host
+func (h *WebSubHub) hubURL() string {
+	host := viper.GetString("rss.host")
+	if host == "" {
+		host = "http://localhost:8080"
+	}
+	return h.HubURL(host)
+}
+
+// SignWebSubBody returns the hex HMAC-SHA256 of body keyed with secret, as
+// sent in X-Hub-Signature
+func SignWebSubBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
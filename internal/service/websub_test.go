@@ -0,0 +1,301 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/store"
+)
+
+const testTopic = "https://feeds.example.org/feed/BIZ_A"
+
+// delivery is a feed POSTed to a test subscriber
+type delivery struct {
+	body      []byte
+	signature string
+}
+
+// testSubscriber is a WebSub subscriber that confirms every verification
+// unless refuse is set, and reports verifications and deliveries
+type testSubscriber struct {
+	*httptest.Server
+	refuse     bool
+	params     chan map[string]string
+	deliveries chan delivery
+}
+
+func newTestSubscriber(t *testing.T, refuse bool) *testSubscriber {
+	s := &testSubscriber{
+		refuse:     refuse,
+		params:     make(chan map[string]string, 8),
+		deliveries: make(chan delivery, 8),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			q := r.URL.Query()
+			s.params <- map[string]string{
+				"mode":          q.Get("hub.mode"),
+				"topic":         q.Get("hub.topic"),
+				"lease_seconds": q.Get("hub.lease_seconds"),
+			}
+			if s.refuse {
+				http.NotFound(w, r)
+				return
+			}
+			io.WriteString(w, q.Get("hub.challenge"))
+		case http.MethodPost:
+			body, _ := io.ReadAll(r.Body)
+			s.deliveries <- delivery{body: body, signature: r.Header.Get("X-Hub-Signature")}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// newTestHub returns a hub over a scratch store that may call back to
+// loopback addresses and renders testTopic with articles of BIZ_A
+func newTestHub(t *testing.T, maxPerTopic, maxPerCallback int) (*WebSubHub, store.Store) {
+	t.Helper()
+	st, err := store.OpenSQLite(filepath.Join(t.TempDir(), "wechatoarss.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	if _, err := st.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	hub := NewWebSubHub(st, true, time.Hour, time.Minute, 2*time.Hour, maxPerTopic, maxPerCallback)
+	hub.SetRenderer(func(topic string) (*RenderedFeed, error) {
+		if topic != testTopic && topic != testTopic+".json" {
+			return nil, errors.New("no such feed")
+		}
+		return &RenderedFeed{Body: []byte("<rss>" + topic + "</rss>"), ContentType: "application/rss+xml", BizIDs: []string{"BIZ_A"}}, nil
+	})
+	return hub, st
+}
+
+// waitVerified returns the parameters of the next verification request
+func waitVerified(t *testing.T, sub *testSubscriber) map[string]string {
+	t.Helper()
+	select {
+	case params := <-sub.params:
+		return params
+	case <-time.After(5 * time.Second):
+		t.Fatal("hub did not verify the request")
+		return nil
+	}
+}
+
+// waitSubscriptions waits until the hub has stored n active subscriptions
+func waitSubscriptions(t *testing.T, st store.Store, n int) []model.WebSubSubscription {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		subs, err := st.GetActiveSubscriptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(subs) == n {
+			return subs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d active subscriptions, want %d", len(subs), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebSubSubscribe(t *testing.T) {
+	hub, st := newTestHub(t, 0, 0)
+	sub := newTestSubscriber(t, false)
+
+	// Leases are clamped to websub.lease_max
+	err := hub.Request(WebSubRequest{Mode: WebSubSubscribe, Callback: sub.URL + "/cb?id=1", Topic: testTopic, Secret: "s3cret", Lease: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := waitVerified(t, sub)
+	if params["mode"] != WebSubSubscribe || params["topic"] != testTopic || params["lease_seconds"] != "7200" {
+		t.Errorf("verification request %v", params)
+	}
+	subs := waitSubscriptions(t, st, 1)
+	if subs[0].Callback != sub.URL+"/cb?id=1" || subs[0].Secret != "s3cret" || subs[0].LeaseSeconds != 7200 {
+		t.Errorf("stored subscription %+v", subs[0])
+	}
+
+	if err := hub.Request(WebSubRequest{Mode: WebSubUnsubscribe, Callback: sub.URL + "/cb?id=1", Topic: testTopic}); err != nil {
+		t.Fatal(err)
+	}
+	if params := waitVerified(t, sub); params["mode"] != WebSubUnsubscribe {
+		t.Errorf("verification request %v", params)
+	}
+	waitSubscriptions(t, st, 0)
+}
+
+func TestWebSubRequestRejected(t *testing.T) {
+	hub, _ := newTestHub(t, 0, 0)
+	tests := []struct {
+		name string
+		req  WebSubRequest
+		want error
+	}{
+		{"unknown topic", WebSubRequest{Mode: WebSubSubscribe, Callback: "https://reader.example.org/cb", Topic: "https://feeds.example.org/feed/BIZ_X"}, ErrUnknownTopic},
+		{"bad mode", WebSubRequest{Mode: "publish", Callback: "https://reader.example.org/cb", Topic: testTopic}, nil},
+		{"relative callback", WebSubRequest{Mode: WebSubSubscribe, Callback: "/cb", Topic: testTopic}, nil},
+		{"ftp callback", WebSubRequest{Mode: WebSubSubscribe, Callback: "ftp://reader.example.org/cb", Topic: testTopic}, nil},
+		{"long secret", WebSubRequest{Mode: WebSubSubscribe, Callback: "https://reader.example.org/cb", Topic: testTopic, Secret: string(make([]byte, 200))}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := hub.Request(tt.req)
+			if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Errorf("Request = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebSubVerificationRefused(t *testing.T) {
+	hub, st := newTestHub(t, 0, 0)
+	sub := newTestSubscriber(t, true)
+
+	if err := hub.Request(WebSubRequest{Mode: WebSubSubscribe, Callback: sub.URL, Topic: testTopic}); err != nil {
+		t.Fatal(err)
+	}
+	waitVerified(t, sub)
+
+	// The subscription is stored right after a confirmed verification
+	time.Sleep(100 * time.Millisecond)
+	if subs, _ := st.GetActiveSubscriptions(); len(subs) != 0 {
+		t.Errorf("stored %d subscriptions the subscriber did not confirm", len(subs))
+	}
+}
+
+func TestWebSubPublish(t *testing.T) {
+	hub, st := newTestHub(t, 0, 0)
+	signed := newTestSubscriber(t, false)
+	unsigned := newTestSubscriber(t, false)
+	expired := newTestSubscriber(t, false)
+
+	if err := st.UpsertSubscription(signed.URL, testTopic, "s3cret", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpsertSubscription(unsigned.URL, testTopic+".json", "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpsertSubscription(expired.URL, testTopic, "", -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other channels are not in the feed
+	hub.Publish("BIZ_B")
+	if len(signed.deliveries)+len(unsigned.deliveries) > 0 {
+		t.Fatal("delivered a feed that does not include the channel")
+	}
+
+	hub.Publish("BIZ_A")
+
+	d := <-signed.deliveries
+	if string(d.body) != "<rss>"+testTopic+"</rss>" {
+		t.Errorf("delivered %q", d.body)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(d.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); d.signature != want {
+		t.Errorf("X-Hub-Signature = %q, want %q", d.signature, want)
+	}
+
+	d = <-unsigned.deliveries
+	if string(d.body) != "<rss>"+testTopic+".json</rss>" || d.signature != "" {
+		t.Errorf("delivered %q signed %q to the subscriber without a secret", d.body, d.signature)
+	}
+
+	if len(expired.deliveries) > 0 {
+		t.Error("delivered to an expired subscription")
+	}
+	if subs := waitSubscriptions(t, st, 2); subs[0].Callback == expired.URL || subs[1].Callback == expired.URL {
+		t.Error("expired subscription still active")
+	}
+	if n, _ := st.DeleteExpiredSubscriptions(); n != 0 {
+		t.Errorf("publishing left %d expired subscriptions behind", n)
+	}
+}
+
+func TestWebSubGone(t *testing.T) {
+	hub, st := newTestHub(t, 0, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer srv.Close()
+
+	if err := st.UpsertSubscription(srv.URL, testTopic, "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	hub.Publish("BIZ_A")
+	waitSubscriptions(t, st, 0)
+}
+
+func TestWebSubLimits(t *testing.T) {
+	hub, st := newTestHub(t, 2, 1)
+	if err := st.UpsertSubscription("https://reader1.example.org/cb", testTopic, "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpsertSubscription("https://reader2.example.org/cb", testTopic, "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		callback string
+		topic    string
+		want     error
+	}{
+		{"topic full", "https://reader3.example.org/cb", testTopic, ErrTooManySubscriptions},
+		{"callback full", "https://reader1.example.org/cb", testTopic + ".json", ErrTooManySubscriptions},
+		{"renewal", "https://reader1.example.org/cb", testTopic, nil},
+		{"other topic", "https://reader3.example.org/cb", testTopic + ".json", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := hub.checkLimits(tt.callback, tt.topic)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("checkLimits = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	err := hub.Request(WebSubRequest{Mode: WebSubSubscribe, Callback: "https://reader3.example.org/cb", Topic: testTopic})
+	if !errors.Is(err, ErrTooManySubscriptions) {
+		t.Errorf("Request past the limit = %v", err)
+	}
+}
+
+func TestWebSubPrivateCallback(t *testing.T) {
+	_, st := newTestHub(t, 0, 0)
+	hub := NewWebSubHub(st, false, time.Hour, 0, 0, 0, 0)
+	hub.SetRenderer(func(string) (*RenderedFeed, error) { return &RenderedFeed{}, nil })
+	sub := newTestSubscriber(t, false)
+
+	if err := hub.Request(WebSubRequest{Mode: WebSubSubscribe, Callback: sub.URL, Topic: testTopic}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sub.params:
+		t.Error("hub connected to a loopback callback")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if subs, _ := st.GetActiveSubscriptions(); len(subs) != 0 {
+		t.Errorf("stored %d subscriptions to a loopback callback", len(subs))
+	}
+}
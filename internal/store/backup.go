@@ -0,0 +1,178 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrBackupUnsupported is returned by stores that cannot back themselves up
+var ErrBackupUnsupported = errors.New("backups are only supported for SQLite; back up PostgreSQL with pg_dump")
+
+// Backup writes a consistent copy of the database to path, which must not
+// exist. It reads from a single transaction, so it runs while the server
+// keeps writing, and the copy is compacted and needs no WAL file. Only the
+// owner can read the copy.
+func (s *SQLiteStore) Backup(path string) error {
+	// VACUUM INTO keeps the mode of an empty file it is given
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	_, err = s.exec("VACUUM INTO ?", path)
+	return err
+}
+
+// Backup is not supported on PostgreSQL
+func (s *PostgresStore) Backup(path string) error {
+	return ErrBackupUnsupported
+}
+
+// VerifyBackup checks that the SQLite file at path is intact and that its
+// schema is one this build can open
+func VerifyBackup(path string) error {
+	return verifyBackup(path, path)
+}
+
+// verifyBackup verifies the file at path, naming it name in errors
+func verifyBackup(path, name string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	s := newSQLiteStore(db, path)
+
+	rows, err := s.query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("%s is not a readable database: %w", name, err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%s is not a readable database: %w", name, err)
+	}
+	if len(problems) > 0 {
+		if len(problems) > 5 {
+			problems = append(problems[:5], fmt.Sprintf("and %d more", len(problems)-5))
+		}
+		return fmt.Errorf("%s failed the integrity check: %s", name, strings.Join(problems, "; "))
+	}
+
+	if _, err := s.MigrationStatus(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// RestoreSQLite replaces the database file at dst with the backup at src,
+// once a copy of it passes VerifyBackup, and returns the path the replaced
+// database was moved to, or "" if there was none. The server must not be
+// running; see checkNotInUse for what is detected.
+func RestoreSQLite(src, dst string) (string, error) {
+	if err := checkNotInUse(dst); err != nil {
+		return "", err
+	}
+
+	tmp := dst + ".restore"
+	os.Remove(tmp)
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := verifyBackup(tmp, src); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	// The replaced database is kept, with its WAL so no write is lost
+	kept := ""
+	if _, err := os.Stat(dst); err == nil {
+		kept = dst + ".before-restore-" + time.Now().Format("20060102-150405")
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			err := os.Rename(dst+suffix, kept+suffix)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return "", err
+			}
+		}
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		return kept, err
+	}
+	return kept, nil
+}
+
+// checkNotInUse fails if the database at path is open elsewhere. A
+// connection in WAL mode keeps the -wal and -shm files, and a write in
+// progress holds a lock or leaves a -journal file. An idle connection in
+// rollback journal mode cannot be detected.
+func checkNotInUse(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if _, err := os.Stat(path + suffix); err == nil {
+			return fmt.Errorf("%s exists: the database is in use, or was not closed cleanly; stop the server, or run it with -migrate-status once to recover the database", path+suffix)
+		}
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, query := range []string{"PRAGMA busy_timeout = 0", "BEGIN EXCLUSIVE"} {
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("%s is in use, stop the server first: %w", path, err)
+		}
+	}
+	_, err = db.Exec("ROLLBACK")
+	return err
+}
+
+// copyFile copies src to dst and flushes it to disk. Only the owner can
+// read the copy.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// copyTables are the tables CopyToPostgres copies. The search index of
+// SQLite is left out: PostgreSQL indexes the articles table itself.
+var copyTables = []string{
+	"accounts",
+	"channels",
+	"articles",
+	"article_revisions",
+	"channel_groups",
+	"channel_group_members",
+	"channel_filters",
+	"feed_tokens",
+	"websub_subscriptions",
+}
+
+// CopyToPostgres copies every row of src into dst, keeping ids. Both
+// schemas must be up to date and dst must be empty. Each table is copied
+// in one transaction; progress, if not nil, is called after each table
+// with the number of rows copied.
+func CopyToPostgres(dst *PostgresStore, src *SQLiteStore, progress func(table string, rows int64)) error {
+	for _, s := range []Store{src, dst} {
+		pending, err := s.PendingMigrations()
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%s has %d pending migrations; run the server with -migrate first", s, len(pending))
+		}
+	}
+
+	for _, table := range copyTables {
+		var n int64
+		if err := dst.queryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("table %s of %s is not empty", table, dst)
+		}
+	}
+
+	for _, table := range copyTables {
+		n, err := copyTable(dst, src, table)
+		if err != nil {
+			return fmt.Errorf("copying %s: %w", table, err)
+		}
+		if progress != nil {
+			progress(table, n)
+		}
+	}
+	return nil
+}
+
+func copyTable(dst *PostgresStore, src *SQLiteStore, table string) (int64, error) {
+	rows, err := src.query("SELECT * FROM " + table)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := dst.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	stmt, err := tx.Prepare(rebindPostgres("INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES (" + placeholders + ")"))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	values := make([]interface{}, len(columns))
+	dests := make([]interface{}, len(columns))
+	for i := range values {
+		dests[i] = &values[i]
+	}
+
+	var n int64
+	for rows.Next() {
+		if err := rows.Scan(dests...); err != nil {
+			return n, err
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+
+	// New rows must get ids after the copied ones
+	for _, col := range columns {
+		if col == "id" {
+			_, err := tx.Exec("SELECT setval(pg_get_serial_sequence('" + table + "', 'id'), MAX(id)) FROM " + table)
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, tx.Commit()
+}
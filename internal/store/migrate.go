@@ -0,0 +1,180 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema changes are numbered SQL files in migrations/sqlite and
+// migrations/postgres, applied in order and recorded in schema_migrations.
+// Applied files must never be edited: add a new file instead, as their
+// checksums are verified on every start.
+//
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// migrationDir returns the migrations of one backend
+func migrationDir(name string) fs.FS {
+	dir, err := fs.Sub(migrationFiles, "migrations/"+name)
+	if err != nil {
+		panic(err)
+	}
+	return dir
+}
+
+// Migration is one schema change
+type Migration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt time.Time // zero while pending
+	sql       string
+}
+
+// loadMigrations returns the embedded migrations ordered by version
+func loadMigrations(dir fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(dir, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		file := entry.Name()
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_description.sql", file)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, file, version)
+		}
+		seen[version] = file
+
+		content, err := fs.ReadFile(dir, file)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(content)
+		migrations = append(migrations, Migration{
+			Version:  version,
+			Name:     name,
+			Checksum: hex.EncodeToString(sum[:]),
+			sql:      string(content),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// MigrationStatus returns every known migration, with AppliedAt set on
+// those already applied. It fails if an applied migration was changed or
+// is unknown to this build.
+func (s *sqlStore) MigrationStatus() ([]Migration, error) {
+	_, err := s.exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TEXT
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := loadMigrations(s.migrations)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for i := range migrations {
+		byVersion[migrations[i].Version] = &migrations[i]
+	}
+
+	rows, err := s.query("SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var name, checksum string
+		var appliedAt sql.NullString
+		if err := rows.Scan(&version, &name, &checksum, &appliedAt); err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("database has migration %d (%s), which this build does not know; is it older than the database?", version, name)
+		}
+		if m.Checksum != checksum {
+			return nil, fmt.Errorf("migration %d (%s) was modified after it was applied", version, name)
+		}
+		m.AppliedAt = parseTime(appliedAt.String)
+	}
+	return migrations, rows.Err()
+}
+
+// PendingMigrations returns the migrations not yet applied
+func (s *sqlStore) PendingMigrations() ([]Migration, error) {
+	migrations, err := s.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.AppliedAt.IsZero() {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies the pending migrations in order, each in its own
+// transaction, and returns the ones it applied
+func (s *sqlStore) Migrate() ([]Migration, error) {
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range pending {
+		if err := s.applyMigration(m); err != nil {
+			return pending[:i], fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+func (s *sqlStore) applyMigration(m Migration) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return err
+	}
+	_, err = tx.exec("INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+		m.Version, m.Name, m.Checksum, nowUTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,105 @@
+-- The schema of SQLite migrations 0001 to 0007. Columns keep their SQLite
+-- types: timestamps are text in the same layouts, so both backends compare
+-- and sort them alike and data can be copied between them unchanged.
+-- Foreign keys are left out, as SQLite never enforced them.
+CREATE TABLE IF NOT EXISTS accounts (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	cookie TEXT,
+	token TEXT,
+	available INTEGER DEFAULT 1,
+	need_check INTEGER DEFAULT 0,
+	wait_time TEXT,
+	created_at TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS'),
+	updated_at TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')
+);
+
+CREATE TABLE IF NOT EXISTS channels (
+	id BIGSERIAL PRIMARY KEY,
+	biz_id TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL,
+	description TEXT,
+	avatar TEXT,
+	link TEXT,
+	account_id BIGINT,
+	last_update TEXT,
+	article_count INTEGER DEFAULT 0,
+	status TEXT DEFAULT 'active',
+	created_at TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')
+);
+
+CREATE TABLE IF NOT EXISTS articles (
+	id BIGSERIAL PRIMARY KEY,
+	biz_id TEXT NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT,
+	content TEXT,
+	link TEXT NOT NULL UNIQUE,
+	cover TEXT,
+	created_at TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS'),
+	published_at TEXT,
+	starred INTEGER NOT NULL DEFAULT 0,
+	pinned INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_articles_biz_id ON articles(biz_id);
+CREATE INDEX IF NOT EXISTS idx_articles_published ON articles(published_at);
+CREATE INDEX IF NOT EXISTS idx_articles_biz_published ON articles(biz_id, published_at);
+CREATE INDEX IF NOT EXISTS idx_channels_status ON channels(status);
+
+CREATE TABLE IF NOT EXISTS websub_subscriptions (
+	id BIGSERIAL PRIMARY KEY,
+	callback TEXT NOT NULL,
+	topic TEXT NOT NULL,
+	secret TEXT,
+	lease_seconds BIGINT NOT NULL,
+	expires_at TEXT NOT NULL,
+	created_at TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS'),
+	UNIQUE (callback, topic)
+);
+
+CREATE TABLE IF NOT EXISTS channel_groups (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	slug TEXT UNIQUE,
+	description TEXT,
+	created_at TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')
+);
+
+CREATE TABLE IF NOT EXISTS channel_group_members (
+	group_id BIGINT NOT NULL,
+	biz_id TEXT NOT NULL,
+	PRIMARY KEY (group_id, biz_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_members_biz_id ON channel_group_members(biz_id);
+
+CREATE TABLE IF NOT EXISTS channel_filters (
+	biz_id TEXT PRIMARY KEY,
+	rules TEXT NOT NULL,
+	updated_at TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')
+);
+
+CREATE TABLE IF NOT EXISTS feed_tokens (
+	id BIGSERIAL PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	label TEXT,
+	scope TEXT NOT NULL,
+	target TEXT,
+	expires_at TEXT,
+	last_used_at TEXT,
+	revoked_at TEXT,
+	created_at TEXT DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')
+);
+
+-- Search matches substrings with ILIKE, sped up by trigram indexes over
+-- the title, the digest and the text of the content
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE OR REPLACE FUNCTION strip_html(html TEXT) RETURNS TEXT
+LANGUAGE sql IMMUTABLE PARALLEL SAFE
+AS $$ SELECT regexp_replace(html, '<[^>]*>', ' ', 'g') $$;
+
+CREATE INDEX IF NOT EXISTS idx_articles_title_trgm ON articles USING gin (title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_articles_description_trgm ON articles USING gin (description gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_articles_content_trgm ON articles USING gin (strip_html(content) gin_trgm_ops);
@@ -0,0 +1,19 @@
+-- See SQLite migration 0008
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS content_hash TEXT;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published';
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS updated_at TEXT;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS checked_at TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_articles_status_published ON articles(status, published_at);
+
+CREATE TABLE IF NOT EXISTS article_revisions (
+	id BIGSERIAL PRIMARY KEY,
+	article_id BIGINT NOT NULL,
+	revision INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT,
+	content TEXT,
+	content_hash TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	UNIQUE (article_id, revision)
+);
@@ -0,0 +1,3 @@
+-- See SQLite migration 0009
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS author TEXT;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS source_url TEXT;
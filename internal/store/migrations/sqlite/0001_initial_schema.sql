@@ -0,0 +1,44 @@
+-- Accounts, channels and articles
+CREATE TABLE IF NOT EXISTS accounts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	cookie TEXT,
+	token TEXT,
+	available INTEGER DEFAULT 1,
+	need_check INTEGER DEFAULT 0,
+	wait_time TEXT,
+	created_at TEXT DEFAULT (datetime('now')),
+	updated_at TEXT DEFAULT (datetime('now'))
+);
+
+CREATE TABLE IF NOT EXISTS channels (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	biz_id TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL,
+	description TEXT,
+	avatar TEXT,
+	link TEXT,
+	account_id INTEGER,
+	last_update TEXT,
+	article_count INTEGER DEFAULT 0,
+	status TEXT DEFAULT 'active',
+	created_at TEXT DEFAULT (datetime('now')),
+	FOREIGN KEY (account_id) REFERENCES accounts(id)
+);
+
+CREATE TABLE IF NOT EXISTS articles (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	biz_id TEXT NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT,
+	content TEXT,
+	link TEXT NOT NULL UNIQUE,
+	cover TEXT,
+	created_at TEXT DEFAULT (datetime('now')),
+	published_at TEXT,
+	FOREIGN KEY (biz_id) REFERENCES channels(biz_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_articles_biz_id ON articles(biz_id);
+CREATE INDEX IF NOT EXISTS idx_articles_published ON articles(published_at);
+CREATE INDEX IF NOT EXISTS idx_channels_status ON channels(status);
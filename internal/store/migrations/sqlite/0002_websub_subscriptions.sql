@@ -0,0 +1,11 @@
+-- WebSub hub subscriptions
+CREATE TABLE IF NOT EXISTS websub_subscriptions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	callback TEXT NOT NULL,
+	topic TEXT NOT NULL,
+	secret TEXT,
+	lease_seconds INTEGER NOT NULL,
+	expires_at TEXT NOT NULL,
+	created_at TEXT DEFAULT (datetime('now')),
+	UNIQUE (callback, topic)
+);
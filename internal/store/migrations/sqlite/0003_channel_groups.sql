@@ -0,0 +1,17 @@
+-- Channel groups and their members
+CREATE TABLE IF NOT EXISTS channel_groups (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	slug TEXT UNIQUE,
+	description TEXT,
+	created_at TEXT DEFAULT (datetime('now'))
+);
+
+CREATE TABLE IF NOT EXISTS channel_group_members (
+	group_id INTEGER NOT NULL,
+	biz_id TEXT NOT NULL,
+	PRIMARY KEY (group_id, biz_id),
+	FOREIGN KEY (group_id) REFERENCES channel_groups(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_members_biz_id ON channel_group_members(biz_id);
@@ -0,0 +1,6 @@
+-- Saved per-channel filter rules, stored as JSON
+CREATE TABLE IF NOT EXISTS channel_filters (
+	biz_id TEXT PRIMARY KEY,
+	rules TEXT NOT NULL,
+	updated_at TEXT DEFAULT (datetime('now'))
+);
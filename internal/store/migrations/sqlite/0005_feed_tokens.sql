@@ -0,0 +1,12 @@
+-- Feed access tokens; only the SHA-256 of each token is stored
+CREATE TABLE IF NOT EXISTS feed_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	token_hash TEXT NOT NULL UNIQUE,
+	label TEXT,
+	scope TEXT NOT NULL,
+	target TEXT,
+	expires_at TEXT,
+	last_used_at TEXT,
+	revoked_at TEXT,
+	created_at TEXT DEFAULT (datetime('now'))
+);
@@ -0,0 +1,31 @@
+-- Full-text index over articles. The trigram tokenizer matches any
+-- substring of at least three characters, which suits Chinese text that
+-- has no word separators. Content is indexed as plain text, stripped of
+-- markup by the strip_html function the store registers.
+CREATE VIRTUAL TABLE IF NOT EXISTS articles_fts USING fts5(
+	title,
+	description,
+	content,
+	tokenize = 'trigram'
+);
+
+-- Rank title matches highest, then the digest
+INSERT INTO articles_fts (articles_fts, rank) VALUES ('rank', 'bm25(10.0, 4.0, 1.0)');
+
+INSERT INTO articles_fts (rowid, title, description, content)
+SELECT id, title, description, strip_html(content) FROM articles;
+
+CREATE TRIGGER IF NOT EXISTS articles_fts_insert AFTER INSERT ON articles BEGIN
+	INSERT INTO articles_fts (rowid, title, description, content)
+	VALUES (new.id, new.title, new.description, strip_html(new.content));
+END;
+
+CREATE TRIGGER IF NOT EXISTS articles_fts_delete AFTER DELETE ON articles BEGIN
+	DELETE FROM articles_fts WHERE rowid = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS articles_fts_update AFTER UPDATE OF title, description, content ON articles BEGIN
+	DELETE FROM articles_fts WHERE rowid = old.id;
+	INSERT INTO articles_fts (rowid, title, description, content)
+	VALUES (new.id, new.title, new.description, strip_html(new.content));
+END;
@@ -0,0 +1,5 @@
+-- Starred and pinned articles are exempt from retention
+ALTER TABLE articles ADD COLUMN starred INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE articles ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_articles_biz_published ON articles(biz_id, published_at);
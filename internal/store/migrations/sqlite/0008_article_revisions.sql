@@ -0,0 +1,22 @@
+-- Articles are re-checked after publication. content_hash identifies the
+-- saved version, status records whether the author deleted the article or
+-- WeChat took it down, and versions replaced by an edit are kept in
+-- article_revisions.
+ALTER TABLE articles ADD COLUMN content_hash TEXT;
+ALTER TABLE articles ADD COLUMN status TEXT NOT NULL DEFAULT 'published';
+ALTER TABLE articles ADD COLUMN updated_at TEXT;
+ALTER TABLE articles ADD COLUMN checked_at TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_articles_status_published ON articles(status, published_at);
+
+CREATE TABLE IF NOT EXISTS article_revisions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	article_id INTEGER NOT NULL,
+	revision INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT,
+	content TEXT,
+	content_hash TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	UNIQUE (article_id, revision)
+);
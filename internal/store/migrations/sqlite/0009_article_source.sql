@@ -0,0 +1,4 @@
+-- The byline of an article and the target of its "阅读原文" link, both
+-- read from the article page
+ALTER TABLE articles ADD COLUMN author TEXT;
+ALTER TABLE articles ADD COLUMN source_url TEXT;
@@ -0,0 +1,6 @@
+-- The store now writes the plain text of articles into articles_fts
+-- itself, so the schema no longer depends on the strip_html function the
+-- process registers. Rows indexed by the triggers stay as they are.
+DROP TRIGGER IF EXISTS articles_fts_insert;
+DROP TRIGGER IF EXISTS articles_fts_delete;
+DROP TRIGGER IF EXISTS articles_fts_update;
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"wechatoarss/internal/model"
+)
+
+// PostgresStore keeps the data in PostgreSQL, for deployments sharing a
+// database server. Its schema needs the pg_trgm extension.
+type PostgresStore struct {
+	*sqlStore
+	name string
+}
+
+// OpenPostgres connects to the database at dsn, a URL or a key=value
+// connection string, without touching its schema
+func OpenPostgres(dsn string) (*PostgresStore, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("database.dsn is required for the postgres driver")
+	}
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresStore{
+		sqlStore: &sqlStore{
+			db: db,
+			dialect: dialect{
+				rebind: rebindPostgres,
+				like:   "ILIKE",
+				// strip_html from the schema, which the trigram index covers
+				contentText: "strip_html(content)",
+			},
+			migrations: migrationDir("postgres"),
+		},
+		name: fmt.Sprintf("postgres://%s:%d/%s", cfg.Host, cfg.Port, cfg.Database),
+	}, nil
+}
+
+func (s *PostgresStore) String() string {
+	return s.name
+}
+
+// rebindPostgres numbers the ? placeholders of query as $1, $2...,
+// leaving quoted strings alone
+func rebindPostgres(query string) string {
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// SearchArticles returns a page of the articles matching q and their
+// number. Every term must appear in the title, the digest or the text of
+// the content; matches are ranked by where they appear, as on SQLite.
+func (s *PostgresStore) SearchArticles(q SearchQuery) ([]model.SearchHit, int, error) {
+	terms := strings.Fields(q.Query)
+	if len(terms) == 0 {
+		return nil, 0, ErrEmptySearch
+	}
+
+	// ILIKE escapes with a backslash by default, as likeEscaper expects
+	var conds, scores []string
+	var args, scoreArgs []interface{}
+	for _, term := range terms {
+		pattern := "%" + likeEscaper.Replace(term) + "%"
+		conds = append(conds, "(a.title ILIKE ? OR a.description ILIKE ? OR strip_html(a.content) ILIKE ?)")
+		args = append(args, pattern, pattern, pattern)
+		scores = append(scores, `CASE WHEN a.title ILIKE ? THEN 10 ELSE 0 END +
+			CASE WHEN a.description ILIKE ? THEN 4 ELSE 0 END +
+			CASE WHEN strip_html(a.content) ILIKE ? THEN 1 ELSE 0 END`)
+		scoreArgs = append(scoreArgs, pattern, pattern, pattern)
+	}
+	filterConds, filterArgs := searchFilters(q)
+	conds = append(conds, filterConds...)
+	args = append(args, filterArgs...)
+	from := " FROM articles a WHERE " + strings.Join(conds, " AND ")
+
+	order := "score DESC, a.published_at DESC"
+	if q.Sort == "date" {
+		order = "a.published_at DESC"
+	}
+
+	page, size := q.Page, q.Size
+	if page < 1 {
+		page = 1
+	}
+
+	queryArgs := append(append(scoreArgs, args...), size, (page-1)*size)
+	rows, err := s.query(`
+		SELECT `+strings.Join(scores, " + ")+` AS score,
+			a.id, a.biz_id, a.title, COALESCE(a.description, ''), COALESCE(a.content, ''), a.link, COALESCE(a.cover, ''), a.created_at, a.published_at`+
+		from+" ORDER BY "+order+" LIMIT ? OFFSET ?", queryArgs...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	hits := []model.SearchHit{}
+	for rows.Next() {
+		var hit model.SearchHit
+		var content string
+		var createdAt, publishedAt sql.NullString
+		err := rows.Scan(&hit.Score, &hit.ID, &hit.BizID, &hit.Title, &hit.Description, &content,
+			&hit.Link, &hit.Cover, &createdAt, &publishedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		if createdAt.Valid {
+			hit.CreatedAt = parseTime(createdAt.String)
+		}
+		if publishedAt.Valid {
+			hit.PublishedAt = parseTime(publishedAt.String)
+		}
+
+		hit.TitleHTML = markedHTML(markTerms(hit.Title, terms))
+		hit.Snippet = markedHTML(buildSnippet(hit.Description+"\n"+StripHTML(content), terms))
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := s.queryRow("SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	return hits, total, nil
+}
+
+// Vacuum reclaims the space of deleted rows and returns the number of
+// bytes the database shrank by. PostgreSQL reuses most of that space for
+// new rows rather than returning it to the file system.
+func (s *PostgresStore) Vacuum() (int64, error) {
+	var before, after int64
+	if err := s.queryRow("SELECT pg_database_size(current_database())").Scan(&before); err != nil {
+		return 0, err
+	}
+	if _, err := s.exec("VACUUM"); err != nil {
+		return 0, err
+	}
+	if err := s.queryRow("SELECT pg_database_size(current_database())").Scan(&after); err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// Analyze refreshes the query planner statistics
+func (s *PostgresStore) Analyze() error {
+	_, err := s.exec("ANALYZE")
+	return err
+}
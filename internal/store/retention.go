@@ -0,0 +1,116 @@
+package store
+
+import (
+	"time"
+
+	"wechatoarss/internal/model"
+)
+
+// PruneArticlesByAge deletes the articles of bizID published before
+// cutoff, except starred, pinned, deleted and violated ones, and returns
+// how many it deleted.
+// With dryRun set it only counts them.
+func (s *sqlStore) PruneArticlesByAge(bizID string, cutoff time.Time, dryRun bool) (int64, error) {
+	where := " FROM articles WHERE biz_id = ? AND starred = 0 AND pinned = 0 AND status = ? AND published_at < ?"
+	args := []interface{}{bizID, model.ArticleStatusPublished, cutoff}
+	return s.pruneArticles(where, args, dryRun)
+}
+
+// PruneArticlesByCount deletes the articles of bizID beyond the newest
+// keep, except starred, pinned, deleted and violated ones, and returns how
+// many it deleted. Unless cutoff is zero, the articles PruneArticlesByAge
+// deletes for cutoff are left out, both of the count and of the newest
+// keep, so a dry run of both counts each article once and agrees with a
+// real run, where they are already gone.
+// With dryRun set it only counts them.
+func (s *sqlStore) PruneArticlesByCount(bizID string, keep int, cutoff time.Time, dryRun bool) (int64, error) {
+	where := " FROM articles WHERE biz_id = ? AND starred = 0 AND pinned = 0 AND status = ?"
+	args := []interface{}{bizID, model.ArticleStatusPublished}
+	newest := "SELECT id FROM articles WHERE biz_id = ?"
+	newestArgs := []interface{}{bizID}
+	if !cutoff.IsZero() {
+		where += " AND published_at >= ?"
+		args = append(args, cutoff)
+		newest += " AND (starred <> 0 OR pinned <> 0 OR status <> ? OR published_at >= ?)"
+		newestArgs = append(newestArgs, model.ArticleStatusPublished, cutoff)
+	}
+	where += " AND id NOT IN (" + newest + " ORDER BY published_at DESC, id DESC LIMIT ?)"
+	args = append(append(args, newestArgs...), keep)
+	return s.pruneArticles(where, args, dryRun)
+}
+
+func (s *sqlStore) pruneArticles(where string, args []interface{}, dryRun bool) (int64, error) {
+	if dryRun {
+		var n int64
+		err := s.queryRow("SELECT COUNT(*)"+where, args...).Scan(&n)
+		return n, err
+	}
+
+	result, err := s.exec("DELETE"+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return n, err
+	}
+
+	// Revisions of the deleted articles go with them
+	if _, err = s.exec("DELETE FROM article_revisions WHERE article_id NOT IN (SELECT id FROM articles)"); err != nil {
+		return n, err
+	}
+	return n, s.unindexDeletedArticles(s)
+}
+
+// Vacuum returns the free pages of the database file to the file system
+// and returns the number of bytes freed. Databases created without
+// incremental auto-vacuum are converted by one full VACUUM, which needs
+// free disk space about the size of the database.
+func (s *SQLiteStore) Vacuum() (int64, error) {
+	before, err := s.databaseSize()
+	if err != nil {
+		return 0, err
+	}
+
+	var mode int
+	if err := s.queryRow("PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return 0, err
+	}
+	if mode == 2 {
+		_, err = s.exec("PRAGMA incremental_vacuum")
+	} else {
+		if _, err = s.exec("PRAGMA auto_vacuum = INCREMENTAL"); err == nil {
+			_, err = s.exec("VACUUM")
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	after, err := s.databaseSize()
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// Analyze refreshes the query planner statistics and merges the search
+// index segments left behind by deletions
+func (s *SQLiteStore) Analyze() error {
+	if _, err := s.exec("ANALYZE"); err != nil {
+		return err
+	}
+	_, err := s.exec("INSERT INTO articles_fts (articles_fts) VALUES ('optimize')")
+	return err
+}
+
+func (s *SQLiteStore) databaseSize() (int64, error) {
+	var pages, pageSize int64
+	if err := s.queryRow("PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := s.queryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pages * pageSize, nil
+}
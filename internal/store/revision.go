@@ -0,0 +1,219 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"wechatoarss/internal/model"
+)
+
+// ContentHash identifies a version of an article by its title and the
+// text of its content. Markup is left out, so the tracking parameters
+// WeChat varies in image URLs do not count as edits.
+func ContentHash(title, content string) string {
+	h := sha256.New()
+	h.Write([]byte(strings.Join(strings.Fields(title), " ")))
+	for _, line := range ContentLines(content) {
+		h.Write([]byte{'\n'})
+		h.Write([]byte(line))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ContentLines returns the non-empty lines of the text of content, with
+// their whitespace collapsed. Revisions are compared line by line.
+func ContentLines(content string) []string {
+	var lines []string
+	for _, line := range strings.Split(StripHTML(content), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// GetArticlesToRecheck returns up to limit published articles, published
+// since since and not checked after checkedBefore, least recently checked
+// first. Their content is left out.
+func (s *sqlStore) GetArticlesToRecheck(since, checkedBefore time.Time, limit int) ([]model.Article, error) {
+	rows, err := s.query(`
+		SELECT id, biz_id, title, link, published_at, content_hash,
+			CASE WHEN content_hash IS NULL THEN content ELSE '' END
+		FROM articles
+		WHERE status = ? AND published_at >= ? AND (checked_at IS NULL OR checked_at < ?)
+		ORDER BY COALESCE(checked_at, ''), published_at DESC
+		LIMIT ?
+	`, model.ArticleStatusPublished, since, checkedBefore.UTC().Format("2006-01-02 15:04:05"), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var articles []model.Article
+	for rows.Next() {
+		var a model.Article
+		var publishedAt, hash, content sql.NullString
+		if err := rows.Scan(&a.ID, &a.BizID, &a.Title, &a.Link, &publishedAt, &hash, &content); err != nil {
+			return nil, err
+		}
+		if publishedAt.Valid {
+			a.PublishedAt = parseTime(publishedAt.String)
+		}
+		a.ContentHash = hash.String
+		if !hash.Valid {
+			// Saved before content hashes were recorded
+			a.ContentHash = ContentHash(a.Title, content.String)
+		}
+		a.Status = model.ArticleStatusPublished
+		articles = append(articles, a)
+	}
+	return articles, rows.Err()
+}
+
+// MarkArticleChecked records that an article was re-checked and found in
+// status
+func (s *sqlStore) MarkArticleChecked(id int64, status string) error {
+	_, err := s.exec("UPDATE articles SET status = ?, checked_at = ? WHERE id = ?", status, nowUTC(), id)
+	return err
+}
+
+// AddArticleRevision replaces the title and content of an article with an
+// edited version, keeping the replaced version as a revision, and returns
+// the number of the new revision. Content equal to the saved version is
+// not recorded.
+func (s *sqlStore) AddArticleRevision(id int64, title, content string) (int, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var old model.ArticleRevision
+	var description, oldContent, hash sql.NullString
+	var savedAt string
+	err = tx.queryRow(`
+		SELECT title, description, content, content_hash, COALESCE(updated_at, created_at)
+		FROM articles WHERE id = ?
+	`, id).Scan(&old.Title, &description, &oldContent, &hash, &savedAt)
+	if err != nil {
+		return 0, err
+	}
+	old.ContentHash = hash.String
+	if old.ContentHash == "" {
+		old.ContentHash = ContentHash(old.Title, oldContent.String)
+	}
+
+	var last int
+	if err := tx.queryRow("SELECT COALESCE(MAX(revision), 0) FROM article_revisions WHERE article_id = ?", id).Scan(&last); err != nil {
+		return 0, err
+	}
+
+	newHash := ContentHash(title, content)
+	if newHash == old.ContentHash {
+		return last + 1, nil
+	}
+
+	_, err = tx.exec(`
+		INSERT INTO article_revisions (article_id, revision, title, description, content, content_hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, id, last+1, old.Title, description, oldContent, old.ContentHash, savedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	now := nowUTC()
+	_, err = tx.exec(`
+		UPDATE articles SET title = ?, content = ?, content_hash = ?, updated_at = ?, checked_at = ?
+		WHERE id = ?
+	`, title, content, newHash, now, now, id)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.indexArticle(tx, id, title, description.String, content); err != nil {
+		return 0, err
+	}
+	return last + 2, tx.Commit()
+}
+
+// GetArticleRevisions returns the revisions of an article without their
+// content, oldest first. The last one is the current version.
+func (s *sqlStore) GetArticleRevisions(id int64) ([]model.ArticleRevision, error) {
+	current, err := s.currentRevision(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.query(`
+		SELECT revision, title, COALESCE(description, ''), content_hash, created_at
+		FROM article_revisions WHERE article_id = ? ORDER BY revision
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []model.ArticleRevision
+	for rows.Next() {
+		var r model.ArticleRevision
+		var createdAt string
+		if err := rows.Scan(&r.Revision, &r.Title, &r.Description, &r.ContentHash, &createdAt); err != nil {
+			return nil, err
+		}
+		r.CreatedAt = parseTime(createdAt)
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	current.Content = ""
+	return append(revisions, *current), nil
+}
+
+// GetArticleRevision returns a revision of an article with its content
+func (s *sqlStore) GetArticleRevision(id int64, revision int) (*model.ArticleRevision, error) {
+	current, err := s.currentRevision(id)
+	if err != nil {
+		return nil, err
+	}
+	if revision == current.Revision {
+		return current, nil
+	}
+
+	r := model.ArticleRevision{Revision: revision}
+	var createdAt string
+	err = s.queryRow(`
+		SELECT title, COALESCE(description, ''), COALESCE(content, ''), content_hash, created_at
+		FROM article_revisions WHERE article_id = ? AND revision = ?
+	`, id, revision).Scan(&r.Title, &r.Description, &r.Content, &r.ContentHash, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	r.CreatedAt = parseTime(createdAt)
+	return &r, nil
+}
+
+// currentRevision returns the saved version of an article as its latest
+// revision
+func (s *sqlStore) currentRevision(id int64) (*model.ArticleRevision, error) {
+	r := model.ArticleRevision{Current: true}
+	var hash sql.NullString
+	var savedAt string
+	err := s.queryRow(`
+		SELECT title, COALESCE(description, ''), COALESCE(content, ''), content_hash, COALESCE(updated_at, created_at),
+			(SELECT COALESCE(MAX(revision), 0) FROM article_revisions WHERE article_id = articles.id) + 1
+		FROM articles WHERE id = ?
+	`, id).Scan(&r.Title, &r.Description, &r.Content, &hash, &savedAt, &r.Revision)
+	if err != nil {
+		return nil, err
+	}
+	r.ContentHash = hash.String
+	if r.ContentHash == "" {
+		r.ContentHash = ContentHash(r.Title, r.Content)
+	}
+	r.CreatedAt = parseTime(savedAt)
+	return &r, nil
+}
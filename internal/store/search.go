@@ -0,0 +1,387 @@
+package store
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	sqlite "github.com/glebarez/go-sqlite"
+	nethtml "golang.org/x/net/html"
+
+	"wechatoarss/internal/model"
+)
+
+// ErrEmptySearch is returned for searches without any term
+var ErrEmptySearch = errors.New("search query is empty")
+
+// Highlight markers around matches in titles and snippets, replaced by
+// <mark> tags once the text around them is escaped
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// snippetRunes is the number of characters shown on each side of the
+// first match in snippets built without the index
+const snippetRunes = 32
+
+func init() {
+	// Migration 0006 indexed the existing articles through strip_html.
+	// Since 0010 the store indexes articles itself and the schema does not
+	// use the function, but that migration still needs it to run.
+	sqlite.MustRegisterDeterministicScalarFunction("strip_html", 1, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		switch v := args[0].(type) {
+		case string:
+			return StripHTML(v), nil
+		case []byte:
+			return StripHTML(string(v)), nil
+		}
+		return args[0], nil
+	})
+}
+
+// blockElements separate text with a newline when stripped. Inline
+// elements add nothing, as WeChat often splits words over several spans.
+var blockElements = map[string]bool{
+	"p": true, "div": true, "section": true, "br": true, "li": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "pre": true, "tr": true, "td": true, "th": true,
+	"img": true, "figure": true, "figcaption": true,
+}
+
+// StripHTML returns the visible text of an HTML fragment
+func StripHTML(s string) string {
+	var b strings.Builder
+	z := nethtml.NewTokenizer(strings.NewReader(s))
+	skip := 0
+	newline := func() {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteByte('\n')
+		}
+	}
+
+	for {
+		switch z.Next() {
+		case nethtml.ErrorToken:
+			return strings.TrimSpace(b.String())
+		case nethtml.StartTagToken, nethtml.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch tag := string(name); {
+			case tag == "script" || tag == "style":
+				skip++
+			case blockElements[tag]:
+				newline()
+			}
+		case nethtml.EndTagToken:
+			name, _ := z.TagName()
+			switch tag := string(name); {
+			case (tag == "script" || tag == "style") && skip > 0:
+				skip--
+			case blockElements[tag]:
+				newline()
+			}
+		case nethtml.TextToken:
+			if skip == 0 {
+				b.WriteString(strings.Join(strings.Fields(string(z.Text())), " "))
+			}
+		}
+	}
+}
+
+// indexArticle writes the plain text of an article into the search index,
+// replacing what was indexed for it before
+func (s *sqlStore) indexArticle(ex execer, id int64, title, description, content string) error {
+	if !s.searchIndex {
+		return nil
+	}
+	if _, err := ex.exec("DELETE FROM articles_fts WHERE rowid = ?", id); err != nil {
+		return err
+	}
+	_, err := ex.exec("INSERT INTO articles_fts (rowid, title, description, content) VALUES (?, ?, ?, ?)",
+		id, title, description, StripHTML(content))
+	return err
+}
+
+// unindexDeletedArticles removes deleted articles from the search index
+func (s *sqlStore) unindexDeletedArticles(ex execer) error {
+	if !s.searchIndex {
+		return nil
+	}
+	_, err := ex.exec("DELETE FROM articles_fts WHERE rowid NOT IN (SELECT id FROM articles)")
+	return err
+}
+
+// SearchQuery is a full-text search over articles
+type SearchQuery struct {
+	Query   string // terms separated by spaces, all of which must match
+	BizID   string
+	GroupID int64
+	From    string // YYYYMMDD, inclusive
+	To      string // YYYYMMDD, inclusive
+	Sort    string // "rank" (default) or "date"
+	Page    int
+	Size    int
+}
+
+// SearchArticles returns a page of the articles matching q and their
+// number. Terms of three or more characters are looked up in the trigram
+// index; shorter ones, common in Chinese, can only be matched by scanning
+// it, and searches made only of those are sorted by date.
+func (s *SQLiteStore) SearchArticles(q SearchQuery) ([]model.SearchHit, int, error) {
+	terms := strings.Fields(q.Query)
+	if len(terms) == 0 {
+		return nil, 0, ErrEmptySearch
+	}
+
+	var phrases, shortTerms []string
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) >= 3 {
+			phrases = append(phrases, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+		} else {
+			shortTerms = append(shortTerms, term)
+		}
+	}
+	indexed := len(phrases) > 0
+
+	var conds []string
+	var args []interface{}
+	if indexed {
+		conds = append(conds, "articles_fts MATCH ?")
+		args = append(args, strings.Join(phrases, " "))
+	}
+	for _, term := range shortTerms {
+		pattern := "%" + likeEscaper.Replace(term) + "%"
+		conds = append(conds, `(articles_fts.title LIKE ? ESCAPE '\' OR articles_fts.description LIKE ? ESCAPE '\' OR articles_fts.content LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern, pattern)
+	}
+	filterConds, filterArgs := searchFilters(q)
+	conds = append(conds, filterConds...)
+	args = append(args, filterArgs...)
+	where := " WHERE " + strings.Join(conds, " AND ")
+	// CROSS JOIN keeps SQLite from looking up every article of a channel
+	// in the index one by one
+	from := " FROM articles_fts CROSS JOIN articles a ON a.id = articles_fts.rowid" + where
+
+	// Searches ranked by relevance without channel or date filters only
+	// need the index, which lets FTS5 sort them itself
+	pageFrom := from
+	order := "a.published_at DESC"
+	if indexed && q.Sort != "date" {
+		order = "articles_fts.rank, a.published_at DESC"
+		if q.BizID == "" && q.GroupID == 0 && q.From == "" && q.To == "" {
+			pageFrom = " FROM articles_fts" + where
+			order = "articles_fts.rank"
+		}
+	}
+
+	page, size := q.Page, q.Size
+	if page < 1 {
+		page = 1
+	}
+
+	// Snippets are only built for the page, as SQLite would otherwise
+	// compute them for every match before sorting
+	ids, err := s.searchPage(pageFrom+" ORDER BY "+order+" LIMIT ? OFFSET ?", append(args, size, (page-1)*size))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hits := make([]model.SearchHit, 0, len(ids))
+	if len(ids) > 0 {
+		columns := "0, articles_fts.title, '', COALESCE(articles_fts.description, ''), COALESCE(articles_fts.content, '')"
+		if indexed {
+			columns = `articles_fts.rank, highlight(articles_fts, 0, char(2), char(3)),
+				COALESCE(snippet(articles_fts, -1, char(2), char(3), '…', 48), ''), '', ''`
+		}
+		pageArgs := append([]interface{}{}, args...)
+		for _, id := range ids {
+			pageArgs = append(pageArgs, id)
+		}
+
+		rows, err := s.query(`
+			SELECT `+columns+`, a.id, a.biz_id, a.title, COALESCE(a.description, ''), a.link, COALESCE(a.cover, ''), a.created_at, a.published_at`+
+			from+" AND a.id IN (?"+strings.Repeat(", ?", len(ids)-1)+")", pageArgs...)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer rows.Close()
+
+		position := make(map[int64]int, len(ids))
+		for i, id := range ids {
+			position[id] = i
+		}
+		hits = hits[:len(ids)]
+
+		for rows.Next() {
+			var hit model.SearchHit
+			var title, snippet, description, content string
+			var createdAt, publishedAt sql.NullString
+			err := rows.Scan(&hit.Score, &title, &snippet, &description, &content,
+				&hit.ID, &hit.BizID, &hit.Title, &hit.Description, &hit.Link, &hit.Cover, &createdAt, &publishedAt)
+			if err != nil {
+				return nil, 0, err
+			}
+			if createdAt.Valid {
+				hit.CreatedAt = parseTime(createdAt.String)
+			}
+			if publishedAt.Valid {
+				hit.PublishedAt = parseTime(publishedAt.String)
+			}
+
+			if !indexed {
+				title = markTerms(title, shortTerms)
+				snippet = buildSnippet(description+"\n"+content, shortTerms)
+			}
+			// bm25 is lower for better matches
+			hit.Score = -hit.Score
+			hit.TitleHTML = markedHTML(title)
+			hit.Snippet = markedHTML(snippet)
+			hits[position[hit.ID]] = hit
+		}
+		if err := rows.Err(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var total int
+	if err := s.queryRow("SELECT COUNT(*)"+pageFrom, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	return hits, total, nil
+}
+
+// searchFilters returns the conditions on the articles a of q's channel,
+// group and dates
+func searchFilters(q SearchQuery) ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if q.BizID != "" {
+		conds = append(conds, "a.biz_id = ?")
+		args = append(args, q.BizID)
+	}
+	if q.GroupID != 0 {
+		conds = append(conds, "a.biz_id IN (SELECT biz_id FROM channel_group_members WHERE group_id = ?)")
+		args = append(args, q.GroupID)
+	}
+	if q.From != "" {
+		t, _ := time.Parse("20060102", q.From)
+		conds = append(conds, "a.published_at >= ?")
+		args = append(args, t.Format("2006-01-02"))
+	}
+	if q.To != "" {
+		t, _ := time.Parse("20060102", q.To)
+		conds = append(conds, "a.published_at < ?")
+		args = append(args, t.AddDate(0, 0, 1).Format("2006-01-02"))
+	}
+	return conds, args
+}
+
+// searchPage returns the ids of the articles on a page of search results
+func (s *SQLiteStore) searchPage(query string, args []interface{}) ([]int64, error) {
+	rows, err := s.query("SELECT articles_fts.rowid"+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// markedHTML escapes s and turns its highlight markers into <mark> tags
+func markedHTML(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, markStart, "<mark>")
+	return strings.ReplaceAll(s, markEnd, "</mark>")
+}
+
+// buildSnippet returns the text around the first match of terms in text,
+// with every match marked
+func buildSnippet(text string, terms []string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	lower := lowerASCII(text)
+
+	first := -1
+	for _, term := range terms {
+		if i := strings.Index(lower, lowerASCII(term)); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	start := first
+	for n := 0; n < snippetRunes && start > 0; n++ {
+		_, w := utf8.DecodeLastRuneInString(text[:start])
+		start -= w
+	}
+	end := first
+	for n := 0; n < 2*snippetRunes && end < len(text); n++ {
+		_, w := utf8.DecodeRuneInString(text[end:])
+		end += w
+	}
+
+	snippet := markTerms(text[start:end], terms)
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(text) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// markTerms wraps every match of terms in s in highlight markers. Like
+// SQLite's LIKE, matching ignores the case of ASCII letters only.
+func markTerms(s string, terms []string) string {
+	lower := lowerASCII(s)
+	marked := make([]bool, len(s))
+	for _, term := range terms {
+		term = lowerASCII(term)
+		for i := 0; term != ""; {
+			j := strings.Index(lower[i:], term)
+			if j < 0 {
+				break
+			}
+			for k := i + j; k < i+j+len(term); k++ {
+				marked[k] = true
+			}
+			i += j + len(term)
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(markStart)
+		}
+		b.WriteByte(s[i])
+		if marked[i] && (i == len(s)-1 || !marked[i+1]) {
+			b.WriteString(markEnd)
+		}
+	}
+	return b.String()
+}
+
+// lowerASCII lowercases ASCII letters only, keeping byte offsets intact
+func lowerASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/glebarez/sqlite"
+)
+
+// SQLiteStore keeps the data in a SQLite file. It is the default store.
+type SQLiteStore struct {
+	*sqlStore
+	path string
+}
+
+// OpenSQLite opens the database file at path, creating it and its
+// directory if needed, without touching its schema
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+
+	// Set connection pool settings
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	return newSQLiteStore(db, path), nil
+}
+
+func newSQLiteStore(db *sql.DB, path string) *SQLiteStore {
+	return &SQLiteStore{
+		sqlStore: &sqlStore{
+			db: db,
+			dialect: dialect{
+				rebind: func(query string) string { return query },
+				like:   "LIKE",
+				// The search index holds the StripHTML text of every article
+				contentText: "(SELECT articles_fts.content FROM articles_fts WHERE articles_fts.rowid = articles.id)",
+			},
+			migrations:  migrationDir("sqlite"),
+			searchIndex: true,
+		},
+		path: path,
+	}
+}
+
+func (s *SQLiteStore) String() string {
+	return s.path
+}
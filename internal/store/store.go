@@ -2,142 +2,292 @@ package store
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
-	_ "github.com/glebarez/sqlite"
 	"github.com/spf13/viper"
 
 	"wechatoarss/internal/model"
 )
 
-var db *sql.DB
+// Store persists accounts, channels, articles and everything attached to
+// them. SQLiteStore is the default implementation; PostgresStore keeps the
+// same data in PostgreSQL.
+type Store interface {
+	// Accounts
+	CreateAccount(name, cookie, token string) (*model.Account, error)
+	GetAccounts() ([]model.Account, error)
+	GetAccountByID(id int64) (*model.Account, error)
+	UpdateAccount(id int64, name, cookie, token string, available bool) error
+	GetUsableAccounts() ([]model.Account, error)
+	SetAccountWaitTime(id int64, t time.Time) error
+	SetAccountNeedCheck(id int64, needCheck bool) error
+	TouchAccount(id int64) error
+	ResetAccountStatus(id int64) error
+	DeleteAccount(id int64) error
 
-func InitDB() error {
-	// Get database path
+	// Channels
+	CreateChannel(bizID, name, description, avatar, link string, accountID int64) (*model.Channel, error)
+	GetChannels(page, size int, name string) ([]model.Channel, int, error)
+	GetChannelByBizID(bizID string) (*model.Channel, error)
+	GetActiveChannels() ([]model.Channel, error)
+	DeleteChannel(bizID string) error
+	UpdateChannelStatus(bizID string, status string) error
+	UpdateChannelArticleCount(bizID string, count int) error
+
+	// Articles
+	CreateArticle(bizID, title, description, content, link, cover, author, sourceURL string, publishedAt time.Time) (*model.Article, error)
+	GetArticles(bizID string, before, after string, page, size int, includeContent bool) ([]model.Article, int, error)
+	QueryArticles(q ArticleQuery) ([]model.Article, int, error)
+	GetArticleMonths(q ArticleQuery) ([]string, error)
+	GetArticleByID(id int64) (*model.Article, error)
+	SetArticleFlags(id int64, starred, pinned *bool) error
+	SearchArticles(q SearchQuery) ([]model.SearchHit, int, error)
+	GetArticlesToRecheck(since, checkedBefore time.Time, limit int) ([]model.Article, error)
+	MarkArticleChecked(id int64, status string) error
+	AddArticleRevision(id int64, title, content string) (int, error)
+	GetArticleRevisions(id int64) ([]model.ArticleRevision, error)
+	GetArticleRevision(id int64, revision int) (*model.ArticleRevision, error)
+	PruneArticlesByAge(bizID string, cutoff time.Time, dryRun bool) (int64, error)
+	PruneArticlesByCount(bizID string, keep int, cutoff time.Time, dryRun bool) (int64, error)
+
+	// Saved channel filters
+	GetChannelFilter(bizID string) (*model.ArticleFilter, error)
+	GetChannelFilters() (map[string]model.ArticleFilter, error)
+	SetChannelFilter(bizID string, f model.ArticleFilter) error
+	DeleteChannelFilter(bizID string) error
+
+	// Channel groups
+	CreateGroup(name, slug, description string, bizIDs []string) (*model.Group, error)
+	UpdateGroup(id int64, name, slug, description string, bizIDs []string) error
+	DeleteGroup(id int64) error
+	GetGroups() ([]model.Group, error)
+	GetGroupByID(id int64) (*model.Group, error)
+	GetGroupBySlug(slug string) (*model.Group, error)
+
+	// Feed tokens
+	CreateFeedToken(tokenHash, label, scope, target string, expiresAt time.Time) (*model.FeedToken, error)
+	GetFeedTokens() ([]model.FeedToken, error)
+	GetFeedTokenByID(id int64) (*model.FeedToken, error)
+	GetFeedTokenByHash(tokenHash string) (*model.FeedToken, error)
+	TouchFeedToken(id int64) error
+	RevokeFeedToken(id int64) error
+
+	// WebSub subscriptions
+	UpsertSubscription(callback, topic, secret string, lease time.Duration) error
+	DeleteSubscription(callback, topic string) error
+	GetActiveSubscriptions() ([]model.WebSubSubscription, error)
+	CountSubscriptions(callback, topic string) (byCallback, byTopic int, err error)
+	DeleteExpiredSubscriptions() (int64, error)
+
+	// Maintenance
+	MigrationStatus() ([]Migration, error)
+	PendingMigrations() ([]Migration, error)
+	Migrate() ([]Migration, error)
+	Vacuum() (int64, error)
+	Analyze() error
+	Backup(path string) error
+	Close() error
+}
+
+// DBPath returns the configured database file path
+func DBPath() string {
 	dbPath := viper.GetString("database.path")
 	if dbPath == "" {
 		// Default to data directory
 		homeDir, _ := os.UserHomeDir()
 		dbPath = filepath.Join(homeDir, "wechatoarss", "data", "wechatoarss.db")
 	}
+	return dbPath
+}
 
-	// Ensure directory exists
-	dir := filepath.Dir(dbPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
+// DataDir returns the directory holding the database and other data files
+func DataDir() string {
+	return filepath.Dir(DBPath())
+}
 
-	// Open database
-	var err error
-	db, err = sql.Open("sqlite", dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
+// InitDB opens the database and brings its schema up to date, unless
+// database.auto_migrate is off, in which case pending migrations are an
+// error
+func InitDB() (Store, error) {
+	s, err := OpenDB()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Set connection pool settings
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
-
-	// Create tables
-	if err := createTables(); err != nil {
-		return err
+	if viper.GetBool("database.auto_migrate") {
+		applied, err := s.Migrate()
+		for _, m := range applied {
+			log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	} else {
+		pending, err := s.PendingMigrations()
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		if len(pending) > 0 {
+			s.Close()
+			return nil, fmt.Errorf("database has %d pending migrations; run the server with -migrate to apply them", len(pending))
+		}
 	}
 
-	log.Printf("Database initialized at: %s", dbPath)
-	return nil
+	log.Printf("Database initialized at: %s", s)
+	return s, nil
 }
 
-func createTables() error {
-	// Accounts table
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS accounts (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL,
-			cookie TEXT,
-			token TEXT,
-			available INTEGER DEFAULT 1,
-			need_check INTEGER DEFAULT 0,
-			wait_time TEXT,
-			created_at TEXT DEFAULT (datetime('now')),
-			updated_at TEXT DEFAULT (datetime('now'))
-		)
-	`)
-	if err != nil {
-		return err
+// OpenDB opens the database selected by database.driver, "sqlite" (the
+// default) or "postgres", without touching its schema
+func OpenDB() (Store, error) {
+	switch driver := viper.GetString("database.driver"); driver {
+	case "", "sqlite":
+		s, err := OpenSQLite(DBPath())
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "postgres":
+		s, err := OpenPostgres(viper.GetString("database.dsn"))
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown database driver: %s", driver)
 	}
+}
 
-	// Channels table
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS channels (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			biz_id TEXT NOT NULL UNIQUE,
-			name TEXT NOT NULL,
-			description TEXT,
-			avatar TEXT,
-			link TEXT,
-			account_id INTEGER,
-			last_update TEXT,
-			article_count INTEGER DEFAULT 0,
-			status TEXT DEFAULT 'active',
-			created_at TEXT DEFAULT (datetime('now')),
-			FOREIGN KEY (account_id) REFERENCES accounts(id)
-		)
-	`)
-	if err != nil {
-		return err
-	}
+// sqlStore implements what both backends share. Queries are written for
+// SQLite, with ? placeholders, and adapted by the dialect of the backend.
+type sqlStore struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations fs.FS // numbered SQL files, see migrate.go
+	// searchIndex is set when the store keeps articles_fts in step with
+	// articles, see search.go
+	searchIndex bool
+}
 
-	// Articles table
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS articles (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			biz_id TEXT NOT NULL,
-			title TEXT NOT NULL,
-			description TEXT,
-			content TEXT,
-			link TEXT NOT NULL UNIQUE,
-			cover TEXT,
-			created_at TEXT DEFAULT (datetime('now')),
-			published_at TEXT,
-			FOREIGN KEY (biz_id) REFERENCES channels(biz_id)
-		)
-	`)
+// dialect describes how the SQL of a backend differs from SQLite's
+type dialect struct {
+	// rebind rewrites the ? placeholders of a query
+	rebind func(query string) string
+	// like is the operator matching a pattern regardless of case
+	like string
+	// contentText is an expression for the visible text of the content of
+	// the article in the articles row
+	contentText string
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.dialect.rebind(query), sqlArgs(args)...)
+}
+
+func (s *sqlStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.rebind(query), sqlArgs(args)...)
+}
+
+func (s *sqlStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.dialect.rebind(query), sqlArgs(args)...)
+}
+
+// begin starts a transaction whose queries are adapted like the store's
+func (s *sqlStore) begin() (*txn, error) {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &txn{Tx: tx, dialect: s.dialect}, nil
+}
 
-	// Create indexes
-	_, err = db.Exec(`
-		CREATE INDEX IF NOT EXISTS idx_articles_biz_id ON articles(biz_id);
-		CREATE INDEX IF NOT EXISTS idx_articles_published ON articles(published_at);
-		CREATE INDEX IF NOT EXISTS idx_channels_status ON channels(status);
-	`)
-	if err != nil {
-		return err
+type txn struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t *txn) exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Exec(t.dialect.rebind(query), sqlArgs(args)...)
+}
+
+// execer runs statements on the store or within a transaction
+type execer interface {
+	exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (t *txn) queryRow(query string, args ...interface{}) *sql.Row {
+	return t.QueryRow(t.dialect.rebind(query), sqlArgs(args)...)
+}
+
+// sqlArgs converts query arguments to values both backends store alike:
+// booleans as 0 or 1, and times as text in the layout of the SQLite driver,
+// in local time. Publish times are compared as text, so times compared
+// with them must be passed as time.Time as well to get the same offset.
+func sqlArgs(args []interface{}) []interface{} {
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case bool:
+			converted[i] = 0
+			if v {
+				converted[i] = 1
+			}
+		case time.Time:
+			converted[i] = v.Local().Format("2006-01-02 15:04:05.999999999-07:00")
+		default:
+			converted[i] = arg
+		}
 	}
+	return converted
+}
 
-	return nil
+// nowUTC returns the current time as timestamps are stored
+func nowUTC() string {
+	return time.Now().UTC().Format("2006-01-02 15:04:05")
 }
 
-func GetDB() *sql.DB {
-	return db
+// parseTime parses a timestamp column. Rows stamped with the current time
+// and rows written from a time.Time parameter use different layouts.
+func parseTime(s string) time.Time {
+	layouts := []string{
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04:05.999999999-07:00",
+		"2006-01-02 15:04:05.999999999 -0700 MST",
+		time.RFC3339Nano,
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
 }
 
 // Account operations
-func CreateAccount(name, cookie, token string) (*model.Account, error) {
-	result, err := db.Exec(
-		"INSERT INTO accounts (name, cookie, token) VALUES (?, ?, ?)",
+func (s *sqlStore) CreateAccount(name, cookie, token string) (*model.Account, error) {
+	var id int64
+	err := s.queryRow(
+		"INSERT INTO accounts (name, cookie, token) VALUES (?, ?, ?) RETURNING id",
 		name, cookie, token,
-	)
+	).Scan(&id)
 	if err != nil {
 		return nil, err
 	}
 
-	id, _ := result.LastInsertId()
 	return &model.Account{
 		ID:        id,
 		Name:      name,
@@ -148,8 +298,8 @@ func CreateAccount(name, cookie, token string) (*model.Account, error) {
 	}, nil
 }
 
-func GetAccounts() ([]model.Account, error) {
-	rows, err := db.Query(`
+func (s *sqlStore) GetAccounts() ([]model.Account, error) {
+	rows, err := s.query(`
 		SELECT id, name, cookie, token, available, need_check, wait_time, created_at, updated_at 
 		FROM accounts
 	`)
@@ -161,80 +311,138 @@ func GetAccounts() ([]model.Account, error) {
 	var accounts []model.Account
 	for rows.Next() {
 		var a model.Account
-		var waitTime sql.NullString
-		err := rows.Scan(&a.ID, &a.Name, &a.Cookie, &a.Token, &a.Available, &a.NeedCheck, &waitTime, &a.CreatedAt, &a.UpdatedAt)
+		var cookie, token, waitTime, createdAt, updatedAt sql.NullString
+		err := rows.Scan(&a.ID, &a.Name, &cookie, &token, &a.Available, &a.NeedCheck, &waitTime, &createdAt, &updatedAt)
 		if err != nil {
 			return nil, err
 		}
+		a.Cookie = cookie.String
+		a.Token = token.String
 		if waitTime.Valid {
-			a.WaitTime, _ = time.Parse("2006-01-02 15:04:05", waitTime.String)
+			a.WaitTime = parseTime(waitTime.String)
 		}
+		a.CreatedAt = parseTime(createdAt.String)
+		a.UpdatedAt = parseTime(updatedAt.String)
 		accounts = append(accounts, a)
 	}
 	return accounts, nil
 }
 
-func GetAccountByID(id int64) (*model.Account, error) {
+func (s *sqlStore) GetAccountByID(id int64) (*model.Account, error) {
 	var a model.Account
-	var waitTime sql.NullString
-	err := db.QueryRow(`
+	var cookie, token, waitTime, createdAt, updatedAt sql.NullString
+	err := s.queryRow(`
 		SELECT id, name, cookie, token, available, need_check, wait_time, created_at, updated_at 
 		FROM accounts WHERE id = ?
-	`, id).Scan(&a.ID, &a.Name, &a.Cookie, &a.Token, &a.Available, &a.NeedCheck, &waitTime, &a.CreatedAt, &a.UpdatedAt)
+	`, id).Scan(&a.ID, &a.Name, &cookie, &token, &a.Available, &a.NeedCheck, &waitTime, &createdAt, &updatedAt)
 	if err != nil {
 		return nil, err
 	}
+	a.Cookie = cookie.String
+	a.Token = token.String
 	if waitTime.Valid {
-		a.WaitTime, _ = time.Parse("2006-01-02 15:04:05", waitTime.String)
+		a.WaitTime = parseTime(waitTime.String)
 	}
+	a.CreatedAt = parseTime(createdAt.String)
+	a.UpdatedAt = parseTime(updatedAt.String)
 	return &a, nil
 }
 
-func UpdateAccount(id int64, name, cookie, token string, available bool) error {
-	_, err := db.Exec(`
-		UPDATE accounts SET name = ?, cookie = ?, token = ?, available = ?, updated_at = datetime('now') WHERE id = ?
-	`, name, cookie, token, available, id)
+func (s *sqlStore) UpdateAccount(id int64, name, cookie, token string, available bool) error {
+	_, err := s.exec(`
+		UPDATE accounts SET name = ?, cookie = ?, token = ?, available = ?, need_check = 0, wait_time = NULL, updated_at = ? WHERE id = ?
+	`, name, cookie, token, available, nowUTC(), id)
 	return err
 }
 
-func DeleteAccount(id int64) error {
-	_, err := db.Exec("DELETE FROM accounts WHERE id = ?", id)
+// GetUsableAccounts returns accounts that are available, not flagged for a
+// session check and not cooling down, least recently used first
+func (s *sqlStore) GetUsableAccounts() ([]model.Account, error) {
+	accounts, err := s.GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var usable []model.Account
+	for _, a := range accounts {
+		if a.Available && !a.NeedCheck && a.Token != "" && now.After(a.WaitTime) {
+			usable = append(usable, a)
+		}
+	}
+	sort.SliceStable(usable, func(i, j int) bool {
+		return usable[i].UpdatedAt.Before(usable[j].UpdatedAt)
+	})
+	return usable, nil
+}
+
+// SetAccountWaitTime puts an account on cooldown until t
+func (s *sqlStore) SetAccountWaitTime(id int64, t time.Time) error {
+	_, err := s.exec(`
+		UPDATE accounts SET wait_time = ?, updated_at = ? WHERE id = ?
+	`, t.UTC().Format("2006-01-02 15:04:05"), nowUTC(), id)
+	return err
+}
+
+// SetAccountNeedCheck flags an account whose session is no longer valid
+func (s *sqlStore) SetAccountNeedCheck(id int64, needCheck bool) error {
+	_, err := s.exec(`
+		UPDATE accounts SET need_check = ?, updated_at = ? WHERE id = ?
+	`, needCheck, nowUTC(), id)
+	return err
+}
+
+// TouchAccount records that an account was just used
+func (s *sqlStore) TouchAccount(id int64) error {
+	_, err := s.exec("UPDATE accounts SET updated_at = ? WHERE id = ?", nowUTC(), id)
+	return err
+}
+
+// ResetAccountStatus clears cooldown and session check flags
+func (s *sqlStore) ResetAccountStatus(id int64) error {
+	_, err := s.exec(`
+		UPDATE accounts SET available = 1, need_check = 0, wait_time = NULL, updated_at = ? WHERE id = ?
+	`, nowUTC(), id)
+	return err
+}
+
+func (s *sqlStore) DeleteAccount(id int64) error {
+	_, err := s.exec("DELETE FROM accounts WHERE id = ?", id)
 	return err
 }
 
 // Channel operations
-func CreateChannel(bizID, name, description, avatar, link string, accountID int64) (*model.Channel, error) {
-	result, err := db.Exec(`
+func (s *sqlStore) CreateChannel(bizID, name, description, avatar, link string, accountID int64) (*model.Channel, error) {
+	var id int64
+	err := s.queryRow(`
 		INSERT INTO channels (biz_id, name, description, avatar, link, account_id, last_update)
-		VALUES (?, ?, ?, ?, ?, ?, datetime('now'))
-	`, bizID, name, description, avatar, link, accountID)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id
+	`, bizID, name, description, avatar, link, accountID, nowUTC()).Scan(&id)
 	if err != nil {
 		return nil, err
 	}
-
-	id, _ := result.LastInsertId()
 	return &model.Channel{
-		ID:        id,
-		BizID:     bizID,
-		Name:      name,
+		ID:          id,
+		BizID:       bizID,
+		Name:        name,
 		Description: description,
-		Avatar:    avatar,
-		Link:      link,
-		AccountID: accountID,
-		Status:    "active",
-		LastUpdate: time.Now(),
+		Avatar:      avatar,
+		Link:        link,
+		AccountID:   accountID,
+		Status:      "active",
+		LastUpdate:  time.Now(),
 	}, nil
 }
 
-func GetChannels(page, size int, name string) ([]model.Channel, int, error) {
+func (s *sqlStore) GetChannels(page, size int, name string) ([]model.Channel, int, error) {
 	offset := (page - 1) * size
 	var query string
 	var countQuery string
 	var args []interface{}
 
 	if name != "" {
-		query = "SELECT id, biz_id, name, description, avatar, link, account_id, last_update, article_count, status, created_at FROM channels WHERE name LIKE ? LIMIT ? OFFSET ?"
-		countQuery = "SELECT COUNT(*) FROM channels WHERE name LIKE ?"
+		query = "SELECT id, biz_id, name, description, avatar, link, account_id, last_update, article_count, status, created_at FROM channels WHERE name " + s.dialect.like + " ? LIMIT ? OFFSET ?"
+		countQuery = "SELECT COUNT(*) FROM channels WHERE name " + s.dialect.like + " ?"
 		args = []interface{}{"%" + name + "%", size, offset}
 	} else {
 		query = "SELECT id, biz_id, name, description, avatar, link, account_id, last_update, article_count, status, created_at FROM channels LIMIT ? OFFSET ?"
@@ -242,7 +450,7 @@ func GetChannels(page, size int, name string) ([]model.Channel, int, error) {
 		args = []interface{}{size, offset}
 	}
 
-	rows, err := db.Query(query, args...)
+	rows, err := s.query(query, args...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -257,10 +465,10 @@ func GetChannels(page, size int, name string) ([]model.Channel, int, error) {
 			return nil, 0, err
 		}
 		if lastUpdate.Valid {
-			c.LastUpdate, _ = time.Parse("2006-01-02 15:04:05", lastUpdate.String)
+			c.LastUpdate = parseTime(lastUpdate.String)
 		}
 		if createdAt.Valid {
-			c.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt.String)
+			c.CreatedAt = parseTime(createdAt.String)
 		}
 		channels = append(channels, c)
 	}
@@ -268,18 +476,18 @@ func GetChannels(page, size int, name string) ([]model.Channel, int, error) {
 	// Get total count
 	var total int
 	if name != "" {
-		db.QueryRow(countQuery, "%"+name+"%").Scan(&total)
+		s.queryRow(countQuery, "%"+name+"%").Scan(&total)
 	} else {
-		db.QueryRow(countQuery).Scan(&total)
+		s.queryRow(countQuery).Scan(&total)
 	}
 
 	return channels, total, nil
 }
 
-func GetChannelByBizID(bizID string) (*model.Channel, error) {
+func (s *sqlStore) GetChannelByBizID(bizID string) (*model.Channel, error) {
 	var c model.Channel
 	var lastUpdate, createdAt sql.NullString
-	err := db.QueryRow(`
+	err := s.queryRow(`
 		SELECT id, biz_id, name, description, avatar, link, account_id, last_update, article_count, status, created_at 
 		FROM channels WHERE biz_id = ?
 	`, bizID).Scan(&c.ID, &c.BizID, &c.Name, &c.Description, &c.Avatar, &c.Link, &c.AccountID, &lastUpdate, &c.ArticleCount, &c.Status, &createdAt)
@@ -287,13 +495,13 @@ func GetChannelByBizID(bizID string) (*model.Channel, error) {
 		return nil, err
 	}
 	if lastUpdate.Valid {
-		c.LastUpdate, _ = time.Parse("2006-01-02 15:04:05", lastUpdate.String)
+		c.LastUpdate = parseTime(lastUpdate.String)
 	}
 	return &c, nil
 }
 
-func GetActiveChannels() ([]model.Channel, error) {
-	rows, err := db.Query(`
+func (s *sqlStore) GetActiveChannels() ([]model.Channel, error) {
+	rows, err := s.query(`
 		SELECT id, biz_id, name, description, avatar, link, account_id, last_update, article_count, status, created_at 
 		FROM channels WHERE status = 'active'
 	`)
@@ -311,46 +519,83 @@ func GetActiveChannels() ([]model.Channel, error) {
 			return nil, err
 		}
 		if lastUpdate.Valid {
-			c.LastUpdate, _ = time.Parse("2006-01-02 15:04:05", lastUpdate.String)
+			c.LastUpdate = parseTime(lastUpdate.String)
 		}
 		channels = append(channels, c)
 	}
 	return channels, nil
 }
 
-func DeleteChannel(bizID string) error {
-	_, err := db.Exec("DELETE FROM channels WHERE biz_id = ?", bizID)
+func (s *sqlStore) DeleteChannel(bizID string) error {
+	tx, err := s.begin()
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec("DELETE FROM articles WHERE biz_id = ?", bizID)
-	return err
+	defer tx.Rollback()
+
+	_, err = tx.exec("DELETE FROM channels WHERE biz_id = ?", bizID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.exec("DELETE FROM channel_group_members WHERE biz_id = ?", bizID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.exec("DELETE FROM channel_filters WHERE biz_id = ?", bizID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.exec("DELETE FROM article_revisions WHERE article_id IN (SELECT id FROM articles WHERE biz_id = ?)", bizID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.exec("DELETE FROM articles WHERE biz_id = ?", bizID)
+	if err != nil {
+		return err
+	}
+	if err := s.unindexDeletedArticles(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func UpdateChannelStatus(bizID string, status string) error {
-	_, err := db.Exec("UPDATE channels SET status = ? WHERE biz_id = ?", status, bizID)
+func (s *sqlStore) UpdateChannelStatus(bizID string, status string) error {
+	_, err := s.exec("UPDATE channels SET status = ? WHERE biz_id = ?", status, bizID)
 	return err
 }
 
-func UpdateChannelArticleCount(bizID string, count int) error {
-	_, err := db.Exec("UPDATE channels SET article_count = ?, last_update = datetime('now') WHERE biz_id = ?", count, bizID)
+func (s *sqlStore) UpdateChannelArticleCount(bizID string, count int) error {
+	_, err := s.exec("UPDATE channels SET article_count = ?, last_update = ? WHERE biz_id = ?", count, nowUTC(), bizID)
 	return err
 }
 
 // Article operations
-func CreateArticle(bizID, title, description, content, link, cover string, publishedAt time.Time) (*model.Article, error) {
-	result, err := db.Exec(`
-		INSERT OR IGNORE INTO articles (biz_id, title, description, content, link, cover, published_at, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, datetime('now'))
-	`, bizID, title, description, content, link, cover, publishedAt)
+func (s *sqlStore) CreateArticle(bizID, title, description, content, link, cover, author, sourceURL string, publishedAt time.Time) (*model.Article, error) {
+	tx, err := s.begin()
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	id, _ := result.LastInsertId()
-	if id == 0 {
+	hash := ContentHash(title, content)
+	var id int64
+	err = tx.queryRow(`
+		INSERT INTO articles (biz_id, title, description, content, link, cover, author, source_url, published_at, created_at, content_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (link) DO NOTHING RETURNING id
+	`, bizID, title, description, content, link, cover, author, sourceURL, publishedAt, nowUTC(), hash).Scan(&id)
+	if err == sql.ErrNoRows {
 		return nil, nil // Already exists
 	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.indexArticle(tx, id, title, description, content); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 
 	return &model.Article{
 		ID:          id,
@@ -360,48 +605,61 @@ func CreateArticle(bizID, title, description, content, link, cover string, publi
 		Content:     content,
 		Link:        link,
 		Cover:       cover,
+		Author:      author,
+		SourceURL:   sourceURL,
 		PublishedAt: publishedAt,
 		CreatedAt:   time.Now(),
+		ContentHash: hash,
+		Status:      model.ArticleStatusPublished,
 	}, nil
 }
 
-func GetArticles(bizID string, before, after string, page, size int, includeContent bool) ([]model.Article, int, error) {
-	offset := (page - 1) * size
-	var args []interface{}
-	query := "SELECT id, biz_id, title, description, content, link, cover, created_at, published_at FROM articles"
-	where := ""
+func (s *sqlStore) GetArticles(bizID string, before, after string, page, size int, includeContent bool) ([]model.Article, int, error) {
+	return s.QueryArticles(ArticleQuery{
+		BizID:  bizID,
+		Before: before,
+		After:  after,
+		Page:   page,
+		Size:   size,
+	})
+}
 
-	if bizID != "" {
-		where = " biz_id = ?"
-		args = append(args, bizID)
-	}
+// ArticleQuery selects articles for listings and feeds
+type ArticleQuery struct {
+	BizID   string
+	GroupID int64
+	Before  string // YYYYMMDD
+	After   string // YYYYMMDD
+	Month   string // YYYY-MM
+	Filters []ScopedFilter
+	Page    int
+	Size    int
+}
 
-	if before != "" {
-		if where != "" {
-			where += " AND"
-		}
-		where += " published_at < ?"
-		t, _ := time.Parse("20060102", before)
-		args = append(args, t.Format("2006-01-02"))
-	}
+// ScopedFilter is a filter applying to one channel, or to every channel
+// when BizID is empty. Only its keyword rules are evaluated in SQL.
+type ScopedFilter struct {
+	BizID  string
+	Filter model.ArticleFilter
+}
 
-	if after != "" {
-		if where != "" {
-			where += " AND"
-		}
-		where += " published_at > ?"
-		t, _ := time.Parse("20060102", after)
-		args = append(args, t.Format("2006-01-02"))
-	}
+// QueryArticles returns a page of the articles matching q, newest first,
+// and the number of matching articles
+func (s *sqlStore) QueryArticles(q ArticleQuery) ([]model.Article, int, error) {
+	where, args := s.articleConditions(q)
 
-	if where != "" {
-		query += " WHERE" + where
+	page, size := q.Page, q.Size
+	if page < 1 {
+		page = 1
 	}
+	offset := (page - 1) * size
 
-	query += " ORDER BY published_at DESC LIMIT ? OFFSET ?"
-	args = append(args, size, offset)
-
-	rows, err := db.Query(query, args...)
+	rows, err := s.query(`
+		SELECT id, biz_id, title, description, content, link, cover, COALESCE(author, ''), COALESCE(source_url, ''),
+			created_at, published_at, status, updated_at, checked_at
+		FROM articles`+where+`
+		ORDER BY published_at DESC LIMIT ? OFFSET ?
+	`, append(args, size, offset)...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -410,13 +668,10 @@ func GetArticles(bizID string, before, after string, page, size int, includeCont
 	var articles []model.Article
 	for rows.Next() {
 		var a model.Article
-		var createdAt, publishedAt sql.NullString
+		var createdAt, publishedAt, updatedAt, checkedAt sql.NullString
 		var content sql.NullString
-		if includeContent {
-			err = rows.Scan(&a.ID, &a.BizID, &a.Title, &a.Description, &content, &a.Link, &a.Cover, &createdAt, &publishedAt)
-		} else {
-			err = rows.Scan(&a.ID, &a.BizID, &a.Title, &a.Description, &content, &a.Link, &a.Cover, &createdAt, &publishedAt)
-		}
+		err = rows.Scan(&a.ID, &a.BizID, &a.Title, &a.Description, &content, &a.Link, &a.Cover, &a.Author, &a.SourceURL,
+			&createdAt, &publishedAt, &a.Status, &updatedAt, &checkedAt)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -424,88 +679,606 @@ func GetArticles(bizID string, before, after string, page, size int, includeCont
 			a.Content = content.String
 		}
 		if createdAt.Valid {
-			a.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt.String)
+			a.CreatedAt = parseTime(createdAt.String)
 		}
 		if publishedAt.Valid {
-			a.PublishedAt, _ = time.Parse("2006-01-02 15:04:05", publishedAt.String)
+			a.PublishedAt = parseTime(publishedAt.String)
+		}
+		if updatedAt.Valid {
+			a.UpdatedAt = parseTime(updatedAt.String)
+		}
+		if checkedAt.Valid {
+			a.CheckedAt = parseTime(checkedAt.String)
 		}
 		articles = append(articles, a)
 	}
 
 	// Get total count
 	var total int
-	countQuery := "SELECT COUNT(*) FROM articles"
-	if bizID != "" {
-		countQuery += " WHERE biz_id = ?"
-		db.QueryRow(countQuery, bizID).Scan(&total)
+	s.queryRow("SELECT COUNT(*) FROM articles"+where, args...).Scan(&total)
+
+	return articles, total, nil
+}
+
+// articleConditions builds the WHERE clause selecting the articles of q
+func (s *sqlStore) articleConditions(q ArticleQuery) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	if q.BizID != "" {
+		conds = append(conds, "biz_id = ?")
+		args = append(args, q.BizID)
+	}
+	if q.GroupID != 0 {
+		conds = append(conds, "biz_id IN (SELECT biz_id FROM channel_group_members WHERE group_id = ?)")
+		args = append(args, q.GroupID)
+	}
+	if q.Before != "" {
+		t, _ := time.Parse("20060102", q.Before)
+		conds = append(conds, "published_at < ?")
+		args = append(args, t.Format("2006-01-02"))
+	}
+	if q.After != "" {
+		t, _ := time.Parse("20060102", q.After)
+		conds = append(conds, "published_at > ?")
+		args = append(args, t.Format("2006-01-02"))
+	}
+	if q.Month != "" {
+		// Compared as text so the month is that of the stored publish time
+		start, _ := time.Parse("2006-01", q.Month)
+		conds = append(conds, "published_at >= ? AND published_at < ?")
+		args = append(args, start.Format("2006-01"), start.AddDate(0, 1, 0).Format("2006-01"))
+	}
+	for _, f := range q.Filters {
+		cond, condArgs := s.keywordCondition(f.Filter)
+		if cond == "" {
+			continue
+		}
+		if f.BizID != "" {
+			cond = "(biz_id <> ? OR " + cond + ")"
+			condArgs = append([]interface{}{f.BizID}, condArgs...)
+		}
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+	return where, args
+}
+
+// GetArticleMonths returns the months (YYYY-MM) in which the articles
+// matching q were published, oldest first. Page, Size, Month and the
+// filters of q are ignored.
+func (s *sqlStore) GetArticleMonths(q ArticleQuery) ([]string, error) {
+	where, args := s.articleConditions(ArticleQuery{BizID: q.BizID, GroupID: q.GroupID, Before: q.Before, After: q.After})
+	if where == "" {
+		where = " WHERE published_at IS NOT NULL"
 	} else {
-		db.QueryRow(countQuery).Scan(&total)
+		where += " AND published_at IS NOT NULL"
 	}
 
-	return articles, total, nil
+	rows, err := s.query("SELECT DISTINCT substr(published_at, 1, 7) AS month FROM articles"+where+" ORDER BY month", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var months []string
+	for rows.Next() {
+		var month string
+		if err := rows.Scan(&month); err != nil {
+			return nil, err
+		}
+		months = append(months, month)
+	}
+	return months, rows.Err()
+}
+
+// keywordCondition translates the keyword rules of f into SQL: at least
+// one include keyword and no exclude keyword must appear in the selected
+// fields. Content is matched on its text, like the rules evaluated in Go,
+// so keywords never match markup. Matching ignores case, on SQLite for
+// ASCII letters only.
+func (s *sqlStore) keywordCondition(f model.ArticleFilter) (string, []interface{}) {
+	filterColumns := map[string]string{
+		"title":   "title",
+		"digest":  "description",
+		"content": s.dialect.contentText,
+	}
+	var columns []string
+	for _, field := range f.Fields {
+		if col, ok := filterColumns[field]; ok {
+			columns = append(columns, col)
+		}
+	}
+	if len(columns) == 0 {
+		columns = []string{"title", "description", s.dialect.contentText}
+	}
+
+	match := func(keyword string) (string, []interface{}) {
+		pattern := "%" + likeEscaper.Replace(keyword) + "%"
+		var parts []string
+		var args []interface{}
+		for _, col := range columns {
+			parts = append(parts, "COALESCE("+col+", '') "+s.dialect.like+" ? ESCAPE '\\'")
+			args = append(args, pattern)
+		}
+		return "(" + strings.Join(parts, " OR ") + ")", args
+	}
+
+	var conds []string
+	var args []interface{}
+
+	var includes []string
+	for _, kw := range f.Include {
+		if kw == "" {
+			continue
+		}
+		cond, condArgs := match(kw)
+		includes = append(includes, cond)
+		args = append(args, condArgs...)
+	}
+	if len(includes) > 0 {
+		conds = append(conds, "("+strings.Join(includes, " OR ")+")")
+	}
+
+	for _, kw := range f.Exclude {
+		if kw == "" {
+			continue
+		}
+		cond, condArgs := match(kw)
+		conds = append(conds, "NOT "+cond)
+		args = append(args, condArgs...)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "(" + strings.Join(conds, " AND ") + ")", args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Channel filter operations
+func (s *sqlStore) GetChannelFilter(bizID string) (*model.ArticleFilter, error) {
+	var rules string
+	err := s.queryRow("SELECT rules FROM channel_filters WHERE biz_id = ?", bizID).Scan(&rules)
+	if err != nil {
+		return nil, err
+	}
+
+	var f model.ArticleFilter
+	if err := json.Unmarshal([]byte(rules), &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
 }
 
-func GetArticleByID(id int64) (*model.Article, error) {
+// GetChannelFilters returns the saved filter of every channel that has one
+func (s *sqlStore) GetChannelFilters() (map[string]model.ArticleFilter, error) {
+	rows, err := s.query("SELECT biz_id, rules FROM channel_filters")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filters := make(map[string]model.ArticleFilter)
+	for rows.Next() {
+		var bizID, rules string
+		if err := rows.Scan(&bizID, &rules); err != nil {
+			return nil, err
+		}
+		var f model.ArticleFilter
+		if err := json.Unmarshal([]byte(rules), &f); err != nil {
+			log.Printf("Ignoring invalid filter of %s: %v", bizID, err)
+			continue
+		}
+		filters[bizID] = f
+	}
+	return filters, rows.Err()
+}
+
+func (s *sqlStore) SetChannelFilter(bizID string, f model.ArticleFilter) error {
+	rules, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(`
+		INSERT INTO channel_filters (biz_id, rules, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (biz_id) DO UPDATE SET rules = excluded.rules, updated_at = excluded.updated_at
+	`, bizID, string(rules), nowUTC())
+	return err
+}
+
+func (s *sqlStore) DeleteChannelFilter(bizID string) error {
+	_, err := s.exec("DELETE FROM channel_filters WHERE biz_id = ?", bizID)
+	return err
+}
+
+func (s *sqlStore) GetArticleByID(id int64) (*model.Article, error) {
 	var a model.Article
-	var createdAt, publishedAt sql.NullString
-	err := db.QueryRow(`
-		SELECT id, biz_id, title, description, content, link, cover, created_at, published_at 
+	var createdAt, publishedAt, updatedAt, checkedAt, hash sql.NullString
+	err := s.queryRow(`
+		SELECT id, biz_id, title, description, content, link, cover, COALESCE(author, ''), COALESCE(source_url, ''),
+			created_at, published_at, starred, pinned, content_hash, status, updated_at, checked_at
 		FROM articles WHERE id = ?
-	`, id).Scan(&a.ID, &a.BizID, &a.Title, &a.Description, &a.Content, &a.Link, &a.Cover, &createdAt, &publishedAt)
+	`, id).Scan(&a.ID, &a.BizID, &a.Title, &a.Description, &a.Content, &a.Link, &a.Cover, &a.Author, &a.SourceURL,
+		&createdAt, &publishedAt, &a.Starred, &a.Pinned, &hash, &a.Status, &updatedAt, &checkedAt)
 	if err != nil {
 		return nil, err
 	}
 	if createdAt.Valid {
-		a.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt.String)
+		a.CreatedAt = parseTime(createdAt.String)
 	}
 	if publishedAt.Valid {
-		a.PublishedAt, _ = time.Parse("2006-01-02 15:04:05", publishedAt.String)
+		a.PublishedAt = parseTime(publishedAt.String)
+	}
+	if updatedAt.Valid {
+		a.UpdatedAt = parseTime(updatedAt.String)
+	}
+	if checkedAt.Valid {
+		a.CheckedAt = parseTime(checkedAt.String)
+	}
+	a.ContentHash = hash.String
+	if a.ContentHash == "" {
+		// Saved before content hashes were recorded
+		a.ContentHash = ContentHash(a.Title, a.Content)
 	}
 
 	// Get channel name
 	var channelName string
-	db.QueryRow("SELECT name FROM channels WHERE biz_id = ?", a.BizID).Scan(&channelName)
+	s.queryRow("SELECT name FROM channels WHERE biz_id = ?", a.BizID).Scan(&channelName)
 	a.ChannelName = channelName
 
 	return &a, nil
 }
 
-func SearchArticles(keyword string, page, size int) ([]model.Article, int, error) {
-	offset := (page - 1) * size
-	rows, err := db.Query(`
-		SELECT id, biz_id, title, description, content, link, cover, created_at, published_at 
-		FROM articles 
-		WHERE title LIKE ? OR description LIKE ? OR content LIKE ?
-		ORDER BY published_at DESC 
-		LIMIT ? OFFSET ?
-	`, "%"+keyword+"%", "%"+keyword+"%", "%"+keyword+"%", size, offset)
+// SetArticleFlags stars or pins an article; nil flags are left unchanged
+func (s *sqlStore) SetArticleFlags(id int64, starred, pinned *bool) error {
+	if starred != nil {
+		if _, err := s.exec("UPDATE articles SET starred = ? WHERE id = ?", *starred, id); err != nil {
+			return err
+		}
+	}
+	if pinned != nil {
+		if _, err := s.exec("UPDATE articles SET pinned = ? WHERE id = ?", *pinned, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Channel group operations
+func (s *sqlStore) CreateGroup(name, slug, description string, bizIDs []string) (*model.Group, error) {
+	tx, err := s.begin()
 	if err != nil {
-		return nil, 0, err
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var slugArg interface{}
+	if slug != "" {
+		slugArg = slug
+	}
+	var id int64
+	err = tx.queryRow("INSERT INTO channel_groups (name, slug, description) VALUES (?, ?, ?) RETURNING id", name, slugArg, description).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Names without a usable slug get one derived from the id
+	if slug == "" {
+		slug = fmt.Sprintf("group-%d", id)
+		if _, err := tx.exec("UPDATE channel_groups SET slug = ? WHERE id = ?", slug, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.setGroupChannels(tx, id, bizIDs); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetGroupByID(id)
+}
+
+func (s *sqlStore) UpdateGroup(id int64, name, slug, description string, bizIDs []string) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.exec("UPDATE channel_groups SET name = ?, slug = ?, description = ? WHERE id = ?", name, slug, description, id); err != nil {
+		return err
+	}
+	if bizIDs != nil {
+		if err := s.setGroupChannels(tx, id, bizIDs); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) setGroupChannels(tx *txn, id int64, bizIDs []string) error {
+	if _, err := tx.exec("DELETE FROM channel_group_members WHERE group_id = ?", id); err != nil {
+		return err
+	}
+	for _, bizID := range bizIDs {
+		if _, err := tx.exec("INSERT INTO channel_group_members (group_id, biz_id) VALUES (?, ?) ON CONFLICT DO NOTHING", id, bizID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteGroup(id int64) error {
+	_, err := s.exec("DELETE FROM channel_group_members WHERE group_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec("DELETE FROM channel_groups WHERE id = ?", id)
+	return err
+}
+
+// GetGroups returns every group with its channels
+func (s *sqlStore) GetGroups() ([]model.Group, error) {
+	rows, err := s.query("SELECT id, name, slug, description, created_at FROM channel_groups ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []model.Group
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		groups = append(groups, *g)
+	}
+	rows.Close()
+
+	members, err := s.getGroupMembers()
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		groups[i].Channels = members[groups[i].ID]
+		if groups[i].Channels == nil {
+			groups[i].Channels = []string{}
+		}
+	}
+	return groups, nil
+}
+
+func (s *sqlStore) GetGroupByID(id int64) (*model.Group, error) {
+	row := s.queryRow("SELECT id, name, slug, description, created_at FROM channel_groups WHERE id = ?", id)
+	return s.getGroup(row)
+}
+
+func (s *sqlStore) GetGroupBySlug(slug string) (*model.Group, error) {
+	row := s.queryRow("SELECT id, name, slug, description, created_at FROM channel_groups WHERE slug = ?", slug)
+	return s.getGroup(row)
+}
+
+func (s *sqlStore) getGroup(row *sql.Row) (*model.Group, error) {
+	g, err := scanGroup(row)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.query("SELECT biz_id FROM channel_group_members WHERE group_id = ? ORDER BY biz_id", g.ID)
+	if err != nil {
+		return nil, err
 	}
 	defer rows.Close()
 
-	var articles []model.Article
+	g.Channels = []string{}
 	for rows.Next() {
-		var a model.Article
-		var createdAt, publishedAt sql.NullString
-		err := rows.Scan(&a.ID, &a.BizID, &a.Title, &a.Description, &a.Content, &a.Link, &a.Cover, &createdAt, &publishedAt)
+		var bizID string
+		if err := rows.Scan(&bizID); err != nil {
+			return nil, err
+		}
+		g.Channels = append(g.Channels, bizID)
+	}
+	return g, rows.Err()
+}
+
+func scanGroup(row interface{ Scan(...interface{}) error }) (*model.Group, error) {
+	var g model.Group
+	var slug, description, createdAt sql.NullString
+	if err := row.Scan(&g.ID, &g.Name, &slug, &description, &createdAt); err != nil {
+		return nil, err
+	}
+	g.Slug = slug.String
+	g.Description = description.String
+	if createdAt.Valid {
+		g.CreatedAt = parseTime(createdAt.String)
+	}
+	return &g, nil
+}
+
+// getGroupMembers maps each group id to the biz ids of its channels
+func (s *sqlStore) getGroupMembers() (map[int64][]string, error) {
+	rows, err := s.query("SELECT group_id, biz_id FROM channel_group_members ORDER BY group_id, biz_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make(map[int64][]string)
+	for rows.Next() {
+		var id int64
+		var bizID string
+		if err := rows.Scan(&id, &bizID); err != nil {
+			return nil, err
+		}
+		members[id] = append(members[id], bizID)
+	}
+	return members, rows.Err()
+}
+
+// Feed token operations
+func (s *sqlStore) CreateFeedToken(tokenHash, label, scope, target string, expiresAt time.Time) (*model.FeedToken, error) {
+	var expires interface{}
+	if !expiresAt.IsZero() {
+		expires = expiresAt.UTC().Format("2006-01-02 15:04:05")
+	}
+	var id int64
+	err := s.queryRow(`
+		INSERT INTO feed_tokens (token_hash, label, scope, target, expires_at)
+		VALUES (?, ?, ?, ?, ?) RETURNING id
+	`, tokenHash, label, scope, target, expires).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetFeedTokenByID(id)
+}
+
+const feedTokenColumns = "id, label, scope, target, expires_at, last_used_at, revoked_at, created_at"
+
+func (s *sqlStore) GetFeedTokens() ([]model.FeedToken, error) {
+	rows, err := s.query("SELECT " + feedTokenColumns + " FROM feed_tokens ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []model.FeedToken
+	for rows.Next() {
+		t, err := scanFeedToken(rows)
 		if err != nil {
-			return nil, 0, err
+			return nil, err
 		}
-		if createdAt.Valid {
-			a.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt.String)
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqlStore) GetFeedTokenByID(id int64) (*model.FeedToken, error) {
+	return scanFeedToken(s.queryRow("SELECT "+feedTokenColumns+" FROM feed_tokens WHERE id = ?", id))
+}
+
+func (s *sqlStore) GetFeedTokenByHash(tokenHash string) (*model.FeedToken, error) {
+	return scanFeedToken(s.queryRow("SELECT "+feedTokenColumns+" FROM feed_tokens WHERE token_hash = ?", tokenHash))
+}
+
+func scanFeedToken(row interface{ Scan(...interface{}) error }) (*model.FeedToken, error) {
+	var t model.FeedToken
+	var label, target, expiresAt, lastUsedAt, revokedAt, createdAt sql.NullString
+	if err := row.Scan(&t.ID, &label, &t.Scope, &target, &expiresAt, &lastUsedAt, &revokedAt, &createdAt); err != nil {
+		return nil, err
+	}
+	t.Label = label.String
+	t.Target = target.String
+	if expiresAt.Valid {
+		t.ExpiresAt = parseTime(expiresAt.String)
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = parseTime(lastUsedAt.String)
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = parseTime(revokedAt.String)
+	}
+	if createdAt.Valid {
+		t.CreatedAt = parseTime(createdAt.String)
+	}
+	return &t, nil
+}
+
+func (s *sqlStore) TouchFeedToken(id int64) error {
+	_, err := s.exec("UPDATE feed_tokens SET last_used_at = ? WHERE id = ?", nowUTC(), id)
+	return err
+}
+
+func (s *sqlStore) RevokeFeedToken(id int64) error {
+	_, err := s.exec("UPDATE feed_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", nowUTC(), id)
+	return err
+}
+
+// WebSub subscription operations
+func (s *sqlStore) UpsertSubscription(callback, topic, secret string, lease time.Duration) error {
+	expiresAt := time.Now().Add(lease).UTC().Format("2006-01-02 15:04:05")
+	_, err := s.exec(`
+		INSERT INTO websub_subscriptions (callback, topic, secret, lease_seconds, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (callback, topic) DO UPDATE SET
+			secret = excluded.secret,
+			lease_seconds = excluded.lease_seconds,
+			expires_at = excluded.expires_at
+	`, callback, topic, secret, int64(lease/time.Second), expiresAt)
+	return err
+}
+
+func (s *sqlStore) DeleteSubscription(callback, topic string) error {
+	_, err := s.exec("DELETE FROM websub_subscriptions WHERE callback = ? AND topic = ?", callback, topic)
+	return err
+}
+
+// GetActiveSubscriptions returns the subscriptions whose lease has not expired
+func (s *sqlStore) GetActiveSubscriptions() ([]model.WebSubSubscription, error) {
+	rows, err := s.query(`
+		SELECT id, callback, topic, secret, lease_seconds, expires_at, created_at
+		FROM websub_subscriptions
+		WHERE expires_at > ?
+		ORDER BY id
+	`, nowUTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []model.WebSubSubscription
+	for rows.Next() {
+		var sub model.WebSubSubscription
+		var secret, expiresAt, createdAt sql.NullString
+		if err := rows.Scan(&sub.ID, &sub.Callback, &sub.Topic, &secret, &sub.LeaseSeconds, &expiresAt, &createdAt); err != nil {
+			return nil, err
 		}
-		if publishedAt.Valid {
-			a.PublishedAt, _ = time.Parse("2006-01-02 15:04:05", publishedAt.String)
+		sub.Secret = secret.String
+		if expiresAt.Valid {
+			sub.ExpiresAt = parseTime(expiresAt.String)
 		}
-		articles = append(articles, a)
+		if createdAt.Valid {
+			sub.CreatedAt = parseTime(createdAt.String)
+		}
+		subs = append(subs, sub)
 	}
+	return subs, rows.Err()
+}
 
-	var total int
-	db.QueryRow(`
-		SELECT COUNT(*) FROM articles 
-		WHERE title LIKE ? OR description LIKE ? OR content LIKE ?
-	`, "%"+keyword+"%", "%"+keyword+"%", "%"+keyword+"%").Scan(&total)
+// CountSubscriptions returns the number of active subscriptions delivering
+// to callback and the number of active subscriptions to topic, not counting
+// the subscription of callback to topic itself
+func (s *sqlStore) CountSubscriptions(callback, topic string) (byCallback, byTopic int, err error) {
+	now := nowUTC()
+	err = s.queryRow(`
+		SELECT COUNT(*) FROM websub_subscriptions
+		WHERE callback = ? AND topic <> ? AND expires_at > ?
+	`, callback, topic, now).Scan(&byCallback)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = s.queryRow(`
+		SELECT COUNT(*) FROM websub_subscriptions
+		WHERE topic = ? AND callback <> ? AND expires_at > ?
+	`, topic, callback, now).Scan(&byTopic)
+	if err != nil {
+		return 0, 0, err
+	}
+	return byCallback, byTopic, nil
+}
 
-	return articles, total, nil
+// DeleteExpiredSubscriptions removes subscriptions whose lease has expired
+func (s *sqlStore) DeleteExpiredSubscriptions() (int64, error) {
+	result, err := s.exec("DELETE FROM websub_subscriptions WHERE expires_at <= ?", nowUTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
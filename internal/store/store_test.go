@@ -0,0 +1,124 @@
+package store_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wechatoarss/internal/store"
+	"wechatoarss/internal/store/storetest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	s, err := store.OpenSQLite(filepath.Join(t.TempDir(), "wechatoarss.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	checkStore(t, s)
+}
+
+// TestSQLiteStoreLocalTime runs the checks with the process in time zones
+// east and west of UTC, as timestamps are written in local time but
+// stamped by the store in UTC
+func TestSQLiteStoreLocalTime(t *testing.T) {
+	local := time.Local
+	defer func() { time.Local = local }()
+
+	for _, offset := range []int{8, -5} {
+		time.Local = time.FixedZone(fmt.Sprintf("UTC%+d", offset), offset*3600)
+		t.Run(time.Local.String(), func(t *testing.T) {
+			s, err := store.OpenSQLite(filepath.Join(t.TempDir(), "wechatoarss.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			checkStore(t, s)
+		})
+	}
+}
+
+// TestSQLiteDeleteChannelRollback checks that a channel is deleted with
+// all its rows or not at all
+func TestSQLiteDeleteChannelRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wechatoarss.db")
+	s, err := store.OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateChannel("BIZ_A", "Alpha", "", "", "https://example.org", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateGroup("Tech", "tech", "", []string{"BIZ_A"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateArticle("BIZ_A", "Hello", "", "<p>alpha body</p>", "https://example.org/1", "", "", "", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make deleting the articles, the last step, fail
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TRIGGER keep_articles BEFORE DELETE ON articles BEGIN SELECT RAISE(ABORT, 'kept'); END"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteChannel("BIZ_A"); err == nil {
+		t.Fatal("DeleteChannel succeeded although its articles could not be deleted")
+	}
+	if _, err := s.GetChannelByBizID("BIZ_A"); err != nil {
+		t.Errorf("channel deleted without its articles: %v", err)
+	}
+	if g, err := s.GetGroupBySlug("tech"); err != nil || len(g.Channels) != 1 {
+		t.Errorf("group after the failed deletion: %+v, %v", g, err)
+	}
+	if _, total, _ := s.SearchArticles(store.SearchQuery{Query: "alpha", Page: 1, Size: 10}); total != 1 {
+		t.Errorf("search found %d articles after the failed deletion", total)
+	}
+}
+
+// TestPostgresStore runs against the database at WECHATOARSS_TEST_PG_DSN,
+// which must be empty, and leaves the checks' data behind
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("WECHATOARSS_TEST_PG_DSN")
+	if dsn == "" {
+		t.Skip("WECHATOARSS_TEST_PG_DSN is not set")
+	}
+	s, err := store.OpenPostgres(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	checkStore(t, s)
+}
+
+// checkStore migrates s and runs the contract checks against it
+func checkStore(t *testing.T, s store.Store) {
+	t.Helper()
+	if _, err := s.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) > 0 {
+		t.Fatalf("%d migrations still pending", len(pending))
+	}
+	if err := storetest.Check(s); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,801 @@
+// Package storetest checks that a store.Store behaves like the others.
+// Every implementation must pass the same checks.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"wechatoarss/internal/model"
+	"wechatoarss/internal/store"
+)
+
+// Check runs the contract checks against s, whose schema must be up to
+// date, and returns the first failure. s must be empty; the checks leave
+// their data behind, so run them against a scratch database.
+func Check(s store.Store) error {
+	accounts, err := s.GetAccounts()
+	if err != nil {
+		return err
+	}
+	channels, _, err := s.GetChannels(1, 1, "")
+	if err != nil {
+		return err
+	}
+	if len(accounts) > 0 || len(channels) > 0 {
+		return errors.New("storetest: the store is not empty")
+	}
+
+	checks := []struct {
+		name string
+		run  func(store.Store) error
+	}{
+		{"accounts", checkAccounts},
+		{"channels", checkChannels},
+		{"articles", checkArticles},
+		{"article queries", checkQueries},
+		{"search", checkSearch},
+		{"retention", checkRetention},
+		{"revisions", checkRevisions},
+		{"filters", checkFilters},
+		{"groups", checkGroups},
+		{"feed tokens", checkFeedTokens},
+		{"websub", checkSubscriptions},
+		{"channel deletion", checkDeleteChannel},
+	}
+	for _, c := range checks {
+		if err := c.run(s); err != nil {
+			return fmt.Errorf("storetest: %s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+var (
+	day  = 24 * time.Hour
+	base = time.Date(2024, 5, 20, 8, 0, 0, 0, time.UTC)
+)
+
+func checkAccounts(s store.Store) error {
+	a, err := s.CreateAccount("alice", "cookie-a", "token-a")
+	if err != nil {
+		return err
+	}
+	b, err := s.CreateAccount("bob", "cookie-b", "token-b")
+	if err != nil {
+		return err
+	}
+	if a.ID == 0 || a.ID == b.ID {
+		return fmt.Errorf("ids %d and %d", a.ID, b.ID)
+	}
+
+	got, err := s.GetAccountByID(a.ID)
+	if err != nil {
+		return err
+	}
+	if got.Name != "alice" || got.Cookie != "cookie-a" || got.Token != "token-a" || !got.Available || got.NeedCheck {
+		return fmt.Errorf("read back %+v", got)
+	}
+	if got.CreatedAt.IsZero() {
+		return errors.New("created_at not set")
+	}
+
+	if err := s.SetAccountWaitTime(a.ID, time.Now().Add(time.Hour)); err != nil {
+		return err
+	}
+	if err := s.SetAccountNeedCheck(b.ID, true); err != nil {
+		return err
+	}
+	usable, err := s.GetUsableAccounts()
+	if err != nil {
+		return err
+	}
+	if len(usable) != 0 {
+		return fmt.Errorf("%d usable accounts while all are cooling down or flagged", len(usable))
+	}
+
+	if err := s.ResetAccountStatus(a.ID); err != nil {
+		return err
+	}
+	if err := s.UpdateAccount(b.ID, "bob", "cookie-b2", "token-b2", true); err != nil {
+		return err
+	}
+	usable, err = s.GetUsableAccounts()
+	if err != nil {
+		return err
+	}
+	if len(usable) != 2 {
+		return fmt.Errorf("%d usable accounts, want 2", len(usable))
+	}
+	if got, _ := s.GetAccountByID(b.ID); got == nil || got.Cookie != "cookie-b2" || got.NeedCheck {
+		return fmt.Errorf("update not applied: %+v", got)
+	}
+
+	if err := s.TouchAccount(a.ID); err != nil {
+		return err
+	}
+	if err := s.DeleteAccount(b.ID); err != nil {
+		return err
+	}
+	if _, err := s.GetAccountByID(b.ID); err == nil {
+		return errors.New("deleted account still found")
+	}
+	all, err := s.GetAccounts()
+	if err != nil {
+		return err
+	}
+	if len(all) != 1 || all[0].ID != a.ID {
+		return fmt.Errorf("%d accounts after deletion, want 1", len(all))
+	}
+	return nil
+}
+
+func checkChannels(s store.Store) error {
+	ch, err := s.CreateChannel("BIZ_A", "Alpha News", "desc", "avatar", "link", 0)
+	if err != nil {
+		return err
+	}
+	if ch.ID == 0 || ch.Status != "active" {
+		return fmt.Errorf("created %+v", ch)
+	}
+	if _, err := s.CreateChannel("BIZ_A", "Again", "", "", "", 0); err == nil {
+		return errors.New("duplicate biz_id accepted")
+	}
+	if _, err := s.CreateChannel("BIZ_B", "Beta 周刊", "", "", "", 0); err != nil {
+		return err
+	}
+
+	got, err := s.GetChannelByBizID("BIZ_A")
+	if err != nil {
+		return err
+	}
+	if got.Name != "Alpha News" || got.Description != "desc" || got.LastUpdate.IsZero() {
+		return fmt.Errorf("read back %+v", got)
+	}
+	if _, err := s.GetChannelByBizID("BIZ_NONE"); err == nil {
+		return errors.New("unknown channel found")
+	}
+
+	list, total, err := s.GetChannels(1, 10, "")
+	if err != nil {
+		return err
+	}
+	if total != 2 || len(list) != 2 {
+		return fmt.Errorf("listed %d of %d channels, want 2", len(list), total)
+	}
+	// Name searches ignore the case of ASCII letters
+	list, total, err = s.GetChannels(1, 10, "alpha")
+	if err != nil {
+		return err
+	}
+	if total != 1 || len(list) != 1 || list[0].BizID != "BIZ_A" {
+		return fmt.Errorf("search for alpha found %d channels", total)
+	}
+	if _, total, _ = s.GetChannels(1, 10, "周刊"); total != 1 {
+		return fmt.Errorf("search for 周刊 found %d channels", total)
+	}
+
+	if err := s.UpdateChannelStatus("BIZ_B", "paused"); err != nil {
+		return err
+	}
+	active, err := s.GetActiveChannels()
+	if err != nil {
+		return err
+	}
+	if len(active) != 1 || active[0].BizID != "BIZ_A" {
+		return fmt.Errorf("%d active channels, want BIZ_A only", len(active))
+	}
+	if err := s.UpdateChannelStatus("BIZ_B", "active"); err != nil {
+		return err
+	}
+
+	if err := s.UpdateChannelArticleCount("BIZ_A", 7); err != nil {
+		return err
+	}
+	if got, _ := s.GetChannelByBizID("BIZ_A"); got == nil || got.ArticleCount != 7 {
+		return errors.New("article count not updated")
+	}
+	return nil
+}
+
+// seedArticles gives BIZ_A 30 articles, one a day until base, and BIZ_B 5.
+// Publish times are in local time, as sources return them.
+func seedArticles(s store.Store) error {
+	for i := 0; i < 30; i++ {
+		title := fmt.Sprintf("Alpha issue %02d", i)
+		content := fmt.Sprintf("<p>Body of issue %d</p>", i)
+		if i%10 == 0 {
+			title += " Golang"
+			content = "<section><p>关于<b>微服务</b>架构的讨论</p></section>"
+		}
+		a, err := s.CreateArticle("BIZ_A", title, "digest", content,
+			fmt.Sprintf("https://example.com/a/%d", i), "cover", "Alpha Writer", fmt.Sprintf("https://example.org/source/%d", i),
+			base.Add(-time.Duration(i)*day).Local())
+		if err != nil {
+			return err
+		}
+		if a == nil || a.ID == 0 {
+			return fmt.Errorf("article %d not created", i)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		_, err := s.CreateArticle("BIZ_B", fmt.Sprintf("Beta %d", i), "", "<p>beta</p>",
+			fmt.Sprintf("https://example.com/b/%d", i), "", "", "", base.Add(-time.Duration(i)*day-time.Hour).Local())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkArticles(s store.Store) error {
+	if err := seedArticles(s); err != nil {
+		return err
+	}
+
+	dup, err := s.CreateArticle("BIZ_A", "Duplicate", "", "", "https://example.com/a/0", "", "", "", base)
+	if err != nil {
+		return err
+	}
+	if dup != nil {
+		return errors.New("article with a known link created again")
+	}
+
+	articles, total, err := s.GetArticles("BIZ_A", "", "", 1, 1, true)
+	if err != nil {
+		return err
+	}
+	if total != 30 || len(articles) != 1 {
+		return fmt.Errorf("got %d of %d articles, want 1 of 30", len(articles), total)
+	}
+	a := articles[0]
+	if a.Title != "Alpha issue 00 Golang" || !a.PublishedAt.Equal(base) || a.CreatedAt.IsZero() {
+		return fmt.Errorf("newest article is %q published %v", a.Title, a.PublishedAt)
+	}
+	if a.Author != "Alpha Writer" || a.SourceURL != "https://example.org/source/0" {
+		return fmt.Errorf("newest article has author %q and source %q", a.Author, a.SourceURL)
+	}
+
+	got, err := s.GetArticleByID(a.ID)
+	if err != nil {
+		return err
+	}
+	if got.ChannelName != "Alpha News" || got.Content == "" || got.Author != a.Author || got.SourceURL != a.SourceURL || got.Starred || got.Pinned {
+		return fmt.Errorf("read back %+v", got)
+	}
+
+	yes, no := true, false
+	if err := s.SetArticleFlags(a.ID, &yes, nil); err != nil {
+		return err
+	}
+	if got, _ := s.GetArticleByID(a.ID); got == nil || !got.Starred || got.Pinned {
+		return errors.New("star not set")
+	}
+	if err := s.SetArticleFlags(a.ID, &no, &yes); err != nil {
+		return err
+	}
+	if got, _ := s.GetArticleByID(a.ID); got == nil || got.Starred || !got.Pinned {
+		return errors.New("flags not updated")
+	}
+	return s.SetArticleFlags(a.ID, nil, &no)
+}
+
+func checkQueries(s store.Store) error {
+	// Pages are newest first and do not overlap
+	first, total, err := s.QueryArticles(store.ArticleQuery{BizID: "BIZ_A", Page: 1, Size: 10})
+	if err != nil {
+		return err
+	}
+	second, _, err := s.QueryArticles(store.ArticleQuery{BizID: "BIZ_A", Page: 2, Size: 10})
+	if err != nil {
+		return err
+	}
+	if total != 30 || len(first) != 10 || len(second) != 10 {
+		return fmt.Errorf("pages of %d and %d of %d articles", len(first), len(second), total)
+	}
+	if !first[9].PublishedAt.After(second[0].PublishedAt) {
+		return errors.New("pages are not in publication order")
+	}
+
+	_, total, err = s.QueryArticles(store.ArticleQuery{Size: 100})
+	if err != nil {
+		return err
+	}
+	if total != 35 {
+		return fmt.Errorf("%d articles in all, want 35", total)
+	}
+
+	// After includes its day, Before does not
+	_, total, err = s.QueryArticles(store.ArticleQuery{BizID: "BIZ_A", After: "20240510", Before: "20240515", Size: 100})
+	if err != nil {
+		return err
+	}
+	if total != 5 {
+		return fmt.Errorf("%d articles between 20240510 and 20240515, want 5", total)
+	}
+
+	_, total, err = s.QueryArticles(store.ArticleQuery{BizID: "BIZ_A", Month: "2024-04", Size: 100})
+	if err != nil {
+		return err
+	}
+	if total != 10 {
+		return fmt.Errorf("%d articles in 2024-04, want 10", total)
+	}
+	months, err := s.GetArticleMonths(store.ArticleQuery{BizID: "BIZ_A"})
+	if err != nil {
+		return err
+	}
+	if strings.Join(months, ",") != "2024-04,2024-05" {
+		return fmt.Errorf("months %v", months)
+	}
+
+	// Keyword filters ignore ASCII case and apply to their channel only
+	filters := []store.ScopedFilter{{BizID: "BIZ_A", Filter: model.ArticleFilter{Include: []string{"golang"}, Fields: []string{"title"}}}}
+	_, total, err = s.QueryArticles(store.ArticleQuery{Filters: filters, Size: 100})
+	if err != nil {
+		return err
+	}
+	if total != 3+5 {
+		return fmt.Errorf("%d articles with the include filter, want 8", total)
+	}
+	filters = []store.ScopedFilter{{Filter: model.ArticleFilter{Exclude: []string{"微服务", "beta"}}}}
+	_, total, err = s.QueryArticles(store.ArticleQuery{Filters: filters, Size: 100})
+	if err != nil {
+		return err
+	}
+	if total != 27 {
+		return fmt.Errorf("%d articles with the exclude filter, want 27", total)
+	}
+	filters = []store.ScopedFilter{{Filter: model.ArticleFilter{Include: []string{"100%_"}}}}
+	if _, total, _ = s.QueryArticles(store.ArticleQuery{Filters: filters, Size: 100}); total != 0 {
+		return fmt.Errorf("LIKE wildcards in keywords matched %d articles", total)
+	}
+	// Content keywords match its text, not its markup
+	filters = []store.ScopedFilter{{Filter: model.ArticleFilter{Include: []string{"section"}, Fields: []string{"content"}}}}
+	if _, total, _ = s.QueryArticles(store.ArticleQuery{Filters: filters, Size: 100}); total != 0 {
+		return fmt.Errorf("a tag name matched %d articles", total)
+	}
+	filters = []store.ScopedFilter{{Filter: model.ArticleFilter{Include: []string{"body of issue 1"}, Fields: []string{"content"}}}}
+	if _, total, _ = s.QueryArticles(store.ArticleQuery{Filters: filters, Size: 100}); total != 10 {
+		return fmt.Errorf("%d articles with the content filter, want 10", total)
+	}
+	return nil
+}
+
+func checkSearch(s store.Store) error {
+	if _, _, err := s.SearchArticles(store.SearchQuery{Query: "  ", Size: 10}); err != store.ErrEmptySearch {
+		return fmt.Errorf("empty search returned %v", err)
+	}
+
+	// Titles rank above content
+	hits, total, err := s.SearchArticles(store.SearchQuery{Query: "golang", Page: 1, Size: 10})
+	if err != nil {
+		return err
+	}
+	if total != 3 || len(hits) != 3 {
+		return fmt.Errorf("golang found %d articles, want 3", total)
+	}
+	if !strings.Contains(hits[0].TitleHTML, "<mark>Golang</mark>") {
+		return fmt.Errorf("title not highlighted: %q", hits[0].TitleHTML)
+	}
+
+	// Content is searched as text, without its markup
+	hits, total, err = s.SearchArticles(store.SearchQuery{Query: "关于微服务", Page: 1, Size: 10})
+	if err != nil {
+		return err
+	}
+	if total != 3 || !strings.Contains(hits[0].Snippet, "<mark>") || strings.Contains(hits[0].Snippet, "<b>") {
+		return fmt.Errorf("关于微服务 found %d articles, snippet %q", total, firstSnippet(hits))
+	}
+	if _, total, _ = s.SearchArticles(store.SearchQuery{Query: "section", Page: 1, Size: 10}); total != 0 {
+		return fmt.Errorf("markup matched %d articles", total)
+	}
+
+	// Short terms and filters
+	_, total, err = s.SearchArticles(store.SearchQuery{Query: "架构 issue", BizID: "BIZ_A", To: "20240510", Page: 1, Size: 10})
+	if err != nil {
+		return err
+	}
+	if total != 2 {
+		return fmt.Errorf("filtered search found %d articles, want 2", total)
+	}
+	hits, total, err = s.SearchArticles(store.SearchQuery{Query: "issue", Sort: "date", Page: 2, Size: 10})
+	if err != nil {
+		return err
+	}
+	if total != 30 || len(hits) != 10 || !hits[0].PublishedAt.Equal(base.Add(-10*day)) {
+		return fmt.Errorf("second page by date has %d of %d hits", len(hits), total)
+	}
+	return nil
+}
+
+func firstSnippet(hits []model.SearchHit) string {
+	if len(hits) == 0 {
+		return ""
+	}
+	return hits[0].Snippet
+}
+
+func checkRetention(s store.Store) error {
+	articles, _, err := s.GetArticles("BIZ_A", "", "", 30, 1, false)
+	if err != nil || len(articles) != 1 {
+		return fmt.Errorf("oldest article: %v", err)
+	}
+	yes := true
+	if err := s.SetArticleFlags(articles[0].ID, &yes, nil); err != nil {
+		return err
+	}
+
+	n, err := s.PruneArticlesByAge("BIZ_A", base.Add(-20*day), true)
+	if err != nil {
+		return err
+	}
+	if n != 8 {
+		return fmt.Errorf("dry run would prune %d old articles, want 8", n)
+	}
+	// Cutoffs in another time zone than the publish times, a minute either
+	// side of one, must not be shifted by the difference
+	for _, tt := range []struct {
+		cutoff time.Time
+		want   int64
+	}{
+		{base.Add(-20*day + time.Minute).In(time.FixedZone("UTC+8", 8*3600)), 9},
+		{base.Add(-20*day - time.Minute).In(time.FixedZone("UTC-5", -5*3600)), 8},
+	} {
+		if n, err := s.PruneArticlesByAge("BIZ_A", tt.cutoff, true); err != nil || n != tt.want {
+			return fmt.Errorf("dry run with cutoff %v would prune %d old articles, want %d: %v", tt.cutoff, n, tt.want, err)
+		}
+	}
+	// Articles old enough to be pruned by age are not counted again
+	if n, err = s.PruneArticlesByCount("BIZ_A", 10, base.Add(-20*day), true); err != nil || n != 11 {
+		return fmt.Errorf("dry run would prune %d articles beyond 10, want 11: %v", n, err)
+	}
+	if _, total, _ := s.GetArticles("BIZ_A", "", "", 1, 1, false); total != 30 {
+		return errors.New("dry run deleted articles")
+	}
+	if n, err = s.PruneArticlesByAge("BIZ_A", base.Add(-20*day), false); err != nil || n != 8 {
+		return fmt.Errorf("pruned %d old articles, want 8: %v", n, err)
+	}
+	if n, err = s.PruneArticlesByCount("BIZ_A", 10, time.Time{}, false); err != nil || n != 11 {
+		return fmt.Errorf("pruned %d articles beyond 10, want 11: %v", n, err)
+	}
+	if _, total, _ := s.GetArticles("BIZ_A", "", "", 1, 1, false); total != 11 {
+		return fmt.Errorf("%d articles left, want 10 and the starred one", total)
+	}
+	if _, err := s.GetArticleByID(articles[0].ID); err != nil {
+		return errors.New("starred article pruned")
+	}
+
+	// Pruned articles leave the search index
+	hits, total, err := s.SearchArticles(store.SearchQuery{Query: "golang", Page: 1, Size: 10})
+	if err != nil {
+		return err
+	}
+	if total != 1 || len(hits) != 1 {
+		return fmt.Errorf("golang found %d articles after pruning, want 1", len(hits))
+	}
+
+	if err := s.Analyze(); err != nil {
+		return err
+	}
+	_, err = s.Vacuum()
+	return err
+}
+
+func checkRevisions(s store.Store) error {
+	articles, _, err := s.GetArticles("BIZ_A", "", "", 1, 1, true)
+	if err != nil || len(articles) != 1 {
+		return fmt.Errorf("newest article: %v", err)
+	}
+	a, err := s.GetArticleByID(articles[0].ID)
+	if err != nil {
+		return err
+	}
+	if a.Status != model.ArticleStatusPublished || a.ContentHash != store.ContentHash(a.Title, a.Content) {
+		return fmt.Errorf("new article has status %q and hash %q", a.Status, a.ContentHash)
+	}
+
+	due, err := s.GetArticlesToRecheck(base.Add(-2*day), time.Now().Add(time.Hour), 100)
+	if err != nil {
+		return err
+	}
+	if len(due) == 0 || due[0].ID != a.ID || due[0].ContentHash != a.ContentHash {
+		return fmt.Errorf("%d articles due for a re-check, want the newest first", len(due))
+	}
+	if due, err := s.GetArticlesToRecheck(base.Add(time.Minute).In(time.FixedZone("UTC+8", 8*3600)), time.Now().Add(time.Hour), 100); err != nil || len(due) != 0 {
+		return fmt.Errorf("%d articles published after the cutoff due for a re-check: %v", len(due), err)
+	}
+	if due, err := s.GetArticlesToRecheck(base.Add(-time.Minute).In(time.FixedZone("UTC-5", -5*3600)), time.Now().Add(time.Hour), 100); err != nil || len(due) != 1 {
+		return fmt.Errorf("%d articles due for a re-check since a minute before the newest, want 1: %v", len(due), err)
+	}
+
+	if rev, err := s.AddArticleRevision(a.ID, a.Title, a.Content); err != nil || rev != 1 {
+		return fmt.Errorf("unchanged content saved as revision %d: %v", rev, err)
+	}
+	rev, err := s.AddArticleRevision(a.ID, "Edited title", a.Content+"<p>Added line</p>")
+	if err != nil || rev != 2 {
+		return fmt.Errorf("edit saved as revision %d, want 2: %v", rev, err)
+	}
+
+	revisions, err := s.GetArticleRevisions(a.ID)
+	if err != nil {
+		return err
+	}
+	if len(revisions) != 2 || revisions[0].Title != a.Title || revisions[0].Current ||
+		!revisions[1].Current || revisions[1].Title != "Edited title" || revisions[1].Content != "" {
+		return fmt.Errorf("revisions %+v", revisions)
+	}
+	first, err := s.GetArticleRevision(a.ID, 1)
+	if err != nil || first.Content != a.Content || first.ContentHash != a.ContentHash {
+		return fmt.Errorf("first revision does not hold the original: %v", err)
+	}
+	if _, err := s.GetArticleRevision(a.ID, 3); err == nil {
+		return errors.New("found a revision past the current one")
+	}
+
+	got, err := s.GetArticleByID(a.ID)
+	if err != nil {
+		return err
+	}
+	if got.Title != "Edited title" || got.UpdatedAt.IsZero() || got.ContentHash == a.ContentHash {
+		return fmt.Errorf("article not updated to the edit: %+v", got)
+	}
+	hits, total, err := s.SearchArticles(store.SearchQuery{Query: "Edited title added line", Page: 1, Size: 10})
+	if err != nil {
+		return err
+	}
+	if total != 1 || hits[0].ID != a.ID {
+		return fmt.Errorf("edit found in %d articles, want it indexed", total)
+	}
+
+	if err := s.MarkArticleChecked(a.ID, model.ArticleStatusDeleted); err != nil {
+		return err
+	}
+	if got, _ := s.GetArticleByID(a.ID); got == nil || got.Status != model.ArticleStatusDeleted || got.CheckedAt.IsZero() {
+		return errors.New("article not marked deleted")
+	}
+	// Feeds take their Last-Modified from the check time of a status change
+	listed, _, err := s.QueryArticles(store.ArticleQuery{BizID: a.BizID, Page: 1, Size: 100})
+	if err != nil {
+		return err
+	}
+	if i := slices.IndexFunc(listed, func(l model.Article) bool { return l.ID == a.ID }); i < 0 || listed[i].CheckedAt.IsZero() {
+		return errors.New("queried article has no check time")
+	}
+	if due, _ := s.GetArticlesToRecheck(base.Add(-2*day), time.Now().Add(time.Hour), 100); len(due) > 0 && due[0].ID == a.ID {
+		return errors.New("deleted article still due for a re-check")
+	}
+
+	// Removed articles are kept like starred ones
+	n, err := s.PruneArticlesByAge("BIZ_A", base.Add(day), true)
+	if err != nil {
+		return err
+	}
+	if n != 9 {
+		return fmt.Errorf("would prune %d articles, want all 11 but the starred and the deleted one", n)
+	}
+	return nil
+}
+
+func checkFilters(s store.Store) error {
+	if _, err := s.GetChannelFilter("BIZ_A"); err == nil {
+		return errors.New("filter found before it was saved")
+	}
+	f := model.ArticleFilter{Include: []string{"a"}, MinLength: 10}
+	if err := s.SetChannelFilter("BIZ_A", f); err != nil {
+		return err
+	}
+	f.Include = []string{"b"}
+	if err := s.SetChannelFilter("BIZ_A", f); err != nil {
+		return err
+	}
+	got, err := s.GetChannelFilter("BIZ_A")
+	if err != nil {
+		return err
+	}
+	if len(got.Include) != 1 || got.Include[0] != "b" || got.MinLength != 10 {
+		return fmt.Errorf("read back %+v", got)
+	}
+	all, err := s.GetChannelFilters()
+	if err != nil {
+		return err
+	}
+	if len(all) != 1 {
+		return fmt.Errorf("%d saved filters, want 1", len(all))
+	}
+	if err := s.DeleteChannelFilter("BIZ_A"); err != nil {
+		return err
+	}
+	if all, _ = s.GetChannelFilters(); len(all) != 0 {
+		return errors.New("filter not deleted")
+	}
+	return s.SetChannelFilter("BIZ_B", f)
+}
+
+func checkGroups(s store.Store) error {
+	g, err := s.CreateGroup("Tech", "tech", "", []string{"BIZ_B", "BIZ_A", "BIZ_A"})
+	if err != nil {
+		return err
+	}
+	if g.Slug != "tech" || strings.Join(g.Channels, ",") != "BIZ_A,BIZ_B" {
+		return fmt.Errorf("created %+v", g)
+	}
+	if _, err := s.CreateGroup("Again", "tech", "", nil); err == nil {
+		return errors.New("duplicate slug accepted")
+	}
+
+	unnamed, err := s.CreateGroup("无名", "", "", nil)
+	if err != nil {
+		return err
+	}
+	if unnamed.Slug != fmt.Sprintf("group-%d", unnamed.ID) {
+		return fmt.Errorf("derived slug %q", unnamed.Slug)
+	}
+
+	_, total, err := s.QueryArticles(store.ArticleQuery{GroupID: g.ID, Size: 1})
+	if err != nil {
+		return err
+	}
+	if total != 16 {
+		return fmt.Errorf("%d articles in the group, want 16", total)
+	}
+
+	if err := s.UpdateGroup(g.ID, "Tech news", "tech-news", "d", []string{"BIZ_B"}); err != nil {
+		return err
+	}
+	got, err := s.GetGroupBySlug("tech-news")
+	if err != nil {
+		return err
+	}
+	if got.Name != "Tech news" || got.Description != "d" || strings.Join(got.Channels, ",") != "BIZ_B" {
+		return fmt.Errorf("read back %+v", got)
+	}
+	// Nil channels are left unchanged
+	if err := s.UpdateGroup(g.ID, "Tech", "tech", "", nil); err != nil {
+		return err
+	}
+	if got, _ = s.GetGroupByID(g.ID); got == nil || len(got.Channels) != 1 {
+		return errors.New("members changed by an update without channels")
+	}
+
+	groups, err := s.GetGroups()
+	if err != nil {
+		return err
+	}
+	if len(groups) != 2 || groups[0].Name != "Tech" || groups[1].Channels == nil {
+		return fmt.Errorf("listed %+v", groups)
+	}
+	if err := s.DeleteGroup(unnamed.ID); err != nil {
+		return err
+	}
+	if _, err := s.GetGroupByID(unnamed.ID); err == nil {
+		return errors.New("deleted group still found")
+	}
+	return nil
+}
+
+func checkFeedTokens(s store.Store) error {
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	t, err := s.CreateFeedToken("hash-1", "reader", model.TokenScopeChannel, "BIZ_A", expires)
+	if err != nil {
+		return err
+	}
+	if t.Label != "reader" || t.Target != "BIZ_A" || !t.ExpiresAt.Equal(expires) || !t.LastUsedAt.IsZero() {
+		return fmt.Errorf("created %+v", t)
+	}
+	if _, err := s.CreateFeedToken("hash-1", "", model.TokenScopeAll, "", time.Time{}); err == nil {
+		return errors.New("duplicate token hash accepted")
+	}
+	forever, err := s.CreateFeedToken("hash-2", "", model.TokenScopeAll, "", time.Time{})
+	if err != nil {
+		return err
+	}
+	if !forever.ExpiresAt.IsZero() {
+		return errors.New("token without expiry expires")
+	}
+
+	if err := s.TouchFeedToken(t.ID); err != nil {
+		return err
+	}
+	if err := s.RevokeFeedToken(t.ID); err != nil {
+		return err
+	}
+	got, err := s.GetFeedTokenByHash("hash-1")
+	if err != nil {
+		return err
+	}
+	if got.ID != t.ID || got.LastUsedAt.IsZero() || got.RevokedAt.IsZero() {
+		return fmt.Errorf("read back %+v", got)
+	}
+	tokens, err := s.GetFeedTokens()
+	if err != nil {
+		return err
+	}
+	if len(tokens) != 2 || tokens[0].ID != t.ID {
+		return fmt.Errorf("listed %d tokens, want 2", len(tokens))
+	}
+	return nil
+}
+
+func checkSubscriptions(s store.Store) error {
+	if err := s.UpsertSubscription("https://cb/1", "topic", "secret", time.Hour); err != nil {
+		return err
+	}
+	if err := s.UpsertSubscription("https://cb/1", "topic", "other", 2*time.Hour); err != nil {
+		return err
+	}
+	if err := s.UpsertSubscription("https://cb/2", "topic", "", -time.Hour); err != nil {
+		return err
+	}
+	subs, err := s.GetActiveSubscriptions()
+	if err != nil {
+		return err
+	}
+	if len(subs) != 1 || subs[0].Secret != "other" || subs[0].LeaseSeconds != 7200 {
+		return fmt.Errorf("active subscriptions %+v", subs)
+	}
+	for _, cb := range []string{"https://cb/3", "https://cb/4"} {
+		if err := s.UpsertSubscription(cb, "topic", "", time.Hour); err != nil {
+			return err
+		}
+	}
+	if err := s.UpsertSubscription("https://cb/1", "other topic", "", time.Hour); err != nil {
+		return err
+	}
+	byCallback, byTopic, err := s.CountSubscriptions("https://cb/1", "topic")
+	if err != nil {
+		return err
+	}
+	if byCallback != 1 || byTopic != 2 {
+		return fmt.Errorf("counted %d subscriptions of the callback and %d of the topic, want 1 and 2", byCallback, byTopic)
+	}
+	for _, sub := range [][2]string{{"https://cb/3", "topic"}, {"https://cb/4", "topic"}, {"https://cb/1", "other topic"}} {
+		if err := s.DeleteSubscription(sub[0], sub[1]); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.DeleteExpiredSubscriptions()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return fmt.Errorf("deleted %d expired subscriptions, want 1", n)
+	}
+	if err := s.DeleteSubscription("https://cb/1", "topic"); err != nil {
+		return err
+	}
+	if subs, _ = s.GetActiveSubscriptions(); len(subs) != 0 {
+		return errors.New("subscription not deleted")
+	}
+	return nil
+}
+
+func checkDeleteChannel(s store.Store) error {
+	if err := s.DeleteChannel("BIZ_B"); err != nil {
+		return err
+	}
+	if _, err := s.GetChannelByBizID("BIZ_B"); err == nil {
+		return errors.New("deleted channel still found")
+	}
+	if _, total, _ := s.GetArticles("BIZ_B", "", "", 1, 1, false); total != 0 {
+		return fmt.Errorf("%d articles of the deleted channel left", total)
+	}
+	if _, total, _ := s.SearchArticles(store.SearchQuery{Query: "beta", Page: 1, Size: 10}); total != 0 {
+		return fmt.Errorf("search found %d articles of the deleted channel", total)
+	}
+	if _, err := s.GetChannelFilter("BIZ_B"); err == nil {
+		return errors.New("filter of the deleted channel left")
+	}
+	g, err := s.GetGroupBySlug("tech")
+	if err != nil {
+		return err
+	}
+	if len(g.Channels) != 0 {
+		return errors.New("deleted channel still in its group")
+	}
+	return nil
+}